@@ -1,18 +1,103 @@
 package app
 
 import (
+	"context"
 	"encoding/json"
-	chirender "github.com/go-chi/render"
-	"github.com/pkg/errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
 	"net/http"
+	"strconv"
 	"time"
+
+	"github.com/go-chi/chi"
+	chirender "github.com/go-chi/render"
+	"github.com/pkg/errors"
+	"github.com/vdimir/markify/diff"
+	"github.com/vdimir/markify/util"
 )
 
+// CreatePasteRequest describes paste to create. In JSON ttl is given as duration string "ttl": "1h30m"
+// or as number of seconds "ttl_seconds": 5400, paste without ttl doesn't expire
 type CreatePasteRequest struct {
-	Text      string `json:"text"`
-	Syntax    string `json:"syntax"`
-	UserToken string `json:"token,omitempty"`
-	Ttl       time.Duration
+	Text       string        `json:"text"`
+	Syntax     string        `json:"syntax"`
+	UserToken  string        `json:"token,omitempty"`
+	Ttl        time.Duration `json:"-"`                  // decoded from "ttl" or "ttl_seconds" by UnmarshalJSON
+	Burn       bool          `json:"burn"`               // delete paste after first view
+	Password   string        `json:"password,omitempty"` // encrypt paste with key derived from password
+	ForkedFrom string        `json:"forked_from,omitempty"`
+	SourceURL  string        `json:"-"`
+
+	syntaxDetected bool
+}
+
+// UnmarshalJSON decodes request with ttl given either as duration string or as number of seconds
+func (req *CreatePasteRequest) UnmarshalJSON(data []byte) error {
+	type plainRequest CreatePasteRequest
+	if err := json.Unmarshal(data, (*plainRequest)(req)); err != nil {
+		return err
+	}
+	ttl := struct {
+		Duration *string `json:"ttl"`
+		Seconds  *int64  `json:"ttl_seconds"`
+	}{}
+	if err := json.Unmarshal(data, &ttl); err != nil {
+		return errors.New("ttl should be duration string like \"1h30m\" and ttl_seconds should be integer")
+	}
+	switch {
+	case ttl.Duration != nil && ttl.Seconds != nil:
+		return errors.New("only one of ttl and ttl_seconds should be set")
+	case ttl.Duration != nil:
+		d, err := time.ParseDuration(*ttl.Duration)
+		if err != nil {
+			return errors.Errorf("ttl %q should be duration like \"1h30m\"", *ttl.Duration)
+		}
+		req.Ttl = d
+	case ttl.Seconds != nil:
+		if *ttl.Seconds > int64(math.MaxInt64/time.Second) {
+			return errors.New("ttl_seconds is too large")
+		}
+		req.Ttl = time.Duration(*ttl.Seconds) * time.Second
+	}
+	if req.Ttl < 0 {
+		return errors.New("ttl should not be negative")
+	}
+	return nil
+}
+
+// CreatePasteResponse returned by API when paste created
+type CreatePasteResponse struct {
+	ID          string `json:"id"`
+	URL         string `json:"url"`
+	RawURL      string `json:"raw_url"`
+	DeleteToken string `json:"delete_token"`
+}
+
+// PasteResponse contains paste content and metadata
+type PasteResponse struct {
+	ID             string     `json:"id"`
+	Content        string     `json:"content"`
+	Syntax         string     `json:"syntax"`
+	SyntaxDetected bool       `json:"syntax_detected,omitempty"` // syntax was guessed from content
+	CreateTime     time.Time  `json:"create_time"`
+	ExpireTime     *time.Time `json:"expire_time,omitempty"`
+	SourceURL      string     `json:"source_url,omitempty"`
+	Burn           bool       `json:"burn,omitempty"`      // paste is deleted when it is read
+	Protected      bool       `json:"protected,omitempty"` // paste is encrypted with password
+	ForkedFrom     string     `json:"forked_from,omitempty"`
+}
+
+// ErrorResponse returned by API in case of error
+type ErrorResponse struct {
+	Error APIError `json:"error"`
+}
+
+// APIError describes error occurred during API call
+type APIError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
 }
 
 func ParseCreatePasteRequest(r *http.Request) (*CreatePasteRequest, error) {
@@ -23,9 +108,12 @@ func ParseCreatePasteRequest(r *http.Request) (*CreatePasteRequest, error) {
 			token = uidCookie.Value
 		}
 		return &CreatePasteRequest{
-			Text:      r.FormValue("data"),
-			Syntax:    r.FormValue("syntax"),
-			UserToken: token,
+			Text:       r.FormValue("data"),
+			Syntax:     r.FormValue("syntax"),
+			Burn:       r.FormValue("burn") != "",
+			Password:   r.FormValue("password"),
+			ForkedFrom: r.FormValue("forked_from"),
+			UserToken:  token,
 		}, nil
 	}
 
@@ -38,3 +126,193 @@ func ParseCreatePasteRequest(r *http.Request) (*CreatePasteRequest, error) {
 	return nil, errors.Errorf("can't parse create request: unknown content type %q", r.Header.Get("Content-Type"))
 }
 
+// apiRoutes setup routes for versioned JSON API
+func (app *App) apiRoutes(r chi.Router) {
+	r.Post("/pastes", app.handleAPICreatePaste)
+	r.Get("/pastes/{pageID}", app.handleAPIGetPaste)
+	r.Put("/pastes/{pageID}", app.handleAPIUpdatePaste)
+	r.Delete("/pastes/{pageID}", app.handleAPIDeletePaste)
+	r.Get("/diff/{a}/{b}", app.handleAPIDiff)
+	r.NotFound(func(w http.ResponseWriter, r *http.Request) {
+		app.writeAPIError(w, r, http.StatusNotFound, "not found")
+	})
+	r.MethodNotAllowed(func(w http.ResponseWriter, r *http.Request) {
+		app.writeAPIError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+	})
+}
+
+func (app *App) handleAPICreatePaste(w http.ResponseWriter, r *http.Request) {
+	req, err := app.parseAndValidateRequest(r)
+	if err != nil {
+		app.respondAPIError(w, r, err)
+		return
+	}
+	docID, deleteToken, err := app.savePaste(r.Context(), req)
+	if err != nil {
+		app.respondAPIError(w, r, err)
+		return
+	}
+	chirender.Status(r, http.StatusCreated)
+	chirender.JSON(w, r, &CreatePasteResponse{
+		ID:          docID,
+		URL:         absoluteURL(r, fmt.Sprintf("/p/%s", docID)),
+		RawURL:      absoluteURL(r, fmt.Sprintf("/p/%s/text", docID)),
+		DeleteToken: deleteToken,
+	})
+}
+
+func (app *App) handleAPIGetPaste(w http.ResponseWriter, r *http.Request) {
+	pageID := chi.URLParam(r, "pageID")
+	data, meta, err := app.openPaste(r.Context(), pageID, r.Header.Get("X-Paste-Password"), clientAddr(r))
+	if err == util.ErrWrongPassword {
+		app.writeAPIError(w, r, http.StatusForbidden, "wrong password")
+		return
+	}
+	if err == errTooManyAttempts {
+		w.Header().Set("Retry-After", strconv.Itoa(int(passwordAttemptsWindow.Seconds())))
+		app.writeAPIError(w, r, http.StatusTooManyRequests, "too many password attempts")
+		return
+	}
+	if err != nil {
+		app.respondAPIError(w, r, err)
+		return
+	}
+	if data == nil {
+		app.writeAPIError(w, r, http.StatusNotFound, "paste not found")
+		return
+	}
+	defer closeData(data)
+	text, err := ioutil.ReadAll(data)
+	if err != nil {
+		app.respondAPIError(w, r, err)
+		return
+	}
+	chirender.JSON(w, r, newPasteResponse(pageID, string(text), meta))
+}
+
+// newPasteResponse builds response from paste text and its metadata
+func newPasteResponse(docID string, text string, meta map[string]string) *PasteResponse {
+	resp := &PasteResponse{
+		ID:             docID,
+		Content:        text,
+		Syntax:         meta["syntax"],
+		SyntaxDetected: meta["syntax_detected"] == "true",
+		CreateTime:     pasteCreateTime(meta),
+		SourceURL:      meta["source_url"],
+		Burn:           meta["burn"] == "true",
+		Protected:      meta["encryption"] == passwordEncryption,
+		ForkedFrom:     meta["forked_from"],
+	}
+	if expireTime := pasteExpireTime(meta); !expireTime.IsZero() {
+		resp.ExpireTime = &expireTime
+	}
+	return resp
+}
+
+func (app *App) handleAPIUpdatePaste(w http.ResponseWriter, r *http.Request) {
+	pageID := chi.URLParam(r, "pageID")
+	meta, ok := app.loadModifiablePasteMeta(w, r, pageID)
+	if !ok {
+		return
+	}
+	req, err := app.parseAndValidateRequest(r)
+	if err != nil {
+		app.respondAPIError(w, r, err)
+		return
+	}
+	newMeta, err := app.updatePaste(r.Context(), pageID, req, meta)
+	if err != nil {
+		app.respondAPIError(w, r, err)
+		return
+	}
+	if newMeta == nil {
+		app.writeAPIError(w, r, http.StatusNotFound, "paste not found")
+		return
+	}
+	// response isn't read from store, reading burn paste would destroy it
+	chirender.JSON(w, r, newPasteResponse(pageID, req.Text, newMeta))
+}
+
+func (app *App) handleAPIDeletePaste(w http.ResponseWriter, r *http.Request) {
+	pageID := chi.URLParam(r, "pageID")
+	meta, ok := app.loadModifiablePasteMeta(w, r, pageID)
+	if !ok {
+		return
+	}
+	if err := app.deletePaste(r.Context(), pageID, meta); err != nil {
+		app.respondAPIError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleAPIDiff returns changes between two pastes as plain text in unified format
+func (app *App) handleAPIDiff(w http.ResponseWriter, r *http.Request) {
+	sides, err := app.loadDiffSides(r.Context(), chi.URLParam(r, "a"), chi.URLParam(r, "b"))
+	if err != nil {
+		app.respondAPIError(w, r, err)
+		return
+	}
+	if sides == nil {
+		app.writeAPIError(w, r, http.StatusNotFound, "paste not found")
+		return
+	}
+	chirender.PlainText(w, r, diff.Unified(sides[0].Name, sides[1].Name, sides[0].Text, sides[1].Text, diffContextLines))
+}
+
+// loadModifiablePasteMeta returns metadata of paste that request is allowed to modify.
+// Responds with error and returns false otherwise
+func (app *App) loadModifiablePasteMeta(w http.ResponseWriter, r *http.Request, pageID string) (map[string]string, bool) {
+	meta, err := app.loadPasteMeta(r.Context(), pageID)
+	if err != nil {
+		app.respondAPIError(w, r, err)
+		return nil, false
+	}
+	if meta == nil {
+		app.writeAPIError(w, r, http.StatusNotFound, "paste not found")
+		return nil, false
+	}
+	if !app.canModifyPaste(r, meta) {
+		app.writeAPIError(w, r, http.StatusForbidden, "not allowed to modify paste")
+		return nil, false
+	}
+	return meta, true
+}
+
+// respondAPIError responds with message from UserError, with timeout if storage has not responded in time or with internal server error
+func (app *App) respondAPIError(w http.ResponseWriter, r *http.Request, err error) {
+	if errUser, ok := err.(UserError); ok {
+		app.writeAPIError(w, r, http.StatusBadRequest, errUser.String())
+		return
+	}
+	if errors.Is(err, context.Canceled) {
+		log.Printf("[INFO] request cancelled: %v", err)
+		return
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		log.Printf("[WARN] storage timeout: %v", err)
+		app.writeAPIError(w, r, http.StatusGatewayTimeout, "storage timeout")
+		return
+	}
+	log.Printf("[ERROR] %v", err)
+	app.writeAPIError(w, r, http.StatusInternalServerError, "internal server error")
+}
+
+func (app *App) writeAPIError(w http.ResponseWriter, r *http.Request, code int, msg string) {
+	chirender.Status(r, code)
+	chirender.JSON(w, r, &ErrorResponse{APIError{Code: code, Message: msg}})
+}
+
+// absoluteURL returns url to path on host the request was sent to
+func absoluteURL(r *http.Request, path string) string {
+	scheme := "http"
+	if isHTTPS(r) {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s%s", scheme, r.Host, path)
+}
+
+// isHTTPS reports if request is sent over TLS directly or to proxy in front of server
+func isHTTPS(r *http.Request) bool {
+	return r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https"
+}
@@ -1,21 +1,28 @@
 package app
 
 import (
+	"bytes"
+	"context"
+	"crypto/hmac"
 	"embed"
 	"encoding/json"
 	"fmt"
 	"html/template"
 	"io"
 	"io/fs"
+	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
 	"path"
 	"regexp"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 	"unicode/utf8"
 
+	"github.com/vdimir/markify/fetch"
 	"github.com/vdimir/markify/render"
 	"github.com/vdimir/markify/store"
 
@@ -25,6 +32,30 @@ import (
 )
 
 const defaultURLHashLen = 7
+const deleteTokenLen = 20
+const userIDLen = 12
+const timeFormat = "Jan 2 15:04:05 2006 MST"
+
+// errProtectedEdit returned on attempt to edit paste stored encrypted
+var errProtectedEdit = WrapfUserError(errors.New("protected paste edit"), "encrypted paste can't be edited")
+
+// errTooManyAttempts returned when client or paste exceeded limit of password attempts
+var errTooManyAttempts = errors.New("too many password attempts")
+
+// passwordEncryption value of "encryption" metadata field for pastes encrypted with user password
+const passwordEncryption = "password"
+const defaultSweepInterval = time.Minute
+const defaultLinkTimeout = time.Second * 10
+const defaultLinkMaxSize = 1 << 20
+const defaultStorageTimeout = time.Second * 30
+
+// encryptionKeysEnv is environment variable with master keys of "encrypted+" storage,
+// comma separated "<id>:<base64 key>", the first key encrypts new blobs
+const encryptionKeysEnv = "MARKIFY_ENCRYPTION_KEYS"
+
+// encryptionAllowPlaintextEnv is environment variable allowing "encrypted+" storage to read blobs stored without encryption,
+// it's set to "true" while existing storage is migrated to encryption
+const encryptionAllowPlaintextEnv = "MARKIFY_ENCRYPTION_ALLOW_PLAINTEXT"
 
 // Config contains application configuration
 type Config struct {
@@ -36,30 +67,109 @@ type Config struct {
 
 	AdminPassword string
 	UIDSecret     string // secret key to generate user ids
+
+	SweepInterval  time.Duration // how often expired pastes are removed from storage
+	StorageTimeout time.Duration // time limit of storage operations made to handle request, negative to disable
+
+	LinkAllowedHosts []string      // hosts allowed to import documents from, import disabled if empty
+	LinkTimeout      time.Duration // time limit to download document by link
+	LinkMaxSize      int64         // maximal size of document downloaded by link
+
+	RenderCacheSize int64 // maximal size in bytes of rendered documents kept in memory, negative to disable cache
 }
 
 type Store interface {
 	SetBlob(key string, reader io.Reader, meta map[string]string, ttl time.Duration) error
 	GetBlob(key string) (io.Reader, map[string]string, error)
+	// TakeBlob returns blob and deletes it, concurrent calls can't return the same blob twice
+	TakeBlob(key string) (io.Reader, map[string]string, error)
 	DeleteBlob(key string) error
 }
 
+// ExpirySweeper implemented by stores that have to remove expired blobs explicitly
+type ExpirySweeper interface {
+	// DeleteExpired removes up to limit expired blobs, returns number of removed ones
+	DeleteExpired(now time.Time, limit int) (int, error)
+}
+
+// MetaQuerier implemented by stores that can find blobs by metadata without full scan
+type MetaQuerier interface {
+	// QueryBlobs returns not expired blobs matching query, most recently created first
+	QueryBlobs(q store.Query) ([]store.BlobInfo, error)
+}
+
+// KeyLister implemented by stores that can enumerate stored blobs
+type KeyLister interface {
+	// ListKeys returns up to limit keys with prefix following key after in lexicographic order,
+	// empty result means there are no keys left. Listed blob could be already expired
+	ListKeys(prefix string, after string, limit int) ([]string, error)
+}
+
+// MetaReader implemented by stores that can read metadata without data
+type MetaReader interface {
+	// GetMeta returns metadata of blob, nil if blob not found or expired
+	GetMeta(key string) (map[string]string, error)
+}
+
+// ExpiryReader is implemented by stores that report expiration time of blobs
+type ExpiryReader interface {
+	// GetExpireTime returns expiration time of blob, zero time if blob doesn't expire, not found or expired
+	GetExpireTime(key string) (time.Time, error)
+}
+
+// ExtendedStore is Store that can enumerate blobs and read their metadata separately,
+// all stores created by createStorage implement it
+type ExtendedStore interface {
+	Store
+	KeyLister
+	MetaReader
+}
+
 // App provides high level interface to app functions for server
 type App struct {
 	cfg        *Config
 	converter  *render.DocConverter
 	blobStore  Store
+	index      *keyIndex
+	fetcher    fetch.Fetcher
 	uidGen     *util.SignedUIDGenerator
 	staticFs   fs.FS
 	htmlView   view.HTMLPageView
 	httpServer *http.Server
-	Addr       string
+
+	syntaxOptions []view.SyntaxOption
+	Addr          string
+
+	renderCache   *renderCache      // nil if disabled
+	assetVersions map[string]string // fingerprints of static files by name
+	pagesVersion  string            // changes with templates and static files, used in ETags of pages
+
+	passwordAttempts *attemptLimiter // attempts to open pastes protected with password
+
+	sweeperStop chan struct{}
+	sweeperWg   sync.WaitGroup
 }
 
 type Document struct {
 	render.Document
 	DocID      string
 	CreateTime time.Time
+	SourceURL  string
+	EditToken  string // secret to pass to edit and delete actions
+	Editable   bool
+	Burn       bool   // document is deleted after reading, Body is empty until it is opened
+	Protected  bool   // document is encrypted with password, Body is empty until it is opened
+	Ciphertext string // document encrypted in browser, it is not rendered and Body is empty
+	Revision   int    // revision number shown, zero for the latest one
+	Revisions  int    // number of revisions
+	ForkedFrom string // id of paste this one is copied from
+	Forks      []string
+	DataHash   string // hash of stored data, empty if document is not rendered
+
+	Syntax         string
+	SyntaxDetected bool
+
+	meta map[string]string
 }
 
 // NewApp create new App instance
@@ -79,7 +189,13 @@ func NewApp(cfg *Config) (*App, error) {
 			templatePath = "view/template"
 		}
 	}
-	htmlView, err := view.NewView(templatePath)
+	app := &App{cfg: cfg, staticFs: staticFs}
+	if !cfg.Debug {
+		// local assets could be changed while server is running, so they are fingerprinted on request
+		app.assetVersions = assetFingerprints(staticFs, "public")
+	}
+	app.pagesVersion = pagesVersion(app.assetVersions)
+	htmlView, err := view.NewView(templatePath, app.assetURL)
 	if err != nil {
 		return nil, errors.Wrap(err, "error initializing html templates")
 	}
@@ -93,13 +209,44 @@ func NewApp(cfg *Config) (*App, error) {
 	if err != nil {
 		return nil, errors.Wrap(err, "error initializing storage")
 	}
-	app := &App{
-		cfg:       cfg,
-		uidGen:    uidGen,
-		converter: render.NewConverter(),
-		blobStore: blobStore,
-		staticFs:  staticFs,
-		htmlView:  htmlView,
+	app.uidGen = uidGen
+	app.converter = render.NewConverter()
+	app.blobStore = blobStore
+	app.index = newKeyIndex(blobStore)
+	app.passwordAttempts = newAttemptLimiter(passwordAttemptsWindow)
+	app.htmlView = htmlView
+	if cfg.RenderCacheSize >= 0 {
+		cacheSize := cfg.RenderCacheSize
+		if cacheSize == 0 {
+			cacheSize = defaultRenderCacheSize
+		}
+		app.renderCache = newRenderCache(cacheSize)
+	}
+	for _, syntax := range render.CodeSyntaxes() {
+		app.syntaxOptions = append(app.syntaxOptions, view.SyntaxOption{Name: syntax.Name, Label: syntax.Label})
+	}
+
+	if len(cfg.LinkAllowedHosts) > 0 {
+		fetchCfg := fetch.Config{
+			Timeout:      cfg.LinkTimeout,
+			MaxSize:      cfg.LinkMaxSize,
+			AllowedHosts: cfg.LinkAllowedHosts,
+		}
+		if fetchCfg.Timeout <= 0 {
+			fetchCfg.Timeout = defaultLinkTimeout
+		}
+		if fetchCfg.MaxSize <= 0 {
+			fetchCfg.MaxSize = defaultLinkMaxSize
+		}
+		app.fetcher = fetch.NewFetcher(fetchCfg)
+	}
+
+	if sweeper, ok := blobStore.(ExpirySweeper); ok {
+		interval := cfg.SweepInterval
+		if interval <= 0 {
+			interval = defaultSweepInterval
+		}
+		app.startSweeper(sweeper, interval)
 	}
 
 	return app, nil
@@ -107,7 +254,7 @@ func NewApp(cfg *Config) (*App, error) {
 
 var emptyTextRegex = regexp.MustCompile("^\\s*$")
 
-func (app *App) validatePasteRequest(req *CreatePasteRequest) error {
+func (app *App) validatePasteRequest(ctx context.Context, req *CreatePasteRequest) error {
 	if !utf8.ValidString(req.Text) {
 		return errors.New("broken input text")
 
@@ -118,6 +265,23 @@ func (app *App) validatePasteRequest(req *CreatePasteRequest) error {
 	if app.uidGen == nil || !app.uidGen.Validate([]byte(req.UserToken)) {
 		req.UserToken = ""
 	}
+	if req.ForkedFrom != "" {
+		parentMeta, err := app.loadPasteMeta(ctx, req.ForkedFrom)
+		if err != nil {
+			return err
+		}
+		if parentMeta == nil || !isPlainPaste(parentMeta) {
+			// parent may expire while fork is edited
+			req.ForkedFrom = ""
+		}
+	}
+	if req.Syntax == encryptedSyntax {
+		return validateEncryptedPaste(req.Text)
+	}
+	if req.Syntax == "" {
+		req.Syntax = render.DetectSyntax(req.Text)
+		req.syntaxDetected = true
+	}
 	if err := app.converter.SupportSyntax(req.Syntax); err != nil {
 		return err
 	}
@@ -155,69 +319,684 @@ func (app *App) viewDocument(
 	}
 
 	docView := &view.PageContext{
-		Title:  title,
-		Body:   template.HTML(doc.Body),
-		OgInfo: ogInfo,
-		DocID:  doc.DocID,
+		Title:     title,
+		Body:      template.HTML(doc.Body),
+		OgInfo:    ogInfo,
+		DocID:     doc.DocID,
+		SourceURL: doc.SourceURL,
+		Editable:  doc.Editable,
+		EditToken: doc.EditToken,
+
+		Syntax:         doc.Syntax,
+		SyntaxDetected: doc.SyntaxDetected,
+		Ciphertext:     doc.Ciphertext,
+		Revision:       doc.Revision,
+		Revisions:      doc.Revisions,
+		ForkedFrom:     doc.ForkedFrom,
+		Forks:          doc.Forks,
+		Forkable:       doc.meta != nil && isPlainPaste(doc.meta) && doc.Revision == 0,
+	}
+	if doc.Burn {
+		// document is already deleted, so links to it are useless
+		docView.DocID = ""
+		docView.Burned = true
+	}
+	if doc.SyntaxDetected {
+		docView.Syntaxes = app.syntaxOptions
 	}
 	if !doc.CreateTime.IsZero() {
-		docView.CreateTime = doc.CreateTime.Format("Jan 2 15:04:05 2006 MST")
+		docView.CreateTime = doc.CreateTime.Format(timeFormat)
 	}
 	app.viewTemplate(http.StatusOK, docView, w)
 }
 
-// Validate request and save data. Returns id of created paste
-func (app *App) savePaste(req *CreatePasteRequest) (string, error) {
+// Validate request and save data. Returns id of created paste and secret token to modify it
+func (app *App) savePaste(ctx context.Context, req *CreatePasteRequest) (string, string, error) {
 	startTime := time.Now()
 	docID := util.Base58UID(defaultURLHashLen)
+	deleteToken := string(util.Base58UID(deleteTokenLen))
 
 	meta := map[string]string{}
 	if req.UserToken != "" {
 		meta["user"] = req.UserToken
 	}
 	meta["syntax"] = req.Syntax
+	if req.syntaxDetected {
+		meta["syntax_detected"] = "true"
+	}
+	if req.SourceURL != "" {
+		meta["source_url"] = req.SourceURL
+	}
+	if req.Burn {
+		meta["burn"] = "true"
+	}
+	if req.ForkedFrom != "" {
+		meta["forked_from"] = req.ForkedFrom
+	}
+	data := []byte(req.Text)
+	if title := app.converter.Title(data, req.Syntax); title != "" && req.Password == "" && !req.Burn {
+		// title is listed in pastes of user without reading and rendering paste
+		meta["title"] = title
+	}
+	if req.Password == "" {
+		// hash is used to validate cached text without reading it
+		meta["content_hash"] = contentHash(data)
+	}
+	if req.Password != "" {
+		encrypted, err := util.EncryptWithPassword(data, req.Password)
+		if err != nil {
+			return "", "", err
+		}
+		data = encrypted
+		meta["encryption"] = passwordEncryption
+	}
 	timeStr, err := time.Now().UTC().MarshalText()
 	if err != nil {
-		return "", err
+		return "", "", err
 	}
 	meta["create_time"] = string(timeStr)
 	meta["ttl"] = req.Ttl.String()
-	err = app.blobStore.SetBlob(string(docID), strings.NewReader(req.Text), meta, req.Ttl)
+	meta["delete_token"] = util.TokenHash(deleteToken)
+	err = app.blobs(ctx).SetBlob(string(docID), bytes.NewReader(data), meta, req.Ttl)
 	if err != nil {
 		log.Printf("[TRACE] document %q not saved after %dms, error: %s", docID, time.Since(startTime).Milliseconds(), err)
-	} else {
-		log.Printf("[TRACE] document %q saved in %dms", docID, time.Since(startTime).Milliseconds())
+		return "", "", err
+	}
+	log.Printf("[TRACE] document %q saved in %dms", docID, time.Since(startTime).Milliseconds())
+	// paste is saved, so indexes are updated even if request is cancelled
+	ctx, cancel := app.cleanupContext()
+	defer cancel()
+	entry := newIndexEntry(string(docID), meta)
+	if req.UserToken != "" {
+		if err = app.index.Add(ctx, userIndexName(req.UserToken), entry, userIndexMeta(meta)); err != nil {
+			log.Printf("[ERROR] document %q not added to user index: %s", docID, err)
+		}
+	}
+	if req.ForkedFrom != "" {
+		if err = app.index.Add(ctx, forksIndexName(req.ForkedFrom), entry, nil); err != nil {
+			log.Printf("[ERROR] document %q not added to forks index: %s", docID, err)
+		}
+		app.invalidateDocument(req.ForkedFrom)
+	}
+	return string(docID), deleteToken, nil
+}
+
+// deletePaste removes paste and references to it
+func (app *App) deletePaste(ctx context.Context, docID string, meta map[string]string) error {
+	if err := app.blobs(ctx).DeleteBlob(docID); err != nil {
+		return err
+	}
+	app.invalidateDocument(docID)
+	log.Printf("[INFO] document %q deleted", docID)
+	app.cleanupPaste(docID, meta)
+	return nil
+}
+
+// cleanupPaste removes revisions and index entries of deleted paste,
+// they are removed even if request is cancelled, so they are not left without paste
+func (app *App) cleanupPaste(docID string, meta map[string]string) {
+	ctx, cancel := app.cleanupContext()
+	defer cancel()
+	app.deleteRevisions(ctx, docID, meta)
+	app.unindexPaste(ctx, docID, meta)
+}
+
+// blobs returns store with operations bound to ctx, they are cancelled when request is done
+func (app *App) blobs(ctx context.Context) Store {
+	return store.WithContext(ctx, app.blobStore)
+}
+
+// cleanupContext returns context of operations done after the primary one succeeded,
+// it's not bound to request and limited by storage timeout
+func (app *App) cleanupContext() (context.Context, context.CancelFunc) {
+	timeout := app.storageTimeout()
+	if timeout < 0 {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithTimeout(context.Background(), timeout)
+}
+
+// storageTimeout returns time limit of storage operations, negative if they are not limited
+func (app *App) storageTimeout() time.Duration {
+	if app.cfg.StorageTimeout == 0 {
+		return defaultStorageTimeout
+	}
+	return app.cfg.StorageTimeout
+}
+
+// userPastes returns page of pastes created by user following entry named after, most recent first,
+// and name of the last entry to continue from, empty if there are no pastes left.
+// Entries contain metadata with title of paste
+func (app *App) userPastes(ctx context.Context, userToken string, after string) ([]indexEntry, string, error) {
+	if querier, ok := app.blobStore.(MetaQuerier); ok {
+		entries, next, err := app.queryUserPastes(querier, userToken, after)
+		// decorators implement query and return error if store they wrap can't do it
+		if !errors.Is(err, store.ErrQueryNotSupported) {
+			return entries, next, err
+		}
+	}
+	entries, next, err := app.index.List(ctx, userIndexName(userToken), after, indexPageSize)
+	if err != nil {
+		return nil, "", err
+	}
+	entries, err = app.index.LoadMeta(ctx, userIndexName(userToken), entries)
+	return entries, next, err
+}
+
+// queryUserPastes finds pastes of user with store query instead of index
+func (app *App) queryUserPastes(querier MetaQuerier, userToken string, after string) ([]indexEntry, string, error) {
+	q := store.Query{User: userToken, Limit: indexPageSize}
+	if after != "" {
+		afterEntry, err := parseIndexEntry(after)
+		if err != nil {
+			return nil, "", err
+		}
+		q.CreatedBefore = afterEntry.CreateTime
+	}
+	blobs, err := querier.QueryBlobs(q)
+	if err != nil {
+		return nil, "", err
+	}
+	entries := make([]indexEntry, 0, len(blobs))
+	for _, blob := range blobs {
+		if strings.Contains(blob.Key, "/") {
+			// previous revisions have the same metadata
+			continue
+		}
+		entry := newIndexEntry(blob.Key, blob.Meta)
+		entry.Meta = userIndexMeta(blob.Meta)
+		entries = append(entries, entry)
+	}
+	next := ""
+	if len(blobs) == q.Limit {
+		// revisions have the same creation time as paste, which is listed before them
+		last := blobs[len(blobs)-1]
+		next = newIndexEntry(strings.SplitN(last.Key, "/", 2)[0], last.Meta).name()
+	}
+	return entries, next, nil
+}
+
+// userIndexMeta returns metadata of entry in user index
+func userIndexMeta(meta map[string]string) map[string]string {
+	res := map[string]string{}
+	if meta["title"] != "" {
+		res["title"] = meta["title"]
+	}
+	return res
+}
+
+// userIndexName name of index with pastes of user
+func userIndexName(userToken string) string {
+	return "user/" + util.TokenHash(userToken)
+}
+
+func forksIndexName(docID string) string {
+	return "forks/" + docID
+}
+
+// unindexPaste removes deleted paste from user and forks indexes and removes index of its forks
+func (app *App) unindexPaste(ctx context.Context, docID string, meta map[string]string) {
+	if err := app.index.RemoveAll(ctx, forksIndexName(docID)); err != nil {
+		log.Printf("[ERROR] forks index of document %q not removed: %s", docID, err)
+	}
+	entry := newIndexEntry(docID, meta)
+	if meta["user"] != "" {
+		if err := app.index.Remove(ctx, userIndexName(meta["user"]), entry); err != nil {
+			log.Printf("[ERROR] document %q not removed from user index: %s", docID, err)
+		}
+	}
+	if meta["forked_from"] != "" {
+		if err := app.index.Remove(ctx, forksIndexName(meta["forked_from"]), entry); err != nil {
+			log.Printf("[ERROR] document %q not removed from forks index: %s", docID, err)
+		}
+		app.invalidateDocument(meta["forked_from"])
+	}
+}
+
+// pasteForks returns not expired pastes forked from docID, most recent first.
+// Index is only read, entries of deleted forks are removed on deletion and expired ones are skipped
+func (app *App) pasteForks(ctx context.Context, docID string) ([]indexEntry, error) {
+	entries, _, err := app.index.List(ctx, forksIndexName(docID), "", indexPageSize)
+	return entries, err
+}
+
+// updatePaste replaces text and syntax of existing paste keeping its metadata and expiration time.
+// Returns metadata of updated paste or nil if paste not found
+func (app *App) updatePaste(ctx context.Context, docID string, req *CreatePasteRequest, meta map[string]string) (map[string]string, error) {
+	if isEncryptedPaste(meta) {
+		return nil, errProtectedEdit
+	}
+	var ttl time.Duration
+	if expireTime := pasteExpireTime(meta); !expireTime.IsZero() {
+		ttl = time.Until(expireTime)
+		if ttl <= 0 {
+			return nil, nil
+		}
+	}
+	newMeta := map[string]string{}
+	for k, v := range meta {
+		newMeta[k] = v
+	}
+	newMeta["syntax"] = req.Syntax
+	delete(newMeta, "syntax_detected")
+	if req.syntaxDetected {
+		newMeta["syntax_detected"] = "true"
+	}
+	newMeta["content_hash"] = contentHash([]byte(req.Text))
+	delete(newMeta, "title")
+	if title := app.converter.Title([]byte(req.Text), req.Syntax); title != "" && meta["burn"] != "true" {
+		newMeta["title"] = title
+	}
+	timeStr, err := time.Now().UTC().MarshalText()
+	if err != nil {
+		return nil, err
+	}
+	newMeta["update_time"] = string(timeStr)
+	if err = app.saveRevision(ctx, docID, meta, ttl); err != nil {
+		return nil, err
+	}
+	newMeta["revision"] = strconv.Itoa(pasteRevision(meta) + 1)
+	if err = app.blobs(ctx).SetBlob(docID, strings.NewReader(req.Text), newMeta, ttl); err != nil {
+		return nil, err
+	}
+	app.invalidateDocument(docID)
+	log.Printf("[TRACE] document %q updated", docID)
+	if meta["user"] != "" && newMeta["title"] != meta["title"] {
+		ctx, cancel := app.cleanupContext()
+		defer cancel()
+		err = app.index.Add(ctx, userIndexName(meta["user"]), newIndexEntry(docID, newMeta), userIndexMeta(newMeta))
+		if err != nil {
+			log.Printf("[ERROR] title of document %q not updated in user index: %s", docID, err)
+		}
+	}
+	return newMeta, nil
+}
+
+// loadPasteMeta returns paste metadata or nil if paste not found, data is not read if store supports it
+func (app *App) loadPasteMeta(ctx context.Context, docID string) (map[string]string, error) {
+	if metaReader, ok := app.blobs(ctx).(MetaReader); ok {
+		meta, err := metaReader.GetMeta(docID)
+		return meta, errors.Wrapf(err, "can't get metadata")
+	}
+	data, meta, err := app.blobs(ctx).GetBlob(docID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't get data")
+	}
+	if data == nil {
+		return nil, nil
 	}
-	return string(docID), err
+	closeData(data)
+	if meta == nil {
+		meta = map[string]string{}
+	}
+	return meta, nil
 }
 
-func (app *App) getDocument(docID string) (*Document, error) {
+// canModifyPaste checks that request is sent by paste owner or contains paste secret token
+func (app *App) canModifyPaste(r *http.Request, meta map[string]string) bool {
+	if token := pasteTokenFromRequest(r); token != "" && meta["delete_token"] != "" {
+		if hmac.Equal([]byte(util.TokenHash(token)), []byte(meta["delete_token"])) {
+			return true
+		}
+	}
+	if app.uidGen == nil || meta["user"] == "" {
+		return false
+	}
+	uidCookie, err := r.Cookie("user_id")
+	if err != nil || !app.uidGen.Validate([]byte(uidCookie.Value)) {
+		return false
+	}
+	return uidCookie.Value == meta["user"]
+}
+
+// pasteTokenFromRequest returns paste secret token passed in header or body of form.
+// Token is not accepted in query, so it's not kept in browser history, logs and Referer of urls
+func pasteTokenFromRequest(r *http.Request) string {
+	if token := r.Header.Get("X-Paste-Token"); token != "" {
+		return token
+	}
+	return r.PostFormValue("token")
+}
+
+// pasteTokenURL returns url of paste with secret token in fragment, it's read by paste-token.js and never sent to server
+func pasteTokenURL(docID string, token string) string {
+	return fmt.Sprintf("/p/%s#token=%s", docID, token)
+}
+
+func (app *App) getDocument(ctx context.Context, docID string) (*Document, error) {
+	return app.getDocumentAs(ctx, docID, "")
+}
+
+// getDocumentAs loads document and renders it with syntax instead of stored one if syntax is not empty
+func (app *App) getDocumentAs(ctx context.Context, docID string, syntax string) (*Document, error) {
+	var cacheGen uint64
+	if app.renderCache != nil {
+		var doc *Document
+		if doc, cacheGen = app.renderCache.Get(docID, syntax); doc != nil {
+			return doc, nil
+		}
+	}
+
 	startTime := time.Now()
 	log.Printf("[TRACE] loading document %q", docID)
-	data, meta, err := app.blobStore.GetBlob(docID)
+	data, meta, err := app.blobs(ctx).GetBlob(docID)
 	if err != nil {
 		return nil, errors.Wrapf(err, "can't get data")
 	}
 	if data == nil {
 		return nil, nil
 	}
+	defer closeData(data)
 
-	rdoc, err := app.converter.Convert(data, meta["syntax"])
+	if meta["burn"] == "true" || meta["encryption"] == passwordEncryption {
+		return &Document{
+			DocID:      docID,
+			CreateTime: pasteCreateTime(meta),
+			Burn:       meta["burn"] == "true",
+			Protected:  meta["encryption"] == passwordEncryption,
+			meta:       meta,
+		}, nil
+	}
+	doc, err := app.renderDocument(docID, data, meta, syntax, startTime)
 	if err != nil {
 		return nil, err
 	}
-	createTime := time.Time{}
-	err = createTime.UnmarshalText([]byte(meta["create_time"]))
+	if !isPlainPaste(meta) {
+		return doc, nil
+	}
+	// forks are cached with document, it's invalidated when fork is created or deleted
+	forks, err := app.pasteForks(ctx, docID)
 	if err != nil {
-		log.Printf("[ERROR] can't parse time from metadata: %q: %s", meta["create_time"], err.Error())
+		return nil, err
+	}
+	expireTime := pasteExpireTime(meta)
+	for _, fork := range forks {
+		doc.Forks = append(doc.Forks, fork.DocID)
+		if !fork.ExpireTime.IsZero() && (expireTime.IsZero() || fork.ExpireTime.Before(expireTime)) {
+			expireTime = fork.ExpireTime
+		}
+	}
+	if app.renderCache != nil {
+		app.renderCache.Put(docID, syntax, doc, expireTime, cacheGen)
+	}
+	return doc, nil
+}
+
+// invalidateDocument removes rendered document from cache after it is changed or deleted
+func (app *App) invalidateDocument(docID string) {
+	if app.renderCache != nil {
+		app.renderCache.Invalidate(docID)
+	}
+}
+
+// openDocument loads document protected with password or deleted after reading and renders it.
+// Returns util.ErrWrongPassword if password doesn't match
+func (app *App) openDocument(ctx context.Context, docID string, password string, client string) (*Document, error) {
+	startTime := time.Now()
+	data, meta, err := app.openPaste(ctx, docID, password, client)
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		return nil, nil
+	}
+	defer closeData(data)
+	doc, err := app.renderDocument(docID, data, meta, "", startTime)
+	if err != nil {
+		return nil, err
+	}
+	doc.Burn = meta["burn"] == "true"
+	return doc, nil
+}
+
+// openPaste returns paste data decrypting it with password if required.
+// Paste that should be deleted after reading is deleted.
+// Returns util.ErrWrongPassword if password doesn't match and errTooManyAttempts
+// if client or paste exceeded limit of password attempts, password is not checked then
+func (app *App) openPaste(ctx context.Context, docID string, password string, client string) (io.Reader, map[string]string, error) {
+	data, meta, err := app.blobs(ctx).GetBlob(docID)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "can't get data")
+	}
+	if data == nil {
+		return nil, nil, nil
+	}
+	if meta["encryption"] == passwordEncryption {
+		if !app.allowPasswordAttempt(client, docID) {
+			closeData(data)
+			return nil, nil, errTooManyAttempts
+		}
+		// check password before paste is deleted
+		plaintext, err := decryptPaste(data, password)
+		closeData(data)
+		if err != nil {
+			return nil, nil, err
+		}
+		data = plaintext
+	}
+	if meta["burn"] != "true" {
+		return data, meta, nil
+	}
+	closeData(data)
+
+	data, meta, err = app.takePaste(ctx, docID)
+	if err != nil || data == nil {
+		return nil, nil, err
+	}
+	if meta["encryption"] == passwordEncryption {
+		plaintext, err := decryptPaste(data, password)
+		closeData(data)
+		if err != nil {
+			return nil, nil, err
+		}
+		data = plaintext
+	}
+	return data, meta, nil
+}
+
+// takePaste returns paste data and deletes it
+func (app *App) takePaste(ctx context.Context, docID string) (io.Reader, map[string]string, error) {
+	data, meta, err := app.blobs(ctx).TakeBlob(docID)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "can't take data")
+	}
+	if data == nil {
+		return nil, nil, nil
+	}
+	app.invalidateDocument(docID)
+	log.Printf("[INFO] document %q deleted after reading", docID)
+	app.cleanupPaste(docID, meta)
+	return data, meta, nil
+}
+
+// closeData closes reader of blob data if store returned closable one, e.g. object downloaded from S3
+func closeData(data io.Reader) {
+	if closer, ok := data.(io.Closer); ok {
+		_ = closer.Close()
+	}
+}
+
+func decryptPaste(data io.Reader, password string) (io.Reader, error) {
+	encrypted, err := ioutil.ReadAll(data)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := util.DecryptWithPassword(encrypted, password)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(plaintext), nil
+}
+
+// renderDocument converts data to html with syntax or with syntax stored in meta if empty
+func (app *App) renderDocument(docID string, data io.Reader, meta map[string]string, syntax string, startTime time.Time) (*Document, error) {
+	if meta["syntax"] == encryptedSyntax {
+		return app.encryptedDocument(docID, data, meta)
+	}
+	if syntax == "" {
+		syntax = meta["syntax"]
+	}
+	text, err := ioutil.ReadAll(data)
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't read data")
+	}
+	rdoc, err := app.converter.Convert(bytes.NewReader(text), syntax)
+	if err != nil {
+		return nil, err
+	}
+	createTime := pasteCreateTime(meta)
+	doc := &Document{
+		Document:   *rdoc,
+		DocID:      docID,
+		CreateTime: createTime,
+		SourceURL:  meta["source_url"],
+		meta:       meta,
+
+		Syntax:         syntax,
+		SyntaxDetected: meta["syntax_detected"] == "true",
+		Revisions:      pasteRevision(meta),
+		ForkedFrom:     meta["forked_from"],
+		DataHash:       contentHash(text),
 	}
-	doc := &Document{Document: *rdoc, DocID: docID, CreateTime: createTime}
 
 	log.Printf("[TRACE] document %q loaded and rendered in %dms", docID, time.Since(startTime).Milliseconds())
 	return doc, nil
 }
 
+// pasteCreateTime returns time when paste was created
+func pasteCreateTime(meta map[string]string) time.Time {
+	createTime := time.Time{}
+	err := createTime.UnmarshalText([]byte(meta["create_time"]))
+	if err != nil {
+		log.Printf("[ERROR] can't parse time from metadata: %q: %s", meta["create_time"], err.Error())
+	}
+	return createTime
+}
+
+// pasteExpireTime returns time when paste expires or zero time if paste has no ttl
+func pasteExpireTime(meta map[string]string) time.Time {
+	ttl, err := time.ParseDuration(meta["ttl"])
+	if err != nil || ttl <= 0 {
+		return time.Time{}
+	}
+	return pasteCreateTime(meta).Add(ttl)
+}
+
+// encryptedDocument returns document encrypted in browser without rendering
+func (app *App) encryptedDocument(docID string, data io.Reader, meta map[string]string) (*Document, error) {
+	ciphertext, err := ioutil.ReadAll(data)
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't read data")
+	}
+	return &Document{
+		DocID:      docID,
+		CreateTime: pasteCreateTime(meta),
+		Ciphertext: string(ciphertext),
+		Syntax:     encryptedSyntax,
+		DataHash:   contentHash(ciphertext),
+		meta:       meta,
+	}, nil
+}
+
+// fetchDocument downloads text by url
+func (app *App) fetchDocument(docURL string) (string, error) {
+	startTime := time.Now()
+	body, err := app.fetcher.Fetch(docURL)
+	if err != nil {
+		return "", WrapfUserError(err, "can't fetch document: %s", err.Error())
+	}
+	defer body.Close()
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		return "", WrapfUserError(err, "can't fetch document: %s", err.Error())
+	}
+	log.Printf("[TRACE] document %q fetched in %dms", docURL, time.Since(startTime).Milliseconds())
+	return string(data), nil
+}
+
+// storageDecorators are prefixes of storage specification wrapping storage into decorator
+var storageDecorators = []string{"dedup", "encrypted", store.CodecGzip, store.CodecZstd}
+
+// splitStorageSpec returns decorators of storage specification in order of prefixes and type of wrapped storage
+func splitStorageSpec(storageSpec string) ([]string, string) {
+	var decorators []string
+	for {
+		found := false
+		for _, decorator := range storageDecorators {
+			if spec := strings.TrimPrefix(storageSpec, decorator+"+"); spec != storageSpec {
+				decorators = append(decorators, decorator)
+				storageSpec, found = spec, true
+			}
+		}
+		if !found {
+			return decorators, strings.SplitN(storageSpec, ":", 2)[0]
+		}
+	}
+}
+
+func hasDecorator(decorators []string, decorator string) bool {
+	for _, d := range decorators {
+		if d == decorator {
+			return true
+		}
+	}
+	return false
+}
+
+// createStorage opens storage by specification, "encrypted+" storage reads blobs stored without encryption
+// only if it's allowed by environment variable
 func createStorage(storageSpec string) (Store, error) {
+	return openStorage(storageSpec, os.Getenv(encryptionAllowPlaintextEnv) == "true")
+}
+
+// openStorage opens storage by specification, allowPlaintext is passed to "encrypted+" storage
+func openStorage(storageSpec string, allowPlaintext bool) (Store, error) {
+	if decorators, _ := splitStorageSpec(storageSpec); hasDecorator(decorators, "dedup") && hasDecorator(decorators, "encrypted") {
+		// dedup over encrypted storage keeps hashes of plain data in keys and
+		// encrypted data under dedup is never identical, so the combination is useless
+		return nil, errors.New("dedup storage can't be combined with encryption")
+	}
+	if spec := strings.TrimPrefix(storageSpec, "dedup+"); spec != storageSpec {
+		// references are counted under locks within process, S3 bucket is shared by instances of service
+		if _, storageType := splitStorageSpec(spec); storageType == "s3" {
+			return nil, errors.New("dedup storage can't be used with s3, it can't be shared by several instances")
+		}
+		inner, err := openStorage(spec, allowPlaintext)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("[INFO] identical blobs are stored once")
+		return store.NewDedup(inner, indexKeyPrefix), nil
+	}
+	if spec := strings.TrimPrefix(storageSpec, "encrypted+"); spec != storageSpec {
+		keysEnv := os.Getenv(encryptionKeysEnv)
+		if keysEnv == "" {
+			return nil, errors.Errorf("encryption keys should be set in %s", encryptionKeysEnv)
+		}
+		keys, err := store.ParseEncryptionKeys(keysEnv)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error parse %s", encryptionKeysEnv)
+		}
+		inner, err := openStorage(spec, allowPlaintext)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("[INFO] blobs are encrypted with key %q", keys[0].ID)
+		if allowPlaintext {
+			log.Printf("[WARN] blobs stored without encryption are read as is")
+		}
+		return store.NewEncrypted(inner, keys, allowPlaintext)
+	}
+	for _, codec := range []string{store.CodecGzip, store.CodecZstd} {
+		if spec := strings.TrimPrefix(storageSpec, codec+"+"); spec != storageSpec {
+			inner, err := openStorage(spec, allowPlaintext)
+			if err != nil {
+				return nil, err
+			}
+			log.Printf("[INFO] blobs are compressed with %s", codec)
+			return store.NewCompress(inner, codec)
+		}
+	}
 	typeAndOptions := strings.SplitN(storageSpec, ":", 2)
 	if len(typeAndOptions) != 2 {
 		return nil, errors.Errorf("error parse storage specification %q", storageSpec)
@@ -236,6 +1015,26 @@ func createStorage(storageSpec string) (Store, error) {
 		log.Printf("[INFO] using s3 storage, endpoint %q, bucket %q", s3conf.Endpoint, s3conf.Bucket)
 		return store.NewS3Storage(s3conf)
 	}
+	if typeAndOptions[0] == "sqlite" {
+		log.Printf("[INFO] creating sqlite storage, database file %q", typeAndOptions[1])
+		return store.NewSqliteStorage(typeAndOptions[1])
+	}
+	if typeAndOptions[0] == "fs" {
+		log.Printf("[INFO] creating file system storage in %q", typeAndOptions[1])
+		return store.NewFSStorage(typeAndOptions[1])
+	}
+	if typeAndOptions[0] == "memory" {
+		// optional limit of total size of blobs in bytes, "memory:" for unlimited storage
+		var maxBytes int64
+		if typeAndOptions[1] != "" {
+			var err error
+			if maxBytes, err = strconv.ParseInt(typeAndOptions[1], 10, 64); err != nil || maxBytes < 0 {
+				return nil, errors.Errorf("error parse memory storage size %q", typeAndOptions[1])
+			}
+		}
+		log.Printf("[INFO] using memory storage, max size %d bytes", maxBytes)
+		return store.NewMemoryStorage(maxBytes), nil
+	}
 	return nil, errors.Errorf("unknown storage type %q", typeAndOptions[0])
 }
 
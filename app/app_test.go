@@ -1,30 +1,42 @@
 package app
 
 import (
+	"context"
+	"encoding/base64"
 	"fmt"
+	"io/ioutil"
+	"os"
 	"path"
 	"regexp"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/vdimir/markify/store"
+	"github.com/vdimir/markify/store/storetest"
 	"github.com/vdimir/markify/testutil"
 )
 
 const testDataPath = "../testdata"
 
 func createNewTestApp(t *testing.T) (tapp *App, teardown func()) {
-	tmpPath, tmpFolderClean := testutil.GetTempFolder(t, "test_app")
+	return createTestAppWithStorage(t, "memory:")
+}
 
+func createTestAppWithStorage(t *testing.T, storageSpec string) (tapp *App, teardown func()) {
 	tapp, err := NewApp(&Config{
 		Debug:        false,
 		AssetsPrefix: "assets",
-		StorageSpec:  fmt.Sprintf("local:%s", tmpPath),
+		StorageSpec:  storageSpec,
 		StatusText:   `{"status": "ok"}`,
 	})
 	assert.NoError(t, err)
 
-	return tapp, tmpFolderClean
+	return tapp, func() {
+		defer tapp.Shutdown()
+	}
 }
 
 func checkURLHash(t *testing.T, urlHash []byte) {
@@ -42,10 +54,10 @@ func TestNewMarkdownPage(t *testing.T) {
 	require.NotNil(tapp)
 
 	mdData := testutil.MustReadData(t, path.Join(testDataPath, "page.md"))
-	key, err := tapp.savePaste(&CreatePasteRequest{Text: string(mdData), Syntax: "markdown"})
+	key, _, err := tapp.savePaste(context.Background(), &CreatePasteRequest{Text: string(mdData), Syntax: "markdown"})
 	assert.NoError(err)
 	{
-		doc, err := tapp.getDocument(key)
+		doc, err := tapp.getDocument(context.Background(), key)
 		require.NoError(err)
 		require.NotNil(doc)
 		assert.Regexp(regexp.MustCompile("<h1[a-z\"= ]*>Header</h1>"), doc.Body)
@@ -54,8 +66,321 @@ func TestNewMarkdownPage(t *testing.T) {
 	}
 	{
 		unexistingKey := "__deadbeef__"
-		doc, err := tapp.getDocument(unexistingKey)
+		doc, err := tapp.getDocument(context.Background(), unexistingKey)
 		require.NoError(err)
 		require.Nil(doc)
 	}
 }
+
+func TestPasteTTL(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	tapp, teardown := createNewTestApp(t)
+	defer teardown()
+	require.NotNil(tapp)
+
+	key, _, err := tapp.savePaste(context.Background(), &CreatePasteRequest{Text: "foo", Ttl: time.Millisecond * 10})
+	require.NoError(err)
+	keepKey, _, err := tapp.savePaste(context.Background(), &CreatePasteRequest{Text: "bar"})
+	require.NoError(err)
+
+	doc, err := tapp.getDocument(context.Background(), key)
+	require.NoError(err)
+	assert.NotNil(doc)
+
+	time.Sleep(time.Millisecond * 20)
+
+	doc, err = tapp.getDocument(context.Background(), key)
+	require.NoError(err)
+	assert.Nil(doc)
+
+	sweeper, ok := tapp.blobStore.(ExpirySweeper)
+	require.True(ok)
+	tapp.sweepExpired(sweeper)
+	n, err := sweeper.DeleteExpired(time.Now(), sweepBatchSize)
+	require.NoError(err)
+	assert.Equal(0, n)
+
+	doc, err = tapp.getDocument(context.Background(), keepKey)
+	require.NoError(err)
+	assert.NotNil(doc)
+}
+
+func TestCodePage(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	tapp, teardown := createNewTestApp(t)
+	defer teardown()
+
+	req := &CreatePasteRequest{Text: "SELECT * FROM pastes;", Syntax: "sql"}
+	require.NoError(tapp.validatePasteRequest(context.Background(), req))
+	key, _, err := tapp.savePaste(context.Background(), req)
+	require.NoError(err)
+
+	doc, err := tapp.getDocument(context.Background(), key)
+	require.NoError(err)
+	require.NotNil(doc)
+	assert.Contains(doc.Body, "<span")
+	assert.Contains(doc.Body, "SELECT")
+
+	assert.Error(tapp.validatePasteRequest(context.Background(), &CreatePasteRequest{Text: "foo", Syntax: "unknown_syntax"}))
+}
+
+func TestDetectPasteSyntax(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	tapp, teardown := createNewTestApp(t)
+	defer teardown()
+
+	req := &CreatePasteRequest{Text: "# Header\n\n* item 1\n* item 2\n"}
+	require.NoError(tapp.validatePasteRequest(context.Background(), req))
+	assert.Equal("markdown", req.Syntax)
+	key, _, err := tapp.savePaste(context.Background(), req)
+	require.NoError(err)
+
+	doc, err := tapp.getDocument(context.Background(), key)
+	require.NoError(err)
+	require.NotNil(doc)
+	assert.Equal("markdown", doc.Syntax)
+	assert.True(doc.SyntaxDetected)
+	assert.Regexp(regexp.MustCompile("<h1[a-z\"= ]*>Header</h1>"), doc.Body)
+
+	doc, err = tapp.getDocumentAs(context.Background(), key, "text")
+	require.NoError(err)
+	require.NotNil(doc)
+	assert.Contains(doc.Body, "# Header")
+	assert.True(doc.SyntaxDetected)
+
+	req = &CreatePasteRequest{Text: "# Header", Syntax: "text"}
+	require.NoError(tapp.validatePasteRequest(context.Background(), req))
+	key, _, err = tapp.savePaste(context.Background(), req)
+	require.NoError(err)
+	doc, err = tapp.getDocument(context.Background(), key)
+	require.NoError(err)
+	assert.Equal("<pre><code># Header</code></pre>", doc.Body)
+	assert.False(doc.SyntaxDetected)
+}
+
+func TestCreateStorage(t *testing.T) {
+	s, err := createStorage("memory:")
+	require.NoError(t, err)
+	assert.IsType(t, &store.Memory{}, s)
+
+	s, err = createStorage("memory:1048576")
+	require.NoError(t, err)
+	assert.IsType(t, &store.Memory{}, s)
+
+	_, err = createStorage("memory:1MB")
+	assert.Error(t, err)
+
+	dir := t.TempDir()
+	for _, spec := range []string{"memory:", "local:" + dir, "fs:" + dir + "/fs", "sqlite:" + dir + "/db", "dedup+memory:", "zstd+dedup+memory:"} {
+		s, err = createStorage(spec)
+		require.NoError(t, err)
+		assert.Implements(t, (*ExtendedStore)(nil), s, spec)
+		closeStorage(s)
+	}
+	assert.Implements(t, (*ExtendedStore)(nil), &store.S3Storage{})
+
+	s, err = createStorage("dedup+memory:")
+	require.NoError(t, err)
+	assert.IsType(t, &store.Dedup{}, s)
+	_, err = createStorage("dedup+memory:1MB")
+	assert.Error(t, err)
+	for _, spec := range []string{"dedup+s3:{}", "dedup+gzip+s3:{}"} {
+		_, err = createStorage(spec)
+		assert.EqualError(t, err, "dedup storage can't be used with s3, it can't be shared by several instances", spec)
+	}
+
+	s, err = createStorage("gzip+memory:")
+	require.NoError(t, err)
+	assert.IsType(t, &store.Compress{}, s)
+	s, err = createStorage("zstd+local:" + dir)
+	require.NoError(t, err)
+	assert.IsType(t, &store.Compress{}, s)
+	closeStorage(s)
+}
+
+func TestCreateEncryptedStorage(t *testing.T) {
+	defer os.Setenv(encryptionKeysEnv, os.Getenv(encryptionKeysEnv))
+
+	require.NoError(t, os.Unsetenv(encryptionKeysEnv))
+	_, err := createStorage("encrypted+memory:")
+	assert.Error(t, err)
+	require.NoError(t, os.Setenv(encryptionKeysEnv, "k1:short"))
+	_, err = createStorage("encrypted+memory:")
+	assert.Error(t, err)
+
+	key := base64.StdEncoding.EncodeToString([]byte(strings.Repeat("k", 32)))
+	require.NoError(t, os.Setenv(encryptionKeysEnv, "k2:"+key+",k1:"+key))
+	s, err := createStorage("zstd+encrypted+memory:")
+	require.NoError(t, err)
+	assert.Implements(t, (*ExtendedStore)(nil), s)
+	for _, spec := range []string{"zstd+encrypted+dedup+memory:", "dedup+encrypted+memory:", "dedup+gzip+encrypted+memory:"} {
+		_, err = createStorage(spec)
+		assert.EqualError(t, err, "dedup storage can't be combined with encryption", spec)
+	}
+	s, err = createStorage("encrypted+memory:")
+	require.NoError(t, err)
+	assert.IsType(t, &store.Encrypted{}, s)
+
+	tapp, teardown := createNewTestApp(t)
+	defer teardown()
+	tapp.blobStore = s
+	key, _, err = tapp.savePaste(context.Background(), &CreatePasteRequest{Text: "secret text"})
+	require.NoError(t, err)
+	doc, err := tapp.getDocument(context.Background(), key)
+	require.NoError(t, err)
+	require.NotNil(t, doc)
+	assert.Contains(t, doc.Body, "secret text")
+}
+
+func TestLoadPasteMeta(t *testing.T) {
+	tapp, teardown := createNewTestApp(t)
+	defer teardown()
+
+	for _, blobStore := range []Store{tapp.blobStore, storetest.NewMemStore()} {
+		tapp.blobStore = blobStore
+		require.NoError(t, blobStore.SetBlob("paste", strings.NewReader("text"), map[string]string{"syntax": "go"}, 0))
+		require.NoError(t, blobStore.SetBlob("no_meta", strings.NewReader("text"), nil, 0))
+
+		meta, err := tapp.loadPasteMeta(context.Background(), "paste")
+		require.NoError(t, err)
+		assert.Equal(t, map[string]string{"syntax": "go"}, meta)
+
+		meta, err = tapp.loadPasteMeta(context.Background(), "no_meta")
+		require.NoError(t, err)
+		assert.NotNil(t, meta)
+
+		meta, err = tapp.loadPasteMeta(context.Background(), "missing")
+		require.NoError(t, err)
+		assert.Nil(t, meta)
+	}
+}
+
+// cancelOnDelete cancels context of request after blob with key is deleted
+type cancelOnDelete struct {
+	Store
+	key    string
+	cancel context.CancelFunc
+}
+
+func (s *cancelOnDelete) DeleteBlob(key string) error {
+	err := s.Store.DeleteBlob(key)
+	if key == s.key {
+		s.cancel()
+	}
+	return err
+}
+
+func TestDeletePasteCleanup(t *testing.T) {
+	tapp, teardown := createNewTestApp(t)
+	defer teardown()
+
+	docID, _, err := tapp.savePaste(context.Background(), &CreatePasteRequest{Text: "v1", Syntax: "text", UserToken: "user"})
+	require.NoError(t, err)
+	meta, err := tapp.loadPasteMeta(context.Background(), docID)
+	require.NoError(t, err)
+	newMeta, err := tapp.updatePaste(context.Background(), docID, &CreatePasteRequest{Text: "v2", Syntax: "text"}, meta)
+	require.NoError(t, err)
+	require.NotNil(t, newMeta)
+	meta, err = tapp.loadPasteMeta(context.Background(), docID)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	blobStore := tapp.blobStore
+	tapp.blobStore = &cancelOnDelete{Store: blobStore, key: docID, cancel: cancel}
+	require.NoError(t, tapp.deletePaste(ctx, docID, meta))
+	assert.Error(t, ctx.Err(), "request is cancelled after paste is deleted")
+	storetest.RequireMissing(t, blobStore, docID)
+	storetest.RequireMissing(t, blobStore, revisionKey(docID, 1))
+	entries, _, err := tapp.index.List(context.Background(), userIndexName("user"), "", indexPageSize)
+	require.NoError(t, err)
+	assert.Empty(t, entries, "paste is removed from index")
+}
+
+func TestUserPastesPages(t *testing.T) {
+	for _, storageSpec := range []string{
+		"memory:", "sqlite:" + path.Join(t.TempDir(), "data.db"),
+		"gzip+memory:", "dedup+zstd+sqlite:" + path.Join(t.TempDir(), "dedup.db"),
+	} {
+		t.Run(strings.Split(storageSpec, ":")[0], func(t *testing.T) {
+			tapp, teardown := createTestAppWithStorage(t, storageSpec)
+			defer teardown()
+			defer closeStorage(tapp.blobStore)
+
+			var ids []string
+			for i := 0; i < indexPageSize+5; i++ {
+				req := &CreatePasteRequest{Text: fmt.Sprintf("# Paste %d\n\ntext", i), Syntax: "markdown", UserToken: "user"}
+				if i%2 == 1 {
+					req.Ttl = time.Hour
+				}
+				docID, _, err := tapp.savePaste(context.Background(), req)
+				require.NoError(t, err)
+				ids = append([]string{docID}, ids...)
+			}
+			_, _, err := tapp.savePaste(context.Background(), &CreatePasteRequest{Text: "# Other", Syntax: "markdown", UserToken: "other"})
+			require.NoError(t, err)
+
+			meta, err := tapp.loadPasteMeta(context.Background(), ids[0])
+			require.NoError(t, err)
+			assert.Equal(t, fmt.Sprintf("Paste %d", indexPageSize+4), meta["title"])
+			newMeta, err := tapp.updatePaste(context.Background(), ids[0], &CreatePasteRequest{Text: "# Edited", Syntax: "markdown"}, meta)
+			require.NoError(t, err)
+			require.NotNil(t, newMeta)
+
+			entries, next, err := tapp.userPastes(context.Background(), "user", "")
+			require.NoError(t, err)
+			require.NotEmpty(t, next)
+			assert.Equal(t, "Edited", entries[0].Meta["title"], "title is updated on edit")
+			last, next, err := tapp.userPastes(context.Background(), "user", next)
+			require.NoError(t, err)
+			assert.Empty(t, next)
+			entries = append(entries, last...)
+
+			var listed []string
+			for _, entry := range entries {
+				listed = append(listed, entry.DocID)
+			}
+			assert.Equal(t, ids, listed, "all pastes of user are listed once, most recent first")
+			assert.Equal(t, "Paste 0", entries[len(entries)-1].Meta["title"])
+			assert.True(t, entries[0].ExpireTime.IsZero())
+			assert.WithinDuration(t, time.Now().Add(time.Hour), entries[1].ExpireTime, time.Minute)
+		})
+	}
+}
+
+func TestDedupIndexEntries(t *testing.T) {
+	tapp, teardown := createTestAppWithStorage(t, "dedup+memory:")
+	defer teardown()
+	inner := store.NewMemoryStorage(0)
+	tapp.blobStore = store.NewDedup(inner, indexKeyPrefix)
+	tapp.index = newKeyIndex(tapp.blobStore)
+
+	const pastes = 300
+	for i := 0; i < pastes; i++ {
+		text := fmt.Sprintf("paste %d\n%s", i, strings.Repeat("line\n", 100))
+		_, _, err := tapp.savePaste(context.Background(), &CreatePasteRequest{Text: text, Syntax: "text", UserToken: "user"})
+		require.NoError(t, err)
+	}
+	entries, next, err := tapp.index.List(context.Background(), userIndexName("user"), "", pastes+1)
+	require.NoError(t, err)
+	assert.Len(t, entries, pastes)
+	assert.Empty(t, next)
+
+	// index entries are stored directly and don't share list of references of empty content
+	refs, err := inner.ListKeys("_dedup/refs/", "", pastes*2)
+	require.NoError(t, err)
+	assert.Len(t, refs, pastes, "only pastes are deduplicated")
+	for _, ref := range refs {
+		data, _, err := inner.GetBlob(ref)
+		require.NoError(t, err)
+		content, err := ioutil.ReadAll(data)
+		require.NoError(t, err)
+		assert.Less(t, len(content), 200, "list of references has single paste")
+	}
+}
@@ -0,0 +1,544 @@
+// Client-side encryption of pastes.
+// Paste is encrypted with AES-256-GCM using random key, the key is stored only in url fragment
+// that is never sent to server. Server stores paste as JSON {"v": 2, "iv": base64, "ct": base64}
+// with "encrypted" syntax and returns it as is.
+// Plaintext of version 2 is JSON {"syntax": string, "text": string}, plaintext of version 1 is text of paste.
+// Decrypted paste is rendered in browser by its syntax. Rendered content is built from DOM nodes
+// with text set by textContent, so html in paste is never interpreted and only safe urls are linked.
+(function () {
+    "use strict";
+
+    var formatText = 1;
+    var formatDocument = 2;
+
+    function toBase64(bytes) {
+        var s = "";
+        for (var i = 0; i < bytes.length; i++) {
+            s += String.fromCharCode(bytes[i]);
+        }
+        return btoa(s);
+    }
+
+    function fromBase64(s) {
+        var raw = atob(s);
+        var bytes = new Uint8Array(raw.length);
+        for (var i = 0; i < raw.length; i++) {
+            bytes[i] = raw.charCodeAt(i);
+        }
+        return bytes;
+    }
+
+    function toBase64URL(bytes) {
+        return toBase64(bytes).replace(/\+/g, "-").replace(/\//g, "_").replace(/=+$/, "");
+    }
+
+    function fromBase64URL(s) {
+        s = s.replace(/-/g, "+").replace(/_/g, "/");
+        while (s.length % 4) {
+            s += "=";
+        }
+        return fromBase64(s);
+    }
+
+    function encrypt(text, syntax) {
+        var rawKey = crypto.getRandomValues(new Uint8Array(32));
+        var iv = crypto.getRandomValues(new Uint8Array(12));
+        var plaintext = JSON.stringify({syntax: syntax, text: text});
+        return crypto.subtle.importKey("raw", rawKey, "AES-GCM", false, ["encrypt"]).then(function (key) {
+            return crypto.subtle.encrypt({name: "AES-GCM", iv: iv}, key, new TextEncoder().encode(plaintext));
+        }).then(function (ct) {
+            return {
+                key: toBase64URL(rawKey),
+                data: JSON.stringify({v: formatDocument, iv: toBase64(iv), ct: toBase64(new Uint8Array(ct))})
+            };
+        });
+    }
+
+    // decrypt returns promise of decrypted paste {syntax, text}
+    function decrypt(data, keyStr) {
+        var paste = JSON.parse(data);
+        if (paste.v !== formatText && paste.v !== formatDocument) {
+            return Promise.reject(new Error("unsupported paste version"));
+        }
+        return crypto.subtle.importKey("raw", fromBase64URL(keyStr), "AES-GCM", false, ["decrypt"]).then(function (key) {
+            return crypto.subtle.decrypt({name: "AES-GCM", iv: fromBase64(paste.iv)}, key, fromBase64(paste.ct));
+        }).then(function (plaintext) {
+            var text = new TextDecoder().decode(plaintext);
+            if (paste.v === formatText) {
+                return {syntax: "text", text: text};
+            }
+            var doc = JSON.parse(text);
+            return {syntax: String(doc.syntax || ""), text: String(doc.text || "")};
+        });
+    }
+
+    function el(tag, className, text) {
+        var node = document.createElement(tag);
+        if (className) {
+            node.className = className;
+        }
+        if (text !== undefined) {
+            node.textContent = text;
+        }
+        return node;
+    }
+
+    // safeURL returns url if it's http, https, mailto or relative url, null otherwise.
+    // Scripts can't be run by "javascript:" or "data:" urls in rendered paste
+    function safeURL(url) {
+        url = url.replace(/[\u0000-\u0020\u007f]/g, "");
+        var scheme = /^([a-z][a-z0-9+.-]*):/i.exec(url);
+        if (scheme && !/^(https?|mailto)$/i.test(scheme[1])) {
+            return null;
+        }
+        return url;
+    }
+
+    // renderText shows text as is
+    function renderText(text, container) {
+        var pre = el("pre");
+        pre.appendChild(el("code", "", text));
+        container.appendChild(pre);
+    }
+
+    // Code is highlighted by generic rules of comments, strings, numbers and keywords common for many languages
+    var hashComments = ["bash", "sh", "shell", "zsh", "python", "python3", "ruby", "perl", "r", "yaml", "toml",
+        "dockerfile", "makefile", "cmake", "powershell", "elixir", "nim", "ini", "nginx"];
+    var dashComments = ["sql", "mysql", "postgresql", "plpgsql", "lua", "haskell", "ada"];
+    var codeKeywords = ["and", "as", "async", "await", "break", "case", "catch", "class", "const", "continue",
+        "def", "default", "defer", "do", "elif", "else", "enum", "except", "export", "extends", "false", "False",
+        "finally", "fn", "for", "from", "func", "function", "go", "if", "impl", "import", "in", "interface", "is",
+        "let", "match", "mod", "new", "nil", "None", "not", "null", "or", "package", "private", "protected",
+        "pub", "public", "raise", "return", "select", "self", "static", "struct", "switch", "this", "throw",
+        "true", "True", "try", "type", "use", "var", "void", "while", "with", "yield"];
+    var sqlKeywords = ["select", "from", "where", "insert", "into", "values", "update", "set", "delete", "create",
+        "table", "index", "view", "alter", "drop", "join", "left", "right", "inner", "outer", "on", "group", "order",
+        "by", "having", "limit", "and", "or", "not", "null", "as", "distinct", "union", "primary", "key"];
+
+    // codeTokenRegexp returns regexp matching comment, string, number or keyword in groups 1-4
+    function codeTokenRegexp(syntax) {
+        var comment = "\\/\\/[^\\n]*|\\/\\*[\\s\\S]*?\\*\\/";
+        var keywords = codeKeywords;
+        var flags = "g";
+        if (hashComments.indexOf(syntax) >= 0) {
+            comment = "#[^\\n]*";
+        } else if (dashComments.indexOf(syntax) >= 0) {
+            comment = "--[^\\n]*|\\/\\*[\\s\\S]*?\\*\\/";
+            keywords = keywords.concat(sqlKeywords);
+            flags = "gi";
+        }
+        var str = "\"(?:[^\"\\\\\\n]|\\\\.)*\"|'(?:[^'\\\\\\n]|\\\\.)*'|`[^`]*`";
+        var num = "\\b\\d+(?:\\.\\d+)?\\b";
+        var kw = "\\b(?:" + keywords.join("|") + ")\\b";
+        return new RegExp("(" + comment + ")|(" + str + ")|(" + num + ")|(" + kw + ")", flags);
+    }
+
+    // highlightCode appends highlighted text of code to node
+    function highlightCode(text, syntax, node) {
+        var re = codeTokenRegexp(syntax.toLowerCase());
+        var classes = ["", "hl-comment", "hl-string", "hl-number", "hl-keyword"];
+        var pos = 0;
+        var m;
+        while ((m = re.exec(text)) !== null) {
+            if (m[0].length === 0) {
+                re.lastIndex++;
+                continue;
+            }
+            node.appendChild(document.createTextNode(text.slice(pos, m.index)));
+            for (var i = 1; i < classes.length; i++) {
+                if (m[i] !== undefined) {
+                    node.appendChild(el("span", classes[i], m[0]));
+                    break;
+                }
+            }
+            pos = m.index + m[0].length;
+        }
+        node.appendChild(document.createTextNode(text.slice(pos)));
+    }
+
+    // renderCode shows code with line numbers and highlighting
+    function renderCode(text, syntax, container) {
+        var pre = el("pre", "encrypted-code");
+        var numbers = el("span", "line-numbers");
+        var lines = text.replace(/\n$/, "").split("\n");
+        for (var i = 1; i <= lines.length; i++) {
+            numbers.appendChild(el("span", "", i + "\n"));
+        }
+        var code = el("code", "language-" + syntax);
+        highlightCode(text, syntax, code);
+        pre.appendChild(numbers);
+        pre.appendChild(code);
+        container.appendChild(pre);
+    }
+
+    // Inline markdown: escapes, code spans, images, links, autolinks, strong, emphasis and strikethrough
+    var inlineRules = [
+        {re: /^\\([!-\/:-@\[-`{-~])/, render: function (m) {
+            return document.createTextNode(m[1]);
+        }},
+        {re: /^(`+)([^`]|[^`][\s\S]*?[^`])\1(?!`)/, render: function (m) {
+            return el("code", "", m[2].trim());
+        }},
+        {re: /^!\[([^\]]*)\]\(\s*<?((?:[^\s<>()]|\([^\s<>()]*\))*)>?(?:\s+"([^"]*)")?\s*\)/, render: function (m) {
+            var url = safeURL(m[2]);
+            if (!url || /^mailto:/i.test(url)) {
+                return document.createTextNode(m[1]);
+            }
+            var img = el("img");
+            img.src = url;
+            img.alt = m[1];
+            img.referrerPolicy = "no-referrer";
+            if (m[3]) {
+                img.title = m[3];
+            }
+            return img;
+        }},
+        {re: /^\[((?:[^\[\]]|\[[^\]]*\])*)\]\(\s*<?((?:[^\s<>()]|\([^\s<>()]*\))*)>?(?:\s+"([^"]*)")?\s*\)/, render: function (m) {
+            return renderLink(m[2], m[1], m[3]);
+        }},
+        {re: /^<((?:https?:\/\/|mailto:)[^\s<>]+)>/, render: function (m) {
+            return renderLink(m[1], null);
+        }},
+        {re: /^(\*\*|__)(?=\S)([\s\S]*?\S)\1/, render: function (m) {
+            return renderInline(m[2], el("strong"));
+        }},
+        {re: /^(\*|_)(?=\S)([\s\S]*?\S)\1(?!\w)/, render: function (m) {
+            return renderInline(m[2], el("em"));
+        }},
+        {re: /^~~(?=\S)([\s\S]*?\S)~~/, render: function (m) {
+            return renderInline(m[1], el("del"));
+        }}
+    ];
+
+    function renderLink(href, label, title) {
+        var url = safeURL(href);
+        var node = url ? el("a") : el("span");
+        if (url) {
+            node.href = url;
+            node.rel = "nofollow noopener noreferrer";
+            if (title) {
+                node.title = title;
+            }
+        }
+        if (label === null) {
+            node.textContent = href;
+        } else {
+            renderInline(label, node);
+        }
+        return node;
+    }
+
+    // renderInline appends rendered inline markdown of text to node and returns the node
+    function renderInline(text, node) {
+        var plain = "";
+        var pos = 0;
+        while (pos < text.length) {
+            var rest = text.slice(pos);
+            var matched = null;
+            // underscore inside of word doesn't start emphasis, e.g. snake_case
+            var inWord = pos > 0 && /\w/.test(text[pos - 1]);
+            for (var i = 0; i < inlineRules.length && !matched; i++) {
+                var m = inlineRules[i].re.exec(rest);
+                if (m && !(inWord && m[0][0] === "_")) {
+                    matched = {node: inlineRules[i].render(m), length: m[0].length};
+                }
+            }
+            if (matched) {
+                node.appendChild(document.createTextNode(plain));
+                node.appendChild(matched.node);
+                plain = "";
+                pos += matched.length;
+                continue;
+            }
+            var plainRun = /^[^\\`!\[<*_~]+/.exec(rest);
+            var length = plainRun ? plainRun[0].length : 1;
+            plain += rest.slice(0, length);
+            pos += length;
+        }
+        node.appendChild(document.createTextNode(plain));
+        return node;
+    }
+
+    var blockRules = {
+        fence: /^ {0,3}(`{3,}|~{3,})\s*([\w+#.-]*)/,
+        heading: /^ {0,3}(#{1,6})(?:\s+(.*?))?(?:\s+#+)?\s*$/,
+        rule: /^ {0,3}([-*_])(?:\s*\1){2,}\s*$/,
+        quote: /^ {0,3}> ?/,
+        list: /^( *)([-*+]|\d{1,9}[.)])(\s+|$)/,
+        indented: /^(?: {4}|\t)/,
+        setext: /^ {0,3}(=+|-+)\s*$/,
+        tableDelimiter: /^\s*\|?\s*:?-+:?\s*(\|\s*:?-+:?\s*)*\|?\s*$/
+    };
+
+    function isBlockStart(line) {
+        return blockRules.fence.test(line) || blockRules.heading.test(line) || blockRules.rule.test(line) ||
+            blockRules.quote.test(line) || blockRules.list.test(line);
+    }
+
+    function splitTableRow(line) {
+        return line.trim().replace(/^\|/, "").replace(/\|$/, "").split("|").map(function (cell) {
+            return cell.trim();
+        });
+    }
+
+    function renderTable(lines, i, parent) {
+        var aligns = splitTableRow(lines[i + 1]).map(function (cell) {
+            if (/^:-+:$/.test(cell)) {
+                return "center";
+            }
+            return /-:$/.test(cell) ? "right" : (/^:-/.test(cell) ? "left" : "");
+        });
+        var table = el("table");
+        var addRow = function (section, cells, tag) {
+            var tr = el("tr");
+            for (var c = 0; c < aligns.length; c++) {
+                var cell = renderInline(cells[c] || "", el(tag));
+                if (aligns[c]) {
+                    cell.style.textAlign = aligns[c];
+                }
+                tr.appendChild(cell);
+            }
+            section.appendChild(tr);
+        };
+        var thead = el("thead");
+        addRow(thead, splitTableRow(lines[i]), "th");
+        table.appendChild(thead);
+        var tbody = el("tbody");
+        for (i += 2; i < lines.length && lines[i].trim() !== "" && lines[i].indexOf("|") >= 0; i++) {
+            addRow(tbody, splitTableRow(lines[i]), "td");
+        }
+        table.appendChild(tbody);
+        parent.appendChild(table);
+        return i;
+    }
+
+    // renderList renders list starting at line i and returns index of line after it
+    function renderList(lines, i, parent) {
+        var first = blockRules.list.exec(lines[i]);
+        var ordered = /\d/.test(first[2]);
+        var list = el(ordered ? "ol" : "ul");
+        if (ordered && parseInt(first[2], 10) !== 1) {
+            list.start = parseInt(first[2], 10);
+        }
+        var indent = first[1].length;
+        while (i < lines.length) {
+            var m = blockRules.list.exec(lines[i]);
+            if (!m || m[1].length !== indent || /\d/.test(m[2]) !== ordered) {
+                break;
+            }
+            var contentIndent = m[0].length;
+            var itemLines = [lines[i].slice(contentIndent)];
+            for (i++; i < lines.length; i++) {
+                var line = lines[i];
+                var lineIndent = /^ */.exec(line)[0].length;
+                if (line.trim() === "") {
+                    var next = lines[i + 1];
+                    if (next === undefined || /^ */.exec(next)[0].length < contentIndent) {
+                        break;
+                    }
+                    itemLines.push("");
+                } else if (lineIndent >= contentIndent) {
+                    itemLines.push(line.slice(contentIndent));
+                } else if (lineIndent <= indent && (blockRules.list.test(line) || isBlockStart(line))) {
+                    break;
+                } else {
+                    itemLines.push(line.trim());
+                }
+            }
+            var item = el("li");
+            renderBlocks(itemLines, item, true);
+            list.appendChild(item);
+            if (i < lines.length && lines[i].trim() === "" && blockRules.list.test(lines[i + 1] || "")) {
+                i++;
+            }
+        }
+        parent.appendChild(list);
+        return i;
+    }
+
+    // renderBlocks appends rendered markdown blocks of lines to parent.
+    // Single paragraph of tight list item is rendered without <p>
+    function renderBlocks(lines, parent, tight) {
+        var paragraphs = [];
+        var i = 0;
+        var m;
+        while (i < lines.length) {
+            var line = lines[i];
+            if (line.trim() === "") {
+                i++;
+            } else if ((m = blockRules.fence.exec(line))) {
+                var fence = m[1];
+                var code = [];
+                for (i++; i < lines.length && lines[i].trim().indexOf(fence) !== 0; i++) {
+                    code.push(lines[i]);
+                }
+                i++;
+                var pre = el("pre");
+                var codeNode = el("code");
+                if (m[2]) {
+                    codeNode.className = "language-" + m[2];
+                    highlightCode(code.join("\n"), m[2], codeNode);
+                } else {
+                    codeNode.textContent = code.join("\n");
+                }
+                pre.appendChild(codeNode);
+                parent.appendChild(pre);
+            } else if ((m = blockRules.heading.exec(line))) {
+                parent.appendChild(renderInline(m[2] || "", el("h" + m[1].length)));
+                i++;
+            } else if (blockRules.rule.test(line)) {
+                parent.appendChild(el("hr"));
+                i++;
+            } else if (blockRules.quote.test(line)) {
+                var quoted = [];
+                for (; i < lines.length && lines[i].trim() !== ""; i++) {
+                    quoted.push(lines[i].replace(blockRules.quote, ""));
+                }
+                var quote = el("blockquote");
+                renderBlocks(quoted, quote, false);
+                parent.appendChild(quote);
+            } else if (blockRules.list.test(line)) {
+                i = renderList(lines, i, parent);
+            } else if (blockRules.indented.test(line)) {
+                var indented = [];
+                for (; i < lines.length && (blockRules.indented.test(lines[i]) || lines[i].trim() === ""); i++) {
+                    indented.push(lines[i].replace(blockRules.indented, ""));
+                }
+                renderText(indented.join("\n").replace(/\n+$/, ""), parent);
+            } else if (line.indexOf("|") >= 0 && i + 1 < lines.length && blockRules.tableDelimiter.test(lines[i + 1]) &&
+                lines[i + 1].indexOf("-") >= 0) {
+                i = renderTable(lines, i, parent);
+            } else {
+                var text = [line.trim()];
+                var tag = "p";
+                for (i++; i < lines.length && lines[i].trim() !== ""; i++) {
+                    if ((m = blockRules.setext.exec(lines[i]))) {
+                        tag = m[1][0] === "=" ? "h1" : "h2";
+                        i++;
+                        break;
+                    }
+                    if (isBlockStart(lines[i])) {
+                        break;
+                    }
+                    text.push(lines[i].trim());
+                }
+                var node = renderInline(text.join("\n"), el(tag));
+                paragraphs.push(node);
+                parent.appendChild(node);
+            }
+        }
+        if (tight && paragraphs.length === 1 && parent.firstChild === paragraphs[0]) {
+            while (paragraphs[0].firstChild) {
+                parent.insertBefore(paragraphs[0].firstChild, paragraphs[0]);
+            }
+            parent.removeChild(paragraphs[0]);
+        }
+    }
+
+    // renderMarkdown renders common subset of markdown, raw html in paste is shown as text
+    function renderMarkdown(text, container) {
+        renderBlocks(text.replace(/\r\n?/g, "\n").split("\n"), container, false);
+    }
+
+    // looksLikeMarkdown guesses syntax of paste created with auto detection
+    function looksLikeMarkdown(text) {
+        return /^(#{1,6} \S|\s*[-*+] \S|\s*\d+\. \S|```|> )/m.test(text) || /\[[^\]]+\]\([^)]+\)/.test(text);
+    }
+
+    // renderPaste renders decrypted paste by its syntax
+    function renderPaste(paste, container) {
+        var syntax = paste.syntax;
+        if (syntax === "") {
+            syntax = looksLikeMarkdown(paste.text) ? "markdown" : "text";
+        }
+        if (syntax === "markdown") {
+            var page = el("div", "encrypted-markdown");
+            renderMarkdown(paste.text, page);
+            container.appendChild(page);
+        } else if (syntax === "text") {
+            renderText(paste.text, container);
+        } else {
+            renderCode(paste.text, syntax, container);
+        }
+    }
+
+    function addHiddenInput(form, name, value) {
+        var input = document.createElement("input");
+        input.type = "hidden";
+        input.name = name;
+        input.value = value;
+        form.appendChild(input);
+    }
+
+    // setupEditor encrypts paste before sending if user asked for it.
+    // Redirect to created paste keeps fragment of form action, so key is passed to viewer
+    function setupEditor() {
+        var option = document.getElementById("encrypt-option");
+        var checkbox = document.getElementById("encrypt-checkbox");
+        if (!option || !checkbox || !window.crypto || !crypto.subtle) {
+            return;
+        }
+        option.hidden = false;
+        var form = option.closest("form");
+        form.addEventListener("submit", function (e) {
+            if (!checkbox.checked) {
+                return;
+            }
+            e.preventDefault();
+            encrypt(form.elements["data"].value, form.elements["syntax"].value).then(function (res) {
+                var encForm = document.createElement("form");
+                encForm.method = "post";
+                encForm.action = "/create#" + res.key;
+                encForm.hidden = true;
+                addHiddenInput(encForm, "data", res.data);
+                addHiddenInput(encForm, "syntax", "encrypted");
+                if (form.elements["burn"].checked) {
+                    addHiddenInput(encForm, "burn", "true");
+                }
+                addHiddenInput(encForm, "password", form.elements["password"].value);
+                document.body.appendChild(encForm);
+                encForm.submit();
+            }).catch(function (err) {
+                alert("Can't encrypt paste: " + err.message);
+            });
+        });
+    }
+
+    // setupViewer decrypts paste with key from url fragment and renders it
+    function setupViewer() {
+        var container = document.getElementById("encrypted-paste");
+        if (!container) {
+            return;
+        }
+        var showMessage = function (msg) {
+            var p = document.createElement("p");
+            p.className = "light-text";
+            p.textContent = msg;
+            container.appendChild(p);
+        };
+        var keyStr = window.location.hash.slice(1);
+        if (!keyStr) {
+            showMessage("This paste is encrypted, the link does not contain key to decrypt it.");
+            return;
+        }
+        decrypt(container.dataset.ciphertext, keyStr).then(function (paste) {
+            renderPaste(paste, container);
+        }).catch(function () {
+            showMessage("Can't decrypt paste, the key in the link is wrong.");
+        });
+    }
+
+    // setupReveal keeps key in url fragment when paste is opened from prompt
+    function setupReveal() {
+        var forms = document.querySelectorAll("form.reveal-form");
+        for (var i = 0; i < forms.length; i++) {
+            if (window.location.hash) {
+                forms[i].action = forms[i].getAttribute("action") + window.location.hash;
+            }
+        }
+    }
+
+    setupEditor();
+    setupViewer();
+    setupReveal();
+})();
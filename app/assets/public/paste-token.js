@@ -0,0 +1,18 @@
+// Edit and delete actions for holders of paste secret token.
+// Token is passed in url fragment "#token=<token>" that is never sent to server,
+// it's put to forms of actions and sent in body of POST request.
+(function () {
+    "use strict";
+
+    var controls = document.getElementById("token-controls");
+    var match = /(?:^#|&)token=([^&]+)/.exec(window.location.hash);
+    if (!controls || !match) {
+        return;
+    }
+    var token = decodeURIComponent(match[1]);
+    var inputs = controls.querySelectorAll("input[name=token]");
+    for (var i = 0; i < inputs.length; i++) {
+        inputs[i].value = token;
+    }
+    controls.hidden = false;
+})();
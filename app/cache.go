@@ -0,0 +1,161 @@
+package app
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/vdimir/markify/render"
+)
+
+const defaultRenderCacheSize = 32 << 20
+
+// renderCache keeps rendered documents in memory to skip loading and rendering of popular pastes.
+// Least recently used documents are evicted when total size exceeds limit.
+// Cache is local to process, so documents edited by other instances are outdated until evicted
+type renderCache struct {
+	mu       sync.Mutex
+	items    map[string]*list.Element
+	byDoc    map[string][]string // cache keys of document rendered with different syntaxes
+	lru      *list.List          // front is most recently used
+	size     int64
+	maxBytes int64
+	gen      uint64 // incremented on invalidation, documents rendered before are not added
+
+	hits   int64
+	misses int64
+}
+
+type renderCacheItem struct {
+	key      string
+	docID    string
+	doc      *Document
+	size     int64
+	expireAt time.Time
+}
+
+// RenderCacheStats counters of render cache
+type RenderCacheStats struct {
+	Hits    int64 `json:"hits"`
+	Misses  int64 `json:"misses"`
+	Entries int   `json:"entries"`
+	Bytes   int64 `json:"bytes"`
+}
+
+func newRenderCache(maxBytes int64) *renderCache {
+	return &renderCache{
+		items:    map[string]*list.Element{},
+		byDoc:    map[string][]string{},
+		lru:      list.New(),
+		maxBytes: maxBytes,
+	}
+}
+
+func renderCacheKey(docID string, syntax string) string {
+	return docID + "\n" + syntax + "\n" + render.Version
+}
+
+// Get returns copy of cached document or nil with generation to pass to Put
+func (c *renderCache) Get(docID string, syntax string) (*Document, uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.items[renderCacheKey(docID, syntax)]
+	if ok {
+		item := elem.Value.(*renderCacheItem)
+		if item.expireAt.IsZero() || item.expireAt.After(time.Now()) {
+			c.hits++
+			c.lru.MoveToFront(elem)
+			return copyDocument(item.doc), c.gen
+		}
+		c.remove(elem)
+	}
+	c.misses++
+	return nil, c.gen
+}
+
+// Put adds copy of document rendered with syntax, document is not added if cache was invalidated after Get
+func (c *renderCache) Put(docID string, syntax string, doc *Document, expireAt time.Time, gen uint64) {
+	item := &renderCacheItem{
+		key:      renderCacheKey(docID, syntax),
+		docID:    docID,
+		doc:      copyDocument(doc),
+		size:     documentSize(doc),
+		expireAt: expireAt,
+	}
+	if item.size > c.maxBytes {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if gen != c.gen {
+		return
+	}
+	if elem, ok := c.items[item.key]; ok {
+		c.remove(elem)
+	}
+	c.items[item.key] = c.lru.PushFront(item)
+	c.byDoc[docID] = append(c.byDoc[docID], item.key)
+	c.size += item.size
+	for c.size > c.maxBytes {
+		c.remove(c.lru.Back())
+	}
+}
+
+// Invalidate removes all renderings of document
+func (c *renderCache) Invalidate(docID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	for _, key := range append([]string(nil), c.byDoc[docID]...) {
+		if elem, ok := c.items[key]; ok {
+			c.remove(elem)
+		}
+	}
+}
+
+// Stats returns cache counters
+func (c *renderCache) Stats() RenderCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return RenderCacheStats{Hits: c.hits, Misses: c.misses, Entries: len(c.items), Bytes: c.size}
+}
+
+func (c *renderCache) remove(elem *list.Element) {
+	item := elem.Value.(*renderCacheItem)
+	c.lru.Remove(elem)
+	delete(c.items, item.key)
+	c.size -= item.size
+	keys := c.byDoc[item.docID][:0]
+	for _, key := range c.byDoc[item.docID] {
+		if key != item.key {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		delete(c.byDoc, item.docID)
+	} else {
+		c.byDoc[item.docID] = keys
+	}
+}
+
+// copyDocument returns copy of document that can be modified by request handler
+func copyDocument(doc *Document) *Document {
+	res := *doc
+	res.Forks = append([]string(nil), doc.Forks...)
+	if doc.meta != nil {
+		res.meta = make(map[string]string, len(doc.meta))
+		for k, v := range doc.meta {
+			res.meta[k] = v
+		}
+	}
+	return &res
+}
+
+// documentSize estimates memory used by document
+func documentSize(doc *Document) int64 {
+	size := len(doc.Title) + len(doc.Preview) + len(doc.Body) + len(doc.Ciphertext) + len(doc.DocID) + len(doc.SourceURL)
+	for k, v := range doc.meta {
+		size += len(k) + len(v)
+	}
+	return int64(size)
+}
@@ -0,0 +1,155 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vdimir/markify/render"
+)
+
+func TestRenderCache(t *testing.T) {
+	cache := newRenderCache(100)
+	newDoc := func(docID string, body string) *Document {
+		return &Document{Document: render.Document{Body: body}, DocID: docID, meta: map[string]string{"k": "v"}}
+	}
+
+	doc, gen := cache.Get("a", "")
+	assert.Nil(t, doc)
+	cache.Put("a", "", newDoc("a", strings.Repeat("a", 40)), time.Time{}, gen)
+	cache.Put("a", "go", newDoc("a", strings.Repeat("b", 40)), time.Time{}, gen)
+
+	doc, _ = cache.Get("a", "")
+	require.NotNil(t, doc)
+	assert.Equal(t, strings.Repeat("a", 40), doc.Body)
+	// cached document is not changed by caller
+	doc.EditToken = "secret"
+	doc.meta["k"] = "changed"
+	doc, _ = cache.Get("a", "")
+	assert.Empty(t, doc.EditToken)
+	assert.Equal(t, "v", doc.meta["k"])
+
+	// least recently used rendering is evicted
+	_, gen = cache.Get("b", "")
+	cache.Put("b", "", newDoc("b", strings.Repeat("c", 40)), time.Time{}, gen)
+	doc, _ = cache.Get("a", "go")
+	assert.Nil(t, doc)
+	doc, _ = cache.Get("a", "")
+	assert.NotNil(t, doc)
+
+	// document rendered before invalidation is not added
+	_, gen = cache.Get("a", "go")
+	cache.Invalidate("a")
+	cache.Put("a", "go", newDoc("a", "outdated"), time.Time{}, gen)
+	doc, _ = cache.Get("a", "")
+	assert.Nil(t, doc)
+	doc, _ = cache.Get("a", "go")
+	assert.Nil(t, doc)
+
+	_, gen = cache.Get("c", "")
+	cache.Put("c", "", newDoc("c", "expired"), time.Now().Add(-time.Second), gen)
+	doc, _ = cache.Get("c", "")
+	assert.Nil(t, doc)
+
+	cache.Put("d", "", newDoc("d", strings.Repeat("d", 200)), time.Time{}, gen)
+	doc, _ = cache.Get("d", "")
+	assert.Nil(t, doc, "document larger than cache is not added")
+
+	stats := cache.Stats()
+	assert.Equal(t, int64(3), stats.Hits)
+	assert.Equal(t, int64(9), stats.Misses)
+	assert.Equal(t, 1, stats.Entries)
+	assert.Equal(t, documentSize(newDoc("b", strings.Repeat("c", 40))), stats.Bytes)
+}
+
+func TestRenderCacheInvalidation(t *testing.T) {
+	tapp, teardown := createNewTestApp(t)
+	defer teardown()
+
+	ts := httptest.NewServer(tapp.Routes())
+	defer ts.Close()
+
+	docID, token, err := tapp.savePaste(context.Background(), &CreatePasteRequest{Text: "# First", Syntax: "markdown"})
+	require.NoError(t, err)
+
+	getBody := func(path string) string {
+		resp, err := http.Get(ts.URL + path)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return string(body)
+	}
+	assert.Contains(t, getBody("/p/"+docID), "First")
+	assert.Contains(t, getBody("/p/"+docID), "First")
+
+	var metrics struct {
+		RenderCache RenderCacheStats `json:"render_cache"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(getBody("/_metrics")), &metrics))
+	assert.Equal(t, int64(1), metrics.RenderCache.Hits)
+	assert.Equal(t, int64(1), metrics.RenderCache.Misses)
+	assert.Equal(t, 1, metrics.RenderCache.Entries)
+
+	resp, err := http.PostForm(ts.URL+"/p/"+docID+"/edit",
+		url.Values{"data": {"# Second"}, "syntax": {"markdown"}, "token": {token}})
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, getBody("/p/"+docID), "Second")
+
+	resp, err = http.PostForm(ts.URL+"/p/"+docID+"/delete", url.Values{"token": {token}})
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	resp, err = http.Get(ts.URL + "/p/" + docID)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+// countingLister counts listings of keys
+type countingLister struct {
+	ExtendedStore
+	lists int
+}
+
+func (s *countingLister) ListKeys(prefix string, after string, limit int) ([]string, error) {
+	s.lists++
+	return s.ExtendedStore.ListKeys(prefix, after, limit)
+}
+
+func TestRenderCacheForks(t *testing.T) {
+	tapp, teardown := createNewTestApp(t)
+	defer teardown()
+	lister := &countingLister{ExtendedStore: tapp.blobStore.(ExtendedStore)}
+	tapp.index = newKeyIndex(lister)
+
+	parentID, _, err := tapp.savePaste(context.Background(), &CreatePasteRequest{Text: "parent", Syntax: "text"})
+	require.NoError(t, err)
+	doc, err := tapp.getDocument(context.Background(), parentID)
+	require.NoError(t, err)
+	assert.Empty(t, doc.Forks)
+	assert.Equal(t, 1, lister.lists)
+	_, err = tapp.getDocument(context.Background(), parentID)
+	require.NoError(t, err)
+	assert.Equal(t, 1, lister.lists, "forks are cached with document")
+
+	forkID, _, err := tapp.savePaste(context.Background(), &CreatePasteRequest{Text: "fork", Syntax: "text", ForkedFrom: parentID, Ttl: time.Hour})
+	require.NoError(t, err)
+	doc, err = tapp.getDocument(context.Background(), parentID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{forkID}, doc.Forks, "document is invalidated when fork is created")
+
+	forkMeta, err := tapp.loadPasteMeta(context.Background(), forkID)
+	require.NoError(t, err)
+	require.NoError(t, tapp.deletePaste(context.Background(), forkID, forkMeta))
+	doc, err = tapp.getDocument(context.Background(), parentID)
+	require.NoError(t, err)
+	assert.Empty(t, doc.Forks, "document is invalidated when fork is deleted")
+}
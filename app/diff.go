@@ -0,0 +1,157 @@
+package app
+
+import (
+	"context"
+	"html/template"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+
+	"github.com/pkg/errors"
+	"github.com/vdimir/markify/diff"
+	"github.com/vdimir/markify/view"
+)
+
+// diffContextLines number of unchanged lines shown around changes
+const diffContextLines = 3
+
+var diffLineKinds = map[diff.Op]string{
+	diff.Equal:  "equal",
+	diff.Delete: "delete",
+	diff.Insert: "insert",
+}
+
+// diffSide is one of compared texts
+type diffSide struct {
+	Name   string
+	URL    string
+	Text   string
+	Syntax string
+}
+
+// loadDiffSide loads paste which text can be compared, returns nil if there is no such paste
+func (app *App) loadDiffSide(ctx context.Context, docID string) (*diffSide, error) {
+	data, meta, err := app.blobs(ctx).GetBlob(docID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't get data")
+	}
+	if data == nil {
+		return nil, nil
+	}
+	defer closeData(data)
+	if !isPlainPaste(meta) {
+		return nil, nil
+	}
+	text, err := ioutil.ReadAll(data)
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't read data")
+	}
+	return &diffSide{Name: docID, URL: "/p/" + docID, Text: string(text), Syntax: meta["syntax"]}, nil
+}
+
+// loadDiffSides loads pastes to compare, returns nil if some of them can't be compared
+func (app *App) loadDiffSides(ctx context.Context, docIDs ...string) ([]*diffSide, error) {
+	sides := make([]*diffSide, 0, len(docIDs))
+	for _, docID := range docIDs {
+		side, err := app.loadDiffSide(ctx, docID)
+		if err != nil || side == nil {
+			return nil, err
+		}
+		sides = append(sides, side)
+	}
+	return sides, nil
+}
+
+// diffView builds context for diff.html showing changes from a to b.
+// Lines are highlighted with syntax of corresponding paste, in split view changed lines are shown side by side
+func (app *App) diffView(a *diffSide, b *diffSide, r *http.Request) (*view.DiffContext, error) {
+	oldLines, err := app.converter.HighlightLines(a.Text, a.Syntax)
+	if err != nil {
+		return nil, err
+	}
+	newLines, err := app.converter.HighlightLines(b.Text, b.Syntax)
+	if err != nil {
+		return nil, err
+	}
+	ctx := &view.DiffContext{
+		Title:      "Changes " + a.Name + ".." + b.Name + " - " + defaultTitle,
+		FromName:   a.Name,
+		FromURL:    a.URL,
+		ToName:     b.Name,
+		ToURL:      b.URL,
+		Split:      r.URL.Query().Get("view") == "split",
+		UnifiedURL: diffViewURL(r, ""),
+		SplitURL:   diffViewURL(r, "split"),
+	}
+	for _, h := range diff.Hunks(diff.Lines(a.Text, b.Text), diffContextLines) {
+		hunk := view.DiffHunk{Header: h.Header()}
+		for _, l := range h.Lines {
+			line := view.DiffLine{Kind: diffLineKinds[l.Op], OldNum: l.OldNum, NewNum: l.NewNum, NoNewline: l.NoNewline}
+			if l.OldNum > 0 {
+				line.HTML = highlightedLine(oldLines, l.OldNum, l.Text)
+			} else {
+				line.HTML = highlightedLine(newLines, l.NewNum, l.Text)
+			}
+			hunk.Lines = append(hunk.Lines, line)
+		}
+		if ctx.Split {
+			hunk.Rows = splitDiffRows(hunk.Lines)
+		}
+		ctx.Hunks = append(ctx.Hunks, hunk)
+	}
+	return ctx, nil
+}
+
+// highlightedLine returns highlighted line by number or escaped text if highlighter split text differently
+func highlightedLine(lines []string, num int, text string) template.HTML {
+	if num <= len(lines) {
+		return template.HTML(lines[num-1])
+	}
+	return template.HTML(template.HTMLEscapeString(text))
+}
+
+// splitDiffRows pairs deleted lines with inserted lines following them
+func splitDiffRows(lines []view.DiffLine) []view.DiffRow {
+	var rows []view.DiffRow
+	for i := 0; i < len(lines); {
+		if lines[i].Kind == "equal" {
+			rows = append(rows, view.DiffRow{Old: &lines[i], New: &lines[i]})
+			i++
+			continue
+		}
+		var deleted, inserted []*view.DiffLine
+		for ; i < len(lines) && lines[i].Kind == "delete"; i++ {
+			deleted = append(deleted, &lines[i])
+		}
+		for ; i < len(lines) && lines[i].Kind == "insert"; i++ {
+			inserted = append(inserted, &lines[i])
+		}
+		for j := 0; j < len(deleted) || j < len(inserted); j++ {
+			row := view.DiffRow{}
+			if j < len(deleted) {
+				row.Old = deleted[j]
+			}
+			if j < len(inserted) {
+				row.New = inserted[j]
+			}
+			rows = append(rows, row)
+		}
+	}
+	return rows
+}
+
+// diffViewURL returns current url with changed diff view mode
+func diffViewURL(r *http.Request, mode string) string {
+	query := url.Values{}
+	for k, v := range r.URL.Query() {
+		query[k] = v
+	}
+	query.Del("view")
+	if mode != "" {
+		query.Set("view", mode)
+	}
+	if len(query) == 0 {
+		return r.URL.Path
+	}
+	return r.URL.Path + "?" + query.Encode()
+}
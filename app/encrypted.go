@@ -0,0 +1,61 @@
+package app
+
+import (
+	"encoding/base64"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+)
+
+// encryptedSyntax marks pastes encrypted in browser. Key is kept in url fragment and never sent to server,
+// so such pastes are stored and returned as is, decrypted and rendered by /public/encrypted.js.
+// Server can't sanitize content it can't read, so script renders it without interpreting html
+const encryptedSyntax = "encrypted"
+
+// versions of encrypted paste format: plaintext of version 1 is text of paste,
+// plaintext of version 2 is json with text and syntax selected by author
+const (
+	encryptedFormatText     = 1
+	encryptedFormatDocument = 2
+)
+const encryptedNonceSize = 12
+
+// encryptedPaste is text of paste with encrypted syntax: AES-256-GCM ciphertext with nonce.
+// Binary fields are encoded with standard base64
+type encryptedPaste struct {
+	Version    int    `json:"v"`
+	Nonce      string `json:"iv"`
+	Ciphertext string `json:"ct"`
+}
+
+// validateEncryptedPaste checks that text is well-formed encrypted paste,
+// so plain text sent with encrypted syntax by mistake is rejected
+func validateEncryptedPaste(text string) error {
+	paste := &encryptedPaste{}
+	if err := json.Unmarshal([]byte(text), paste); err != nil {
+		return errors.New("malformed encrypted paste")
+	}
+	if paste.Version != encryptedFormatText && paste.Version != encryptedFormatDocument {
+		return errors.Errorf("unsupported encrypted paste version %d", paste.Version)
+	}
+	nonce, err := base64.StdEncoding.DecodeString(paste.Nonce)
+	if err != nil || len(nonce) != encryptedNonceSize {
+		return errors.New("malformed encrypted paste nonce")
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(paste.Ciphertext)
+	if err != nil || len(ciphertext) == 0 {
+		return errors.New("malformed encrypted paste ciphertext")
+	}
+	return nil
+}
+
+// isEncryptedPaste returns true if paste is stored encrypted, such pastes can't be edited
+func isEncryptedPaste(meta map[string]string) bool {
+	return meta["encryption"] == passwordEncryption || meta["syntax"] == encryptedSyntax
+}
+
+// isPlainPaste returns true if paste text can be shown to anyone having link without extra steps,
+// so its revisions can be listed and it can be forked
+func isPlainPaste(meta map[string]string) bool {
+	return meta["burn"] != "true" && !isEncryptedPaste(meta)
+}
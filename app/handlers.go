@@ -2,20 +2,29 @@ package app
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
+	chirender "github.com/go-chi/render"
+	"github.com/pkg/errors"
+	"github.com/vdimir/markify/render"
+	"github.com/vdimir/markify/util"
 	"github.com/vdimir/markify/view"
 )
 
 const defaultTitle = "markify"
+const userCookieMaxAge = 60 * 60 * 24 * 365 * 5
 
 // Routes setup
 func (app *App) Routes() *chi.Mux {
@@ -30,30 +39,104 @@ func (app *App) Routes() *chi.Mux {
 
 	r.Use(middleware.RedirectSlashes)
 	r.Use(middleware.Recoverer)
+	r.Use(app.limitStorageTime)
 
 	app.addFileServer(r, "public")
 	app.addFixedPages(r)
 
 	r.Get("/_ping", app.handlePing)
 	r.Get("/ping", app.handlePing)
+	r.Get("/_metrics", app.handleMetrics)
 	r.Get("/_admin/unload", app.handleUnload)
 
 	r.Get("/robots.txt", app.handleRobotsTxt)
 
-	r.Get("/", app.handlePageIndex)
+	r.Route("/api/v1", app.apiRoutes)
 
-	r.Get("/p/{pageID}", app.handleViewPageDoc)
-	r.Get("/p/{pageID}/text", app.handleViewPlainText)
+	r.Group(func(r chi.Router) {
+		r.Use(app.issueUserCookie)
 
-	r.Get("/create", app.handlePageTextInput)
-	r.Post("/create", app.handleCreateDocument)
+		r.Get("/", app.handlePageIndex)
 
-	r.Post("/preview", app.handlePagePreview)
+		r.Get("/p/{pageID}", app.handleViewPageDoc)
+		r.Post("/p/{pageID}", app.handleRevealPageDoc)
+		r.Get("/p/{pageID}/text", app.handleViewPlainText)
+		r.Post("/p/{pageID}/text", app.handleRevealPlainText)
+		r.Get("/p/{pageID}/edit", app.handleEditPageInput)
+		r.Post("/p/{pageID}/edit", app.handleEditDocument)
+		// editor is opened by form with paste token sent in body
+		r.Post("/p/{pageID}/edit/open", app.handleEditPageInput)
+		r.Post("/p/{pageID}/delete", app.handleDeleteDocument)
+		r.Get("/p/{pageID}/fork", app.handleForkPageInput)
+		r.Get("/p/{pageID}/history", app.handlePasteHistory)
+		r.Get("/p/{pageID}/diff", app.handleRevisionDiff)
+		r.Get("/p/{pageID}/v/{rev}", app.handleViewRevision)
+		r.Get("/p/{pageID}/v/{rev}/text", app.handleViewRevisionText)
+
+		r.Get("/create", app.handlePageTextInput)
+		r.Post("/create", app.handleCreateDocument)
+
+		r.Get("/diff/{a}/{b}", app.handlePasteDiff)
+		r.Get("/link", app.handleLinkInput)
+		r.Post("/link", app.handleCreateFromLink)
+
+		r.Get("/my", app.handleUserPastes)
+
+		r.Post("/preview", app.handlePagePreview)
+	})
 	r.Get("/preview", app.notFound)
 	r.NotFound(app.notFound)
 	return r
 }
 
+// limitStorageTime sets deadline to request context, so storage operations don't hang if storage is slow
+func (app *App) limitStorageTime(next http.Handler) http.Handler {
+	timeout := app.storageTimeout()
+	if timeout < 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// issueUserCookie sets signed user_id cookie for new visitors
+func (app *App) issueUserCookie(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.uidGen == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if uidCookie, err := r.Cookie("user_id"); err == nil && app.uidGen.Validate([]byte(uidCookie.Value)) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		uidCookie := &http.Cookie{
+			Name:     "user_id",
+			Value:    string(app.uidGen.GetUID(userIDLen)),
+			Path:     "/",
+			MaxAge:   userCookieMaxAge,
+			HttpOnly: true,
+			Secure:   isHTTPS(r),
+			SameSite: http.SameSiteLaxMode,
+		}
+		http.SetCookie(w, uidCookie)
+
+		// replace invalid cookie to make new one visible to handlers
+		r2 := r.Clone(r.Context())
+		r2.Header.Del("Cookie")
+		for _, c := range r.Cookies() {
+			if c.Name != uidCookie.Name {
+				r2.AddCookie(c)
+			}
+		}
+		r2.AddCookie(uidCookie)
+		next.ServeHTTP(w, r2)
+	})
+}
+
 func (app *App) handlePing(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
@@ -66,6 +149,18 @@ func (app *App) handlePing(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte(app.cfg.StatusText))
 }
 
+// handleMetrics returns counters of render cache
+func (app *App) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	metrics := struct {
+		RenderCache *RenderCacheStats `json:"render_cache,omitempty"`
+	}{}
+	if app.renderCache != nil {
+		stats := app.renderCache.Stats()
+		metrics.RenderCache = &stats
+	}
+	chirender.JSON(w, r, metrics)
+}
+
 func (app *App) handleUnload(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 
@@ -92,7 +187,9 @@ func (app *App) handlePageIndex(w http.ResponseWriter, r *http.Request) {
 
 func (app *App) handlePageTextInput(w http.ResponseWriter, r *http.Request) {
 	ctx := &view.EditorContext{
-		Title: defaultTitle,
+		Title:          defaultTitle,
+		Syntaxes:       app.syntaxOptions,
+		ShowUserPastes: app.uidGen != nil,
 	}
 	app.viewTemplate(http.StatusOK, ctx, w)
 }
@@ -103,12 +200,100 @@ func (app *App) handleCreateDocument(w http.ResponseWriter, r *http.Request) {
 		app.respondError(err, req, w)
 		return
 	}
-	docID, err := app.savePaste(req)
+	docID, deleteToken, err := app.savePaste(r.Context(), req)
 	if err != nil {
 		app.respondError(err, req, w)
 		return
 	}
-	http.Redirect(w, r, fmt.Sprintf("/p/%s", docID), 302)
+	http.Redirect(w, r, pasteTokenURL(docID, deleteToken), 302)
+}
+
+// handleLinkInput shows url prompt or renders document from url passed in query
+func (app *App) handleLinkInput(w http.ResponseWriter, r *http.Request) {
+	if app.fetcher == nil {
+		app.notFound(w, r)
+		return
+	}
+	docURL := r.URL.Query().Get("url")
+	if docURL == "" {
+		app.viewTemplate(http.StatusOK, &view.URLPromptContext{Title: defaultTitle}, w)
+		return
+	}
+
+	text, err := app.fetchDocument(docURL)
+	if err != nil {
+		app.respondLinkError(err, docURL, w)
+		return
+	}
+	doc, err := app.converter.Convert(strings.NewReader(text), "markdown")
+	if err != nil {
+		app.serverError(err, w)
+		return
+	}
+	app.viewDocument(&Document{Document: *doc, SourceURL: docURL}, "", r.URL.String(), w)
+}
+
+// handleCreateFromLink saves snapshot of document from url or redirects to live view
+func (app *App) handleCreateFromLink(w http.ResponseWriter, r *http.Request) {
+	if app.fetcher == nil {
+		app.notFound(w, r)
+		return
+	}
+	docURL := strings.TrimSpace(r.FormValue("data"))
+	if docURL == "" {
+		app.respondLinkError(WrapfUserError(errors.New("empty url"), "empty input"), docURL, w)
+		return
+	}
+	if r.FormValue("mode") == "live" {
+		http.Redirect(w, r, "/link?url="+url.QueryEscape(docURL), http.StatusFound)
+		return
+	}
+
+	text, err := app.fetchDocument(docURL)
+	if err != nil {
+		app.respondLinkError(err, docURL, w)
+		return
+	}
+	req := &CreatePasteRequest{Text: text, Syntax: "markdown", SourceURL: docURL}
+	if uidCookie, err := r.Cookie("user_id"); err == nil {
+		req.UserToken = uidCookie.Value
+	}
+	if err = app.validatePasteRequest(r.Context(), req); err != nil {
+		app.respondLinkError(WrapfUserError(err, err.Error()), docURL, w)
+		return
+	}
+	docID, deleteToken, err := app.savePaste(r.Context(), req)
+	if err != nil {
+		app.serverError(err, w)
+		return
+	}
+	http.Redirect(w, r, pasteTokenURL(docID, deleteToken), http.StatusFound)
+}
+
+func (app *App) handleUserPastes(w http.ResponseWriter, r *http.Request) {
+	uidCookie, err := r.Cookie("user_id")
+	if app.uidGen == nil || err != nil {
+		app.notFound(w, r)
+		return
+	}
+	entries, next, err := app.userPastes(r.Context(), uidCookie.Value, r.URL.Query().Get("after"))
+	if err != nil {
+		app.serverError(err, w)
+		return
+	}
+	ctx := &view.UserPastesContext{Title: "My pastes - " + defaultTitle, NextPage: next}
+	for _, entry := range entries {
+		info := view.PasteInfo{
+			DocID:      entry.DocID,
+			Title:      entry.Meta["title"],
+			CreateTime: entry.CreateTime.Format(timeFormat),
+		}
+		if !entry.ExpireTime.IsZero() {
+			info.ExpireTime = entry.ExpireTime.Format(timeFormat)
+		}
+		ctx.Pastes = append(ctx.Pastes, info)
+	}
+	app.viewTemplate(http.StatusOK, ctx, w)
 }
 
 func (app *App) handleRobotsTxt(w http.ResponseWriter, r *http.Request) {
@@ -133,6 +318,7 @@ func (app *App) handlePagePreview(w http.ResponseWriter, r *http.Request) {
 			Msg:       err.Error(),
 		}
 		app.viewTemplate(http.StatusBadRequest, ctx, w)
+		return
 	}
 
 	doc, err := app.converter.Convert(strings.NewReader(createReq.Text), createReq.Syntax)
@@ -140,12 +326,16 @@ func (app *App) handlePagePreview(w http.ResponseWriter, r *http.Request) {
 		app.serverError(err, w)
 		return
 	}
-	app.viewDocument(&Document{*doc, "", time.Time{}}, "Preview", "", w)
+	app.viewDocument(&Document{Document: *doc}, "Preview", "", w)
 }
 
 func (app *App) handleViewPageDoc(w http.ResponseWriter, r *http.Request) {
 	pageID := chi.URLParam(r, "pageID")
-	doc, err := app.getDocument(pageID)
+	syntax := r.URL.Query().Get("syntax")
+	if err := app.converter.SupportSyntax(syntax); err != nil {
+		syntax = ""
+	}
+	doc, err := app.getDocumentAs(r.Context(), pageID, syntax)
 	if err != nil {
 		app.serverError(err, w)
 		return
@@ -154,12 +344,370 @@ func (app *App) handleViewPageDoc(w http.ResponseWriter, r *http.Request) {
 		app.notFound(w, r)
 		return
 	}
+	if doc.Burn || doc.Protected {
+		w.Header().Set("Cache-Control", "no-store")
+		app.viewRevealPrompt(http.StatusOK, r.URL.Path, doc.meta, "", w)
+		return
+	}
+	if app.canModifyPaste(r, doc.meta) {
+		doc.Editable = true
+		doc.EditToken = pasteTokenFromRequest(r)
+	}
+	// page can be edited or deleted, so caches have to revalidate it
+	w.Header().Set("Vary", "Cookie")
+	if doc.Editable {
+		w.Header().Set("Cache-Control", "private, no-cache")
+	} else {
+		w.Header().Set("Cache-Control", "no-cache")
+	}
+	etag := quoteETag(doc.DataHash, render.Version, app.pagesVersion, doc.Syntax,
+		strconv.FormatBool(doc.Editable), doc.EditToken, strings.Join(doc.Forks, ","), strconv.Itoa(doc.Revisions))
+	if checkNotModified(w, r, etag, pasteUpdateTime(doc.meta)) {
+		return
+	}
 	app.viewDocument(doc, "", r.URL.Path, w)
 }
 
+// handleForkPageInput opens editor with copy of paste, new paste keeps link to the original one
+func (app *App) handleForkPageInput(w http.ResponseWriter, r *http.Request) {
+	pageID := chi.URLParam(r, "pageID")
+	data, meta, err := app.blobs(r.Context()).GetBlob(pageID)
+	if err != nil {
+		app.serverError(err, w)
+		return
+	}
+	if data == nil {
+		app.notFound(w, r)
+		return
+	}
+	defer closeData(data)
+	if !isPlainPaste(meta) {
+		app.notFound(w, r)
+		return
+	}
+	text, err := ioutil.ReadAll(data)
+	if err != nil {
+		app.serverError(err, w)
+		return
+	}
+	ctx := &view.EditorContext{
+		Title:          defaultTitle,
+		InitialText:    string(text),
+		Syntaxes:       app.syntaxOptions,
+		Syntax:         meta["syntax"],
+		ForkedFrom:     pageID,
+		ShowUserPastes: app.uidGen != nil,
+	}
+	app.viewTemplate(http.StatusOK, ctx, w)
+}
+
+func (app *App) handleEditPageInput(w http.ResponseWriter, r *http.Request) {
+	pageID := chi.URLParam(r, "pageID")
+	data, meta, err := app.blobs(r.Context()).GetBlob(pageID)
+	if err != nil {
+		app.serverError(err, w)
+		return
+	}
+	if data == nil {
+		app.notFound(w, r)
+		return
+	}
+	defer closeData(data)
+	if !app.canModifyPaste(r, meta) {
+		app.forbidden(w, r)
+		return
+	}
+	if isEncryptedPaste(meta) {
+		app.viewProtectedEditError(w)
+		return
+	}
+	text, err := ioutil.ReadAll(data)
+	if err != nil {
+		app.serverError(err, w)
+		return
+	}
+	ctx := &view.EditorContext{
+		Title:       defaultTitle,
+		InitialText: string(text),
+		Syntax:      meta["syntax"],
+		Syntaxes:    app.syntaxOptions,
+		Action:      fmt.Sprintf("/p/%s/edit", pageID),
+		EditToken:   pasteTokenFromRequest(r),
+	}
+	app.viewTemplate(http.StatusOK, ctx, w)
+}
+
+func (app *App) handleEditDocument(w http.ResponseWriter, r *http.Request) {
+	pageID := chi.URLParam(r, "pageID")
+	meta, err := app.loadPasteMeta(r.Context(), pageID)
+	if err != nil {
+		app.serverError(err, w)
+		return
+	}
+	if meta == nil {
+		app.notFound(w, r)
+		return
+	}
+	if !app.canModifyPaste(r, meta) {
+		app.forbidden(w, r)
+		return
+	}
+	if isEncryptedPaste(meta) {
+		app.viewProtectedEditError(w)
+		return
+	}
+	req, err := app.parseAndValidateRequest(r)
+	if err != nil {
+		if errUser, ok := err.(UserError); ok {
+			ctx := &view.EditorContext{
+				Title:       fmt.Sprintf("%s :(", defaultTitle),
+				Msg:         errUser.String(),
+				InitialText: r.FormValue("data"),
+				Syntax:      r.FormValue("syntax"),
+				Syntaxes:    app.syntaxOptions,
+				Action:      fmt.Sprintf("/p/%s/edit", pageID),
+				EditToken:   pasteTokenFromRequest(r),
+			}
+			app.viewTemplate(http.StatusBadRequest, ctx, w)
+			return
+		}
+		app.serverError(err, w)
+		return
+	}
+	newMeta, err := app.updatePaste(r.Context(), pageID, req, meta)
+	if err != nil {
+		app.serverError(err, w)
+		return
+	}
+	if newMeta == nil {
+		app.notFound(w, r)
+		return
+	}
+	http.Redirect(w, r, fmt.Sprintf("/p/%s", pageID), http.StatusFound)
+}
+
+func (app *App) handleDeleteDocument(w http.ResponseWriter, r *http.Request) {
+	pageID := chi.URLParam(r, "pageID")
+	meta, err := app.loadPasteMeta(r.Context(), pageID)
+	if err != nil {
+		app.serverError(err, w)
+		return
+	}
+	if meta == nil {
+		app.notFound(w, r)
+		return
+	}
+	if !app.canModifyPaste(r, meta) {
+		app.forbidden(w, r)
+		return
+	}
+	if err = app.deletePaste(r.Context(), pageID, meta); err != nil {
+		app.serverError(err, w)
+		return
+	}
+	http.Redirect(w, r, "/", http.StatusFound)
+}
+
+func (app *App) handleViewRevision(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+	pageID := chi.URLParam(r, "pageID")
+	rev, err := strconv.Atoi(chi.URLParam(r, "rev"))
+	if err != nil {
+		app.notFound(w, r)
+		return
+	}
+	data, meta, latestMeta, err := app.loadRevision(r.Context(), pageID, rev)
+	if err != nil {
+		app.serverError(err, w)
+		return
+	}
+	if data == nil {
+		app.notFound(w, r)
+		return
+	}
+	defer closeData(data)
+	doc, err := app.renderDocument(pageID, data, meta, "", startTime)
+	if err != nil {
+		app.serverError(err, w)
+		return
+	}
+	doc.Revision = rev
+	doc.Revisions = pasteRevision(latestMeta)
+	app.viewDocument(doc, fmt.Sprintf("#%d", rev), "", w)
+}
+
+func (app *App) handleViewRevisionText(w http.ResponseWriter, r *http.Request) {
+	pageID := chi.URLParam(r, "pageID")
+	rev, err := strconv.Atoi(chi.URLParam(r, "rev"))
+	if err != nil {
+		app.notFound(w, r)
+		return
+	}
+	data, _, _, err := app.loadRevision(r.Context(), pageID, rev)
+	if err != nil {
+		app.serverError(err, w)
+		return
+	}
+	if data == nil {
+		app.notFound(w, r)
+		return
+	}
+	defer closeData(data)
+	app.writePlainText(data, w)
+}
+
+func (app *App) handlePasteHistory(w http.ResponseWriter, r *http.Request) {
+	pageID := chi.URLParam(r, "pageID")
+	meta, err := app.loadPasteMeta(r.Context(), pageID)
+	if err != nil {
+		app.serverError(err, w)
+		return
+	}
+	if meta == nil || !isPlainPaste(meta) {
+		app.notFound(w, r)
+		return
+	}
+	revisions, err := app.pasteRevisions(r.Context(), pageID, meta)
+	if err != nil {
+		app.serverError(err, w)
+		return
+	}
+	ctx := &view.HistoryContext{
+		Title: fmt.Sprintf("History - %s", defaultTitle),
+		DocID: pageID,
+	}
+	for _, rev := range revisions {
+		ctx.Revisions = append(ctx.Revisions, view.RevisionInfo{
+			Number: rev.Number,
+			Time:   rev.Time.Format(timeFormat),
+		})
+	}
+	app.viewTemplate(http.StatusOK, ctx, w)
+}
+
+// handleRevisionDiff shows changes between revisions passed in "from" and "to" query parameters.
+// By default, changes made in the latest revision are shown
+func (app *App) handleRevisionDiff(w http.ResponseWriter, r *http.Request) {
+	pageID := chi.URLParam(r, "pageID")
+	meta, err := app.loadPasteMeta(r.Context(), pageID)
+	if err != nil {
+		app.serverError(err, w)
+		return
+	}
+	if meta == nil || !isPlainPaste(meta) {
+		app.notFound(w, r)
+		return
+	}
+	to := pasteRevision(meta)
+	if toStr := r.URL.Query().Get("to"); toStr != "" {
+		if to, err = strconv.Atoi(toStr); err != nil {
+			app.notFound(w, r)
+			return
+		}
+	}
+	from := to - 1
+	if fromStr := r.URL.Query().Get("from"); fromStr != "" {
+		if from, err = strconv.Atoi(fromStr); err != nil {
+			app.notFound(w, r)
+			return
+		}
+	}
+
+	sides := make([]*diffSide, 2)
+	for i, rev := range []int{from, to} {
+		data, meta, _, err := app.loadRevision(r.Context(), pageID, rev)
+		if err != nil {
+			app.serverError(err, w)
+			return
+		}
+		if data == nil {
+			app.notFound(w, r)
+			return
+		}
+		text, err := ioutil.ReadAll(data)
+		closeData(data)
+		if err != nil {
+			app.serverError(err, w)
+			return
+		}
+		sides[i] = &diffSide{
+			Name:   fmt.Sprintf("#%d", rev),
+			URL:    fmt.Sprintf("/p/%s/v/%d", pageID, rev),
+			Text:   string(text),
+			Syntax: meta["syntax"],
+		}
+	}
+	ctx, err := app.diffView(sides[0], sides[1], r)
+	if err != nil {
+		app.serverError(err, w)
+		return
+	}
+	app.viewTemplate(http.StatusOK, ctx, w)
+}
+
+// handlePasteDiff shows changes between two pastes
+func (app *App) handlePasteDiff(w http.ResponseWriter, r *http.Request) {
+	sides, err := app.loadDiffSides(r.Context(), chi.URLParam(r, "a"), chi.URLParam(r, "b"))
+	if err != nil {
+		app.serverError(err, w)
+		return
+	}
+	if sides == nil {
+		app.notFound(w, r)
+		return
+	}
+	ctx, err := app.diffView(sides[0], sides[1], r)
+	if err != nil {
+		app.serverError(err, w)
+		return
+	}
+	ctx.RawURL = fmt.Sprintf("/api/v1/diff/%s/%s", sides[0].Name, sides[1].Name)
+	app.viewTemplate(http.StatusOK, ctx, w)
+}
+
+// handleRevealPageDoc shows document that is deleted after reading or protected with password
+func (app *App) handleRevealPageDoc(w http.ResponseWriter, r *http.Request) {
+	pageID := chi.URLParam(r, "pageID")
+	doc, err := app.openDocument(r.Context(), pageID, r.FormValue("password"), clientAddr(r))
+	if err == util.ErrWrongPassword || err == errTooManyAttempts {
+		app.viewWrongPassword(r.Context(), pageID, r.URL.Path, err, w)
+		return
+	}
+	if err != nil {
+		app.serverError(err, w)
+		return
+	}
+	if doc == nil {
+		app.notFound(w, r)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	app.viewDocument(doc, "", "", w)
+}
+
 func (app *App) handleViewPlainText(w http.ResponseWriter, r *http.Request) {
 	pageID := chi.URLParam(r, "pageID")
-	data, _, err := app.blobStore.GetBlob(pageID)
+	meta, err := app.loadPasteMeta(r.Context(), pageID)
+	if err != nil {
+		app.serverError(err, w)
+		return
+	}
+	if meta == nil {
+		app.notFound(w, r)
+		return
+	}
+	if meta["burn"] == "true" || meta["encryption"] == passwordEncryption {
+		w.Header().Set("Cache-Control", "no-store")
+		app.viewRevealPrompt(http.StatusOK, r.URL.Path, meta, "", w)
+		return
+	}
+	// hash of text is stored with paste, so text is not read to check if client has it
+	w.Header().Set("Cache-Control", "no-cache")
+	textHash := meta["content_hash"]
+	if textHash != "" && checkNotModified(w, r, quoteETag(textHash), pasteUpdateTime(meta)) {
+		return
+	}
+	data, _, err := app.blobs(r.Context()).GetBlob(pageID)
 	if err != nil {
 		app.serverError(err, w)
 		return
@@ -168,8 +716,86 @@ func (app *App) handleViewPlainText(w http.ResponseWriter, r *http.Request) {
 		app.notFound(w, r)
 		return
 	}
+	defer closeData(data)
+	if textHash == "" {
+		// paste saved before hash of text was stored
+		text, err := ioutil.ReadAll(data)
+		if err != nil {
+			app.serverError(err, w)
+			return
+		}
+		if checkNotModified(w, r, quoteETag(contentHash(text)), pasteUpdateTime(meta)) {
+			return
+		}
+		app.writePlainText(bytes.NewReader(text), w)
+		return
+	}
+	app.writePlainText(data, w)
+}
+
+// handleRevealPlainText returns text of document that is deleted after reading or protected with password
+func (app *App) handleRevealPlainText(w http.ResponseWriter, r *http.Request) {
+	pageID := chi.URLParam(r, "pageID")
+	data, _, err := app.openPaste(r.Context(), pageID, r.FormValue("password"), clientAddr(r))
+	if err == util.ErrWrongPassword || err == errTooManyAttempts {
+		app.viewWrongPassword(r.Context(), pageID, r.URL.Path, err, w)
+		return
+	}
+	if err != nil {
+		app.serverError(err, w)
+		return
+	}
+	if data == nil {
+		app.notFound(w, r)
+		return
+	}
+	defer closeData(data)
+	w.Header().Set("Cache-Control", "no-store")
+	app.writePlainText(data, w)
+}
+
+// viewRevealPrompt asks user to confirm reading of document that will be deleted after that
+// or to enter password for protected document
+func (app *App) viewRevealPrompt(code int, action string, meta map[string]string, msg string, w http.ResponseWriter) {
+	w.Header().Set("Cache-Control", "no-store")
+	ctx := &view.RevealContext{
+		Title:       defaultTitle,
+		Action:      action,
+		Msg:         msg,
+		Burn:        meta["burn"] == "true",
+		AskPassword: meta["encryption"] == passwordEncryption,
+	}
+	app.viewTemplate(code, ctx, w)
+}
+
+// viewWrongPassword shows password prompt again with message of password error
+func (app *App) viewWrongPassword(ctx context.Context, docID string, action string, passwordErr error, w http.ResponseWriter) {
+	meta, err := app.loadPasteMeta(ctx, docID)
+	if err != nil {
+		app.serverError(err, w)
+		return
+	}
+	if passwordErr == errTooManyAttempts {
+		w.Header().Set("Retry-After", strconv.Itoa(int(passwordAttemptsWindow.Seconds())))
+		app.viewRevealPrompt(http.StatusTooManyRequests, action, meta, "Too many attempts, try again later", w)
+		return
+	}
+	app.viewRevealPrompt(http.StatusForbidden, action, meta, "Wrong password", w)
+}
+
+// viewProtectedEditError explains that encrypted paste can't be edited
+func (app *App) viewProtectedEditError(w http.ResponseWriter) {
+	ctx := &view.StatusContext{
+		Title:     fmt.Sprintf("%s :(", defaultTitle),
+		HeaderMsg: defaultTitle,
+		Msg:       errProtectedEdit.String(),
+	}
+	app.viewTemplate(http.StatusBadRequest, ctx, w)
+}
+
+func (app *App) writePlainText(data io.Reader, w http.ResponseWriter) {
 	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-	_, err = io.Copy(w, data)
+	_, err := io.Copy(w, data)
 	if err != nil {
 		log.Printf("[ERROR] can't write response: %s", err.Error())
 		return
@@ -185,12 +811,21 @@ func (app *App) notFound(w http.ResponseWriter, r *http.Request) {
 	app.viewTemplate(http.StatusNotFound, ctx, w)
 }
 
+func (app *App) forbidden(w http.ResponseWriter, r *http.Request) {
+	ctx := &view.StatusContext{
+		Title:     "Forbidden",
+		HeaderMsg: "403",
+		Msg:       "You are not allowed to modify this page",
+	}
+	app.viewTemplate(http.StatusForbidden, ctx, w)
+}
+
 func (app *App) parseAndValidateRequest(r *http.Request) (*CreatePasteRequest, error) {
 	req, err := ParseCreatePasteRequest(r)
 	if err != nil {
 		return nil, WrapfUserError(err, err.Error())
 	}
-	err = app.validatePasteRequest(req)
+	err = app.validatePasteRequest(r.Context(), req)
 	if err != nil {
 		return nil, WrapfUserError(err, err.Error())
 	}
@@ -1,13 +1,27 @@
 package app
 
 import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
 	"net/http"
+	"net/http/cookiejar"
 	"net/http/httptest"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/vdimir/markify/fetch"
+	"github.com/vdimir/markify/store"
+	"github.com/vdimir/markify/testutil"
+	"github.com/vdimir/markify/util"
 )
 
 const appHostURL = "https://test.markify.dev"
@@ -73,3 +87,708 @@ func TestRoutes(t *testing.T) {
 	assert.Equal(t, http.StatusOK, resp.StatusCode)
 	// TODO add more checks
 }
+
+func TestLinkImport(t *testing.T) {
+	tapp, teardown := createNewTestApp(t)
+	defer teardown()
+
+	ts := httptest.NewServer(tapp.Routes())
+	defer ts.Close()
+
+	resp, err := ts.Client().Get(ts.URL + "/link")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode, "link import disabled by default")
+
+	fetcher := fetch.NewMock()
+	fetcher.(*fetch.Mock).SetData("http://git.local/README.md", []byte("# Readme\n\nHello"))
+	tapp.fetcher = fetcher
+
+	resp, err = ts.Client().Get(ts.URL + "/link")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, err = ts.Client().PostForm(ts.URL+"/link", url.Values{"data": {"http://git.local/README.md"}})
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.True(t, strings.HasPrefix(resp.Request.URL.Path, "/p/"))
+	body, _ := ioutil.ReadAll(resp.Body)
+	assert.Regexp(t, regexp.MustCompile("<h1[a-z\"= ]*>Readme</h1>"), string(body))
+	assert.Contains(t, string(body), "http://git.local/README.md")
+
+	resp, err = ts.Client().PostForm(ts.URL+"/link", url.Values{
+		"data": {"http://git.local/README.md"},
+		"mode": {"live"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "/link", resp.Request.URL.Path)
+	body, _ = ioutil.ReadAll(resp.Body)
+	assert.Contains(t, string(body), "Hello")
+
+	resp, err = ts.Client().PostForm(ts.URL+"/link", url.Values{"data": {"http://git.local/unknown.md"}})
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestModifyPageByOwner(t *testing.T) {
+	tapp, teardown := createNewTestApp(t)
+	defer teardown()
+	tapp.uidGen = util.NewSignedUIDGenerator([]byte("secret"))
+
+	ts := httptest.NewServer(tapp.Routes())
+	defer ts.Close()
+
+	ownerCookie := &http.Cookie{Name: "user_id", Value: string(tapp.uidGen.GetUID(10))}
+	otherCookie := &http.Cookie{Name: "user_id", Value: string(tapp.uidGen.GetUID(10))}
+
+	noRedirectClient := ts.Client()
+	noRedirectClient.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	postForm := func(path string, cookie *http.Cookie, data url.Values) *http.Response {
+		req, err := http.NewRequest("POST", ts.URL+path, strings.NewReader(data.Encode()))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.AddCookie(cookie)
+		resp, err := noRedirectClient.Do(req)
+		require.NoError(t, err)
+		return resp
+	}
+	get := func(path string, cookie *http.Cookie) *http.Response {
+		req, err := http.NewRequest("GET", ts.URL+path, nil)
+		require.NoError(t, err)
+		req.AddCookie(cookie)
+		resp, err := noRedirectClient.Do(req)
+		require.NoError(t, err)
+		return resp
+	}
+
+	resp := postForm("/create", ownerCookie, url.Values{"data": {"foo"}})
+	require.Equal(t, http.StatusFound, resp.StatusCode)
+	location, err := url.Parse(resp.Header.Get("Location"))
+	require.NoError(t, err)
+	assert.Regexp(t, regexp.MustCompile("^token=[1-9A-Za-z]+$"), location.Fragment, "token is shown after creation")
+	pagePath := location.Path
+
+	resp = get(pagePath, ownerCookie)
+	body, _ := ioutil.ReadAll(resp.Body)
+	assert.Contains(t, string(body), `href="`+pagePath+`/edit"`)
+	assert.NotContains(t, string(body), `id="token-controls"`)
+	resp = get(pagePath, otherCookie)
+	body, _ = ioutil.ReadAll(resp.Body)
+	assert.NotContains(t, string(body), `href="`+pagePath+`/edit"`)
+	assert.Contains(t, string(body), `<span id="token-controls" hidden>`, "actions are shown only with paste token")
+
+	resp = get(pagePath+"/edit", otherCookie)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	resp = get(pagePath+"/edit", ownerCookie)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp = postForm(pagePath+"/edit", otherCookie, url.Values{"data": {"bar"}})
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	resp = postForm(pagePath+"/edit", ownerCookie, url.Values{"data": {"bar"}})
+	assert.Equal(t, http.StatusFound, resp.StatusCode)
+	resp = get(pagePath+"/text", otherCookie)
+	body, _ = ioutil.ReadAll(resp.Body)
+	assert.Equal(t, "bar", string(body))
+
+	resp = postForm(pagePath+"/delete", otherCookie, nil)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	resp = postForm(pagePath+"/delete", ownerCookie, nil)
+	assert.Equal(t, http.StatusFound, resp.StatusCode)
+	resp = get(pagePath, ownerCookie)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestModifyPageByToken(t *testing.T) {
+	tapp, teardown := createNewTestApp(t)
+	defer teardown()
+
+	ts := httptest.NewServer(tapp.Routes())
+	defer ts.Close()
+	noRedirectClient := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+
+	resp, err := ts.Client().Post(ts.URL+"/api/v1/pastes", "application/json", strings.NewReader(`{"text": "foo"}`))
+	require.NoError(t, err)
+	created := &CreatePasteResponse{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(created))
+	pagePath := "/p/" + created.ID
+
+	// token is not accepted in url
+	resp, err = noRedirectClient.Get(ts.URL + pagePath + "/edit?token=" + created.DeleteToken)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	resp, err = noRedirectClient.PostForm(ts.URL+pagePath+"/edit?token="+created.DeleteToken, url.Values{"data": {"bar"}})
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	req, err := http.NewRequest("GET", ts.URL+pagePath, nil)
+	require.NoError(t, err)
+	req.Header.Set("X-Paste-Token", created.DeleteToken)
+	resp, err = noRedirectClient.Do(req)
+	require.NoError(t, err)
+	body, _ := ioutil.ReadAll(resp.Body)
+	assert.Contains(t, string(body), `action="`+pagePath+`/edit/open" method="post"`)
+	assert.NotContains(t, string(body), "?token=")
+
+	resp, err = noRedirectClient.PostForm(ts.URL+pagePath+"/edit/open", url.Values{"token": {created.DeleteToken}})
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	body, _ = ioutil.ReadAll(resp.Body)
+	assert.Contains(t, string(body), `name="token" value="`+created.DeleteToken+`"`, "editor sends token in form")
+
+	resp, err = noRedirectClient.PostForm(ts.URL+pagePath+"/edit", url.Values{"data": {"bar"}, "token": {created.DeleteToken}})
+	require.NoError(t, err)
+	require.Equal(t, http.StatusFound, resp.StatusCode)
+	assert.Equal(t, pagePath, resp.Header.Get("Location"), "token is not passed in redirect")
+
+	resp, err = noRedirectClient.PostForm(ts.URL+pagePath+"/delete", url.Values{"token": {created.DeleteToken}})
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusFound, resp.StatusCode)
+}
+
+func TestUserPastes(t *testing.T) {
+	t.Run("index", func(t *testing.T) {
+		testUserPastes(t, "memory:")
+	})
+	t.Run("query", func(t *testing.T) {
+		tmpPath, tmpFolderClean := testutil.GetTempFolder(t, "test_app")
+		defer tmpFolderClean()
+		testUserPastes(t, "sqlite:"+path.Join(tmpPath, "data.db"))
+	})
+}
+
+func testUserPastes(t *testing.T, storageSpec string) {
+	tapp, teardown := createTestAppWithStorage(t, storageSpec)
+	defer teardown()
+	if strings.HasPrefix(storageSpec, "sqlite:") {
+		_, ok := tapp.blobStore.(MetaQuerier)
+		require.True(t, ok)
+		defer tapp.blobStore.(*store.Sqlite).Close()
+	}
+
+	ts := httptest.NewServer(tapp.Routes())
+	defer ts.Close()
+
+	resp, err := ts.Client().Get(ts.URL + "/")
+	require.NoError(t, err)
+	assert.Empty(t, resp.Cookies(), "cookies are not issued without uid secret")
+	resp, err = ts.Client().Get(ts.URL + "/my")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	tapp.uidGen = util.NewSignedUIDGenerator([]byte("secret"))
+
+	jar, err := cookiejar.New(nil)
+	require.NoError(t, err)
+	client := ts.Client()
+	client.Jar = jar
+
+	resp, err = client.Get(ts.URL + "/")
+	require.NoError(t, err)
+	require.Len(t, resp.Cookies(), 1)
+	uidCookie := resp.Cookies()[0]
+	assert.Equal(t, "user_id", uidCookie.Name)
+	assert.True(t, tapp.uidGen.Validate([]byte(uidCookie.Value)))
+	assert.False(t, uidCookie.Secure, "cookie is sent over plain http")
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/", nil)
+	require.NoError(t, err)
+	req.Header.Set("X-Forwarded-Proto", "https")
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	require.Len(t, resp.Cookies(), 1)
+	assert.True(t, resp.Cookies()[0].Secure, "cookie is secure behind https proxy")
+
+	resp, err = client.Get(ts.URL + "/")
+	require.NoError(t, err)
+	assert.Empty(t, resp.Cookies(), "valid cookie is not reissued")
+
+	resp, err = client.PostForm(ts.URL+"/create", url.Values{"data": {"# First paste"}, "syntax": {"markdown"}})
+	require.NoError(t, err)
+	firstPath := resp.Request.URL.Path
+	resp, err = client.PostForm(ts.URL+"/create", url.Values{"data": {"second paste"}})
+	require.NoError(t, err)
+	secondPath := resp.Request.URL.Path
+
+	resp, err = client.Get(ts.URL + "/my")
+	require.NoError(t, err)
+	body, _ := ioutil.ReadAll(resp.Body)
+	assert.Contains(t, string(body), firstPath)
+	assert.Contains(t, string(body), "First paste")
+	assert.Contains(t, string(body), secondPath)
+
+	resp, err = client.PostForm(ts.URL+secondPath+"/delete", nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, err = client.Get(ts.URL + "/my")
+	require.NoError(t, err)
+	body, _ = ioutil.ReadAll(resp.Body)
+	assert.Contains(t, string(body), firstPath)
+	assert.NotContains(t, string(body), secondPath)
+
+	otherClient := ts.Client()
+	otherClient.Jar, _ = cookiejar.New(nil)
+	resp, err = otherClient.Get(ts.URL + "/my")
+	require.NoError(t, err)
+	body, _ = ioutil.ReadAll(resp.Body)
+	assert.NotContains(t, string(body), firstPath)
+}
+
+func TestBurnAfterReading(t *testing.T) {
+	tapp, teardown := createNewTestApp(t)
+	defer teardown()
+
+	ts := httptest.NewServer(tapp.Routes())
+	defer ts.Close()
+
+	createBurnPaste := func(text string) string {
+		resp, err := ts.Client().PostForm(ts.URL+"/create", url.Values{"data": {text}, "burn": {"true"}})
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		body, _ := ioutil.ReadAll(resp.Body)
+		assert.NotContains(t, string(body), text, "content is not shown before confirmation")
+		return resp.Request.URL.Path
+	}
+
+	pagePath := createBurnPaste("top secret")
+	for i := 0; i < 2; i++ {
+		resp, err := ts.Client().Get(ts.URL + pagePath)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "no-store", resp.Header.Get("Cache-Control"))
+		body, _ := ioutil.ReadAll(resp.Body)
+		assert.NotContains(t, string(body), "top secret", "preview does not reveal paste")
+	}
+
+	resp, err := ts.Client().Post(ts.URL+pagePath, "", nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	body, _ := ioutil.ReadAll(resp.Body)
+	assert.Contains(t, string(body), "top secret")
+
+	resp, err = ts.Client().Post(ts.URL+pagePath, "", nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	resp, err = ts.Client().Get(ts.URL + pagePath)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	pagePath = createBurnPaste("plain secret")
+	resp, err = ts.Client().Get(ts.URL + pagePath + "/text")
+	require.NoError(t, err)
+	body, _ = ioutil.ReadAll(resp.Body)
+	assert.NotContains(t, string(body), "plain secret")
+	resp, err = ts.Client().Post(ts.URL+pagePath+"/text", "", nil)
+	require.NoError(t, err)
+	body, _ = ioutil.ReadAll(resp.Body)
+	assert.Equal(t, "plain secret", string(body))
+	resp, err = ts.Client().Post(ts.URL+pagePath+"/text", "", nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp, err = ts.Client().Post(ts.URL+"/api/v1/pastes", "application/json", strings.NewReader(`{"text": "api secret", "burn": true}`))
+	require.NoError(t, err)
+	created := &CreatePasteResponse{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(created))
+	resp, err = ts.Client().Get(ts.URL + "/api/v1/pastes/" + created.ID)
+	require.NoError(t, err)
+	paste := &PasteResponse{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(paste))
+	assert.Equal(t, "api secret", paste.Content)
+	assert.True(t, paste.Burn)
+	resp, err = ts.Client().Get(ts.URL + "/api/v1/pastes/" + created.ID)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestPasswordProtectedPaste(t *testing.T) {
+	tapp, teardown := createNewTestApp(t)
+	defer teardown()
+
+	ts := httptest.NewServer(tapp.Routes())
+	defer ts.Close()
+
+	resp, err := ts.Client().PostForm(ts.URL+"/create", url.Values{"data": {"db password: hunter2"}, "password": {"s3cret"}})
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	body, _ := ioutil.ReadAll(resp.Body)
+	assert.NotContains(t, string(body), "hunter2", "content is not shown without password")
+	pagePath := resp.Request.URL.Path
+	docID := strings.TrimPrefix(pagePath, "/p/")
+
+	data, meta, err := tapp.blobStore.GetBlob(docID)
+	require.NoError(t, err)
+	stored, _ := ioutil.ReadAll(data)
+	assert.NotContains(t, string(stored), "hunter2", "plaintext is not stored")
+	assert.Equal(t, passwordEncryption, meta["encryption"])
+
+	for _, path := range []string{pagePath, pagePath + "/text"} {
+		resp, err = ts.Client().Get(ts.URL + path)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		body, _ = ioutil.ReadAll(resp.Body)
+		assert.NotContains(t, string(body), "hunter2")
+		assert.Contains(t, string(body), `name="password"`)
+
+		resp, err = ts.Client().PostForm(ts.URL+path, url.Values{"password": {"wrong"}})
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+		body, _ = ioutil.ReadAll(resp.Body)
+		assert.NotContains(t, string(body), "hunter2")
+		assert.Contains(t, string(body), "Wrong password")
+
+		resp, err = ts.Client().PostForm(ts.URL+path, url.Values{"password": {"s3cret"}})
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		body, _ = ioutil.ReadAll(resp.Body)
+		assert.Contains(t, string(body), "db password: hunter2")
+	}
+
+	req, err := http.NewRequest("GET", ts.URL+"/api/v1/pastes/"+docID, nil)
+	require.NoError(t, err)
+	resp, err = ts.Client().Do(req)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	req.Header.Set("X-Paste-Password", "s3cret")
+	resp, err = ts.Client().Do(req)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	paste := &PasteResponse{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(paste))
+	assert.Equal(t, "db password: hunter2", paste.Content)
+	assert.True(t, paste.Protected)
+
+	resp, err = ts.Client().Post(ts.URL+"/api/v1/pastes", "application/json", strings.NewReader(`{"text": "foo", "password": "s3cret"}`))
+	require.NoError(t, err)
+	created := &CreatePasteResponse{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(created))
+	resp, err = ts.Client().PostForm(ts.URL+"/p/"+created.ID+"/edit/open", url.Values{"token": {created.DeleteToken}})
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "protected paste can't be edited")
+	req, err = http.NewRequest("PUT", ts.URL+"/api/v1/pastes/"+created.ID, strings.NewReader(`{"text": "bar"}`))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Paste-Token", created.DeleteToken)
+	resp, err = ts.Client().Do(req)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestPasswordAttemptsLimit(t *testing.T) {
+	tapp, teardown := createNewTestApp(t)
+	defer teardown()
+
+	ts := httptest.NewServer(tapp.Routes())
+	defer ts.Close()
+
+	resp, err := ts.Client().PostForm(ts.URL+"/create", url.Values{"data": {"hunter2"}, "password": {"s3cret"}})
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	pagePath := resp.Request.URL.Path
+
+	for i := 0; i < passwordAttemptsPerIP; i++ {
+		resp, err = ts.Client().PostForm(ts.URL+pagePath, url.Values{"password": {"wrong"}})
+		require.NoError(t, err)
+		require.Equal(t, http.StatusForbidden, resp.StatusCode)
+	}
+	resp, err = ts.Client().PostForm(ts.URL+pagePath, url.Values{"password": {"s3cret"}})
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode, "password is not checked after limit is reached")
+	assert.NotEmpty(t, resp.Header.Get("Retry-After"))
+	body, _ := ioutil.ReadAll(resp.Body)
+	assert.NotContains(t, string(body), "hunter2")
+	assert.Contains(t, string(body), "Too many attempts")
+
+	req, err := http.NewRequest("GET", ts.URL+"/api/v1/pastes"+strings.TrimPrefix(pagePath, "/p"), nil)
+	require.NoError(t, err)
+	req.Header.Set("X-Paste-Password", "s3cret")
+	resp, err = ts.Client().Do(req)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+
+	// limit of paste is shared by clients
+	tapp.passwordAttempts = newAttemptLimiter(passwordAttemptsWindow)
+	for i := 0; i < passwordAttemptsPerPaste; i++ {
+		require.True(t, tapp.allowPasswordAttempt("10.0.0."+strconv.Itoa(i), "paste"))
+	}
+	assert.False(t, tapp.allowPasswordAttempt("10.0.1.1", "paste"))
+	assert.True(t, tapp.allowPasswordAttempt("10.0.1.1", "other"))
+	assert.True(t, tapp.passwordAttempts.Allow(time.Now().Add(passwordAttemptsWindow),
+		limitedKey{key: "paste:paste", limit: passwordAttemptsPerPaste}), "counters are reset in next window")
+}
+
+func TestClientEncryptedPaste(t *testing.T) {
+	tapp, teardown := createNewTestApp(t)
+	defer teardown()
+
+	ts := httptest.NewServer(tapp.Routes())
+	defer ts.Close()
+
+	postPaste := func(body string) *http.Response {
+		resp, err := ts.Client().Post(ts.URL+"/api/v1/pastes", "application/json", strings.NewReader(body))
+		require.NoError(t, err)
+		return resp
+	}
+
+	resp := postPaste(`{"text": "# not encrypted", "syntax": "encrypted"}`)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "plain text rejected")
+	resp = postPaste(`{"text": "{\"v\": 1, \"iv\": \"AAAA\", \"ct\": \"AAAA\"}", "syntax": "encrypted"}`)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "short nonce rejected")
+	resp = postPaste(`{"text": "{\"v\": 3, \"iv\": \"AAECAwQFBgcICQoL\", \"ct\": \"AAAA\"}", "syntax": "encrypted"}`)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "unknown version rejected")
+	resp = postPaste(`{"text": "{\"v\": 1, \"iv\": \"AAECAwQFBgcICQoL\", \"ct\": \"AAAA\"}", "syntax": "encrypted"}`)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode, "text format is accepted")
+
+	encrypted := `{"v":2,"iv":"AAECAwQFBgcICQoL","ct":"IyBzZWNyZXQ="}`
+	reqBody, err := json.Marshal(&CreatePasteRequest{Text: encrypted, Syntax: encryptedSyntax})
+	require.NoError(t, err)
+	resp = postPaste(string(reqBody))
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	created := &CreatePasteResponse{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(created))
+
+	data, _, err := tapp.blobStore.GetBlob(created.ID)
+	require.NoError(t, err)
+	stored, _ := ioutil.ReadAll(data)
+	assert.Equal(t, encrypted, string(stored), "ciphertext is stored as is")
+
+	for _, path := range []string{"/p/" + created.ID, "/p/" + created.ID + "?syntax=markdown"} {
+		resp, err = ts.Client().Get(ts.URL + path)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		body, _ := ioutil.ReadAll(resp.Body)
+		assert.Contains(t, string(body), `id="encrypted-paste"`)
+		assert.Contains(t, string(body), "IyBzZWNyZXQ=")
+		assert.Contains(t, string(body), "/public/encrypted.js")
+	}
+
+	resp, err = ts.Client().Get(ts.URL + "/api/v1/pastes/" + created.ID)
+	require.NoError(t, err)
+	paste := &PasteResponse{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(paste))
+	assert.Equal(t, encrypted, paste.Content)
+	assert.Equal(t, encryptedSyntax, paste.Syntax)
+
+	resp, err = ts.Client().PostForm(ts.URL+"/p/"+created.ID+"/edit/open", url.Values{"token": {created.DeleteToken}})
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "encrypted paste can't be edited")
+
+	resp, err = ts.Client().Get(ts.URL + "/public/encrypted.js")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestPasteRevisions(t *testing.T) {
+	tapp, teardown := createNewTestApp(t)
+	defer teardown()
+
+	ts := httptest.NewServer(tapp.Routes())
+	defer ts.Close()
+
+	resp, err := ts.Client().Post(ts.URL+"/api/v1/pastes", "application/json",
+		strings.NewReader(`{"text": "step one\nstep two\n", "syntax": "text"}`))
+	require.NoError(t, err)
+	created := &CreatePasteResponse{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(created))
+	pagePath := "/p/" + created.ID
+
+	getBody := func(path string, expectedCode int) string {
+		resp, err := ts.Client().Get(ts.URL + path)
+		require.NoError(t, err)
+		require.Equal(t, expectedCode, resp.StatusCode, path)
+		body, _ := ioutil.ReadAll(resp.Body)
+		return string(body)
+	}
+
+	getBody(pagePath+"/history", http.StatusOK)
+	assert.NotContains(t, getBody(pagePath, http.StatusOK), "/history", "no history link for single revision")
+
+	for _, text := range []string{"step one\nstep 2\n", "step one\nstep 2\nstep three\n"} {
+		body, err := json.Marshal(&CreatePasteRequest{Text: text, Syntax: "text"})
+		require.NoError(t, err)
+		req, err := http.NewRequest("PUT", ts.URL+"/api/v1/pastes/"+created.ID, strings.NewReader(string(body)))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Paste-Token", created.DeleteToken)
+		resp, err := ts.Client().Do(req)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+
+	assert.Contains(t, getBody(pagePath, http.StatusOK), pagePath+"/history")
+	history := getBody(pagePath+"/history", http.StatusOK)
+	for _, rev := range []string{"/v/1", "/v/2", "/v/3"} {
+		assert.Contains(t, history, pagePath+rev)
+	}
+
+	assert.Equal(t, "step one\nstep two\n", getBody(pagePath+"/v/1/text", http.StatusOK))
+	assert.Equal(t, "step one\nstep 2\n", getBody(pagePath+"/v/2/text", http.StatusOK))
+	assert.Equal(t, "step one\nstep 2\nstep three\n", getBody(pagePath+"/v/3/text", http.StatusOK))
+	assert.Contains(t, getBody(pagePath+"/v/1", http.StatusOK), "Revision #1 of 3")
+	getBody(pagePath+"/v/4", http.StatusNotFound)
+	getBody(pagePath+"/v/0", http.StatusNotFound)
+
+	diffPage := getBody(pagePath+"/diff?from=1&to=3", http.StatusOK)
+	assert.Regexp(t, `diff-delete">\s*<td class="diff-num">2</td>\s*<td class="diff-num"></td>\s*<td class="diff-text">-step two`, diffPage)
+	assert.Contains(t, diffPage, "+step three")
+	assert.Contains(t, getBody(pagePath+"/diff", http.StatusOK), "+step three", "latest changes by default")
+	assert.NotContains(t, getBody(pagePath+"/diff?to=2", http.StatusOK), "step three")
+	getBody(pagePath+"/diff?from=1&to=5", http.StatusNotFound)
+
+	req, err := http.NewRequest("DELETE", ts.URL+"/api/v1/pastes/"+created.ID, nil)
+	require.NoError(t, err)
+	req.Header.Set("X-Paste-Token", created.DeleteToken)
+	resp, err = ts.Client().Do(req)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+	for rev := 1; rev <= 2; rev++ {
+		data, _, err := tapp.blobStore.GetBlob(revisionKey(created.ID, rev))
+		require.NoError(t, err)
+		assert.Nil(t, data, "revision %d deleted", rev)
+	}
+}
+
+func TestForkPaste(t *testing.T) {
+	tapp, teardown := createNewTestApp(t)
+	defer teardown()
+
+	ts := httptest.NewServer(tapp.Routes())
+	defer ts.Close()
+
+	getBody := func(path string, expectedCode int) string {
+		resp, err := ts.Client().Get(ts.URL + path)
+		require.NoError(t, err)
+		require.Equal(t, expectedCode, resp.StatusCode, path)
+		body, _ := ioutil.ReadAll(resp.Body)
+		return string(body)
+	}
+
+	resp, err := ts.Client().PostForm(ts.URL+"/create", url.Values{"data": {"print('hi')"}, "syntax": {"python"}})
+	require.NoError(t, err)
+	parentPath := resp.Request.URL.Path
+	parentID := strings.TrimPrefix(parentPath, "/p/")
+	assert.Contains(t, getBody(parentPath, http.StatusOK), parentPath+"/fork")
+
+	editor := getBody(parentPath+"/fork", http.StatusOK)
+	assert.Contains(t, editor, "print(&#39;hi&#39;)")
+	assert.Contains(t, editor, `name="forked_from" value="`+parentID+`"`)
+	assert.Contains(t, editor, `value="python" selected`)
+
+	resp, err = ts.Client().PostForm(ts.URL+"/create", url.Values{
+		"data":        {"print('hello')"},
+		"syntax":      {"python"},
+		"forked_from": {parentID},
+	})
+	require.NoError(t, err)
+	forkPath := resp.Request.URL.Path
+	forkID := strings.TrimPrefix(forkPath, "/p/")
+	_, meta, err := tapp.blobStore.GetBlob(forkID)
+	require.NoError(t, err)
+	assert.Equal(t, parentID, meta["forked_from"])
+
+	assert.Contains(t, getBody(forkPath, http.StatusOK), `Forked from <a href="`+parentPath+`">`)
+	assert.Contains(t, getBody(parentPath, http.StatusOK), `<a href="`+forkPath+`">`)
+
+	resp, err = ts.Client().Post(ts.URL+"/api/v1/pastes", "application/json", strings.NewReader(`{"text": "secret", "burn": true}`))
+	require.NoError(t, err)
+	created := &CreatePasteResponse{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(created))
+	getBody("/p/"+created.ID+"/fork", http.StatusNotFound)
+
+	require.NoError(t, tapp.deletePaste(context.Background(), forkID, meta))
+	assert.NotContains(t, getBody(parentPath, http.StatusOK), `<a href="`+forkPath+`">`)
+
+	_, err = ts.Client().PostForm(ts.URL+"/create", url.Values{"data": {"print('bye')"}, "forked_from": {parentID}})
+	require.NoError(t, err)
+	forksPrefix := indexKeyPrefix + forksIndexName(parentID) + "/"
+	keys, err := tapp.blobStore.(KeyLister).ListKeys(forksPrefix, "", 10)
+	require.NoError(t, err)
+	assert.Len(t, keys, 1)
+	parentMeta, err := tapp.loadPasteMeta(context.Background(), parentID)
+	require.NoError(t, err)
+	require.NoError(t, tapp.deletePaste(context.Background(), parentID, parentMeta))
+	keys, err = tapp.blobStore.(KeyLister).ListKeys(forksPrefix, "", 10)
+	require.NoError(t, err)
+	assert.Empty(t, keys, "forks index is removed with paste")
+}
+
+func TestPasteDiff(t *testing.T) {
+	tapp, teardown := createNewTestApp(t)
+	defer teardown()
+
+	ts := httptest.NewServer(tapp.Routes())
+	defer ts.Close()
+
+	createPaste := func(text string, syntax string) string {
+		resp, err := ts.Client().PostForm(ts.URL+"/create", url.Values{"data": {text}, "syntax": {syntax}})
+		require.NoError(t, err)
+		return strings.TrimPrefix(resp.Request.URL.Path, "/p/")
+	}
+	getBody := func(path string, expectedCode int) string {
+		resp, err := ts.Client().Get(ts.URL + path)
+		require.NoError(t, err)
+		require.Equal(t, expectedCode, resp.StatusCode, path)
+		body, _ := ioutil.ReadAll(resp.Body)
+		return string(body)
+	}
+
+	before := createPaste("listen: 80\nworkers: 4\nlog: info\n", "yaml")
+	after := createPaste("listen: 80\nworkers: 8\nlog: info\n", "yaml")
+
+	unified := getBody("/diff/"+before+"/"+after, http.StatusOK)
+	assert.Contains(t, unified, `<tr class="diff-delete">`)
+	assert.Contains(t, unified, `<tr class="diff-insert">`)
+	assert.Contains(t, unified, "<span style=", "lines are highlighted")
+	assert.Contains(t, unified, "/diff/"+before+"/"+after+"?view=split")
+	assert.Contains(t, unified, "/api/v1/diff/"+before+"/"+after)
+
+	split := getBody("/diff/"+before+"/"+after+"?view=split", http.StatusOK)
+	assert.Contains(t, split, `class="diff diff-split"`)
+	assert.Regexp(t, `(?s)diff-text diff-delete">.*4.*diff-text diff-insert">.*8`, split)
+
+	assert.Contains(t, getBody("/diff/"+before+"/"+before, http.StatusOK), "No changes")
+	getBody("/diff/"+before+"/unknown", http.StatusNotFound)
+
+	raw := getBody("/api/v1/diff/"+before+"/"+after, http.StatusOK)
+	assert.Equal(t, "--- "+before+"\n+++ "+after+"\n@@ -1,3 +1,3 @@\n listen: 80\n-workers: 4\n+workers: 8\n log: info\n", raw)
+	getBody("/api/v1/diff/unknown/"+after, http.StatusNotFound)
+
+	noNewline := createPaste("listen: 80\nworkers: 8\nlog: info", "yaml")
+	assert.Contains(t, getBody("/diff/"+after+"/"+noNewline, http.StatusOK), `<span class="diff-no-newline">`)
+	raw = getBody("/api/v1/diff/"+after+"/"+noNewline, http.StatusOK)
+	assert.True(t, strings.HasSuffix(raw, "-log: info\n+log: info\n\\ No newline at end of file\n"), raw)
+}
+
+func TestStorageTimeout(t *testing.T) {
+	tapp, teardown := createNewTestApp(t)
+	defer teardown()
+
+	key, _, err := tapp.savePaste(context.Background(), &CreatePasteRequest{Text: "foo"})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = tapp.getDocument(ctx, key)
+	assert.True(t, errors.Is(err, context.Canceled), "operation is cancelled: %v", err)
+	_, _, err = tapp.savePaste(ctx, &CreatePasteRequest{Text: "bar"})
+	assert.True(t, errors.Is(err, context.Canceled), "operation is cancelled: %v", err)
+
+	tapp.cfg.StorageTimeout = time.Nanosecond
+	ts := httptest.NewServer(tapp.Routes())
+	defer ts.Close()
+
+	resp, err := ts.Client().Get(ts.URL + "/p/" + key)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusGatewayTimeout, resp.StatusCode)
+	resp, err = ts.Client().Get(ts.URL + "/api/v1/pastes/" + key)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusGatewayTimeout, resp.StatusCode)
+	resp, err = ts.Client().Get(ts.URL + "/ping")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
@@ -0,0 +1,126 @@
+package app
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/fs"
+	"log"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/vdimir/markify/view"
+)
+
+// assetCacheControl is used for static files requested with current fingerprint, url changes with content
+const assetCacheControl = "public, max-age=31536000, immutable"
+
+// contentHash returns hex encoded hash of data
+func contentHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// quoteETag makes strong entity tag from parts
+func quoteETag(parts ...string) string {
+	if len(parts) == 1 {
+		return `"` + parts[0] + `"`
+	}
+	return `"` + contentHash([]byte(strings.Join(parts, "\x00")))[:32] + `"`
+}
+
+// checkNotModified sets validators of response and writes 304 Not Modified
+// if client has the same version of resource. If-None-Match takes precedence over If-Modified-Since
+func checkNotModified(w http.ResponseWriter, r *http.Request, etag string, modTime time.Time) bool {
+	w.Header().Set("ETag", etag)
+	if !modTime.IsZero() {
+		w.Header().Set("Last-Modified", modTime.UTC().Format(http.TimeFormat))
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		if !etagMatch(inm, etag) {
+			return false
+		}
+	} else if ims := r.Header.Get("If-Modified-Since"); ims != "" && !modTime.IsZero() {
+		since, err := http.ParseTime(ims)
+		// Last-Modified has second precision
+		if err != nil || modTime.Truncate(time.Second).After(since) {
+			return false
+		}
+	} else {
+		return false
+	}
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// etagMatch checks If-None-Match header value against etag using weak comparison
+func etagMatch(header string, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// assetFingerprints returns short content hashes of files in directory of static fs
+func assetFingerprints(staticFs fs.FS, dir string) map[string]string {
+	res := map[string]string{}
+	err := fs.WalkDir(staticFs, dir, func(filePath string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := fs.ReadFile(staticFs, filePath)
+		if err != nil {
+			return err
+		}
+		res[strings.TrimPrefix(filePath, dir+"/")] = contentHash(data)[:12]
+		return nil
+	})
+	if err != nil {
+		log.Printf("[WARN] can't fingerprint static files: %s", err)
+	}
+	return res
+}
+
+// pagesVersion returns hash of templates and static files, pages are changed when it changes
+func pagesVersion(assetVersions map[string]string) string {
+	names := make([]string, 0, len(assetVersions))
+	for name := range assetVersions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := []string{view.TemplatesVersion()}
+	for _, name := range names {
+		parts = append(parts, name, assetVersions[name])
+	}
+	return contentHash([]byte(strings.Join(parts, "\x00")))
+}
+
+// assetURL returns url of static file with fingerprint, so it can be cached forever
+func (app *App) assetURL(name string) string {
+	url := path.Join("/public", name)
+	if version, ok := app.assetVersion(name); ok {
+		url += "?v=" + version
+	}
+	return url
+}
+
+// assetVersion returns fingerprint of static file, in debug mode it's computed on every call
+func (app *App) assetVersion(name string) (string, bool) {
+	if !app.cfg.Debug {
+		version, ok := app.assetVersions[name]
+		return version, ok
+	}
+	data, err := fs.ReadFile(app.staticFs, path.Join("public", name))
+	if err != nil {
+		return "", false
+	}
+	return contentHash(data)[:12], true
+}
@@ -0,0 +1,129 @@
+package app
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConditionalGet(t *testing.T) {
+	tapp, teardown := createNewTestApp(t)
+	defer teardown()
+
+	ts := httptest.NewServer(tapp.Routes())
+	defer ts.Close()
+
+	docID, token, err := tapp.savePaste(context.Background(), &CreatePasteRequest{Text: "# Runbook", Syntax: "markdown"})
+	require.NoError(t, err)
+
+	get := func(path string, header map[string]string) *http.Response {
+		req, err := http.NewRequest("GET", ts.URL+path, nil)
+		require.NoError(t, err)
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		_, err = ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp
+	}
+
+	for _, path := range []string{"/p/" + docID, "/p/" + docID + "/text"} {
+		resp := get(path, nil)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		etag := resp.Header.Get("ETag")
+		assert.Regexp(t, `^"[0-9a-f]+"$`, etag)
+		assert.Equal(t, "no-cache", resp.Header.Get("Cache-Control"))
+		lastModified, err := http.ParseTime(resp.Header.Get("Last-Modified"))
+		require.NoError(t, err)
+		assert.WithinDuration(t, time.Now(), lastModified, time.Minute)
+
+		assert.Equal(t, etag, get(path, nil).Header.Get("ETag"), "etag is stable")
+		assert.Equal(t, http.StatusNotModified, get(path, map[string]string{"If-None-Match": etag}).StatusCode)
+		assert.Equal(t, http.StatusNotModified, get(path, map[string]string{"If-None-Match": `"other", W/` + etag}).StatusCode)
+		assert.Equal(t, http.StatusOK, get(path, map[string]string{"If-None-Match": `"other"`}).StatusCode)
+		assert.Equal(t, http.StatusNotModified,
+			get(path, map[string]string{"If-Modified-Since": resp.Header.Get("Last-Modified")}).StatusCode)
+		assert.Equal(t, http.StatusOK,
+			get(path, map[string]string{"If-Modified-Since": lastModified.Add(-time.Hour).Format(http.TimeFormat)}).StatusCode)
+		// If-None-Match takes precedence
+		assert.Equal(t, http.StatusOK, get(path, map[string]string{
+			"If-None-Match":     `"other"`,
+			"If-Modified-Since": resp.Header.Get("Last-Modified"),
+		}).StatusCode)
+	}
+
+	pageETag := get("/p/"+docID, nil).Header.Get("ETag")
+	textETag := get("/p/"+docID+"/text", nil).Header.Get("ETag")
+	assert.NotEqual(t, pageETag, get("/p/"+docID+"?syntax=text", nil).Header.Get("ETag"))
+	ownerResp := get("/p/"+docID, map[string]string{"X-Paste-Token": token})
+	assert.NotEqual(t, pageETag, ownerResp.Header.Get("ETag"))
+	assert.Equal(t, "private, no-cache", ownerResp.Header.Get("Cache-Control"))
+
+	resp, err := http.PostForm(ts.URL+"/p/"+docID+"/edit",
+		url.Values{"data": {"# Updated runbook"}, "syntax": {"markdown"}, "token": {token}})
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, http.StatusOK, get("/p/"+docID, map[string]string{"If-None-Match": pageETag}).StatusCode)
+	assert.Equal(t, http.StatusOK, get("/p/"+docID+"/text", map[string]string{"If-None-Match": textETag}).StatusCode)
+
+	// etag of text depends only on content, paste saved before its hash was stored gets the same one
+	textETag = get("/p/"+docID+"/text", nil).Header.Get("ETag")
+	assert.Equal(t, quoteETag(contentHash([]byte("# Updated runbook"))), textETag)
+	_, meta, err := tapp.blobStore.GetBlob(docID)
+	require.NoError(t, err)
+	delete(meta, "content_hash")
+	require.NoError(t, tapp.blobStore.SetBlob(docID, strings.NewReader("# Updated runbook"), meta, 0))
+	assert.Equal(t, textETag, get("/p/"+docID+"/text", nil).Header.Get("ETag"))
+	assert.Equal(t, http.StatusNotModified, get("/p/"+docID+"/text", map[string]string{"If-None-Match": textETag}).StatusCode)
+
+	burnID, _, err := tapp.savePaste(context.Background(), &CreatePasteRequest{Text: "secret", Syntax: "text", Burn: true})
+	require.NoError(t, err)
+	resp = get("/p/"+burnID, nil)
+	assert.Equal(t, "no-store", resp.Header.Get("Cache-Control"))
+	assert.Empty(t, resp.Header.Get("ETag"))
+}
+
+func TestFingerprintedAssets(t *testing.T) {
+	tapp, teardown := createNewTestApp(t)
+	defer teardown()
+
+	ts := httptest.NewServer(tapp.Routes())
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/")
+	require.NoError(t, err)
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	match := regexp.MustCompile(`href="(/public/style.css\?v=([0-9a-f]+))"`).FindStringSubmatch(string(body))
+	require.NotNil(t, match, "stylesheet url has fingerprint")
+
+	resp, err = http.Get(ts.URL + match[1])
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, assetCacheControl, resp.Header.Get("Cache-Control"))
+	assert.Equal(t, `"`+match[2]+`"`, resp.Header.Get("ETag"))
+
+	resp, err = http.Get(ts.URL + "/public/style.css?v=outdated")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "no-cache", resp.Header.Get("Cache-Control"))
+
+	req, err := http.NewRequest("GET", ts.URL+"/public/style.css", nil)
+	require.NoError(t, err)
+	req.Header.Set("If-None-Match", `"`+match[2]+`"`)
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+}
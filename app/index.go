@@ -0,0 +1,177 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/vdimir/markify/store"
+)
+
+const indexKeyPrefix = "_index/"
+
+// indexPageSize maximal number of index entries listed at once
+const indexPageSize = 50
+
+// keyIndex keeps lists of pastes in blob store under reserved keys. Each paste is a separate empty blob
+// "_index/<name>/<entry>" with the same ttl as the paste, so entries are added and removed without
+// rewriting the whole list and expire together with pastes. Entry name starts with inverted creation time,
+// so entries are listed from the most recent one
+type keyIndex struct {
+	store Store
+}
+
+func newKeyIndex(store Store) *keyIndex {
+	return &keyIndex{store: store}
+}
+
+// indexEntry is paste listed in index
+type indexEntry struct {
+	DocID      string
+	CreateTime time.Time
+	ExpireTime time.Time         // zero if paste has no ttl
+	Meta       map[string]string // metadata stored with entry, nil if it's not loaded
+}
+
+// newIndexEntry returns entry of paste with metadata
+func newIndexEntry(docID string, meta map[string]string) indexEntry {
+	return indexEntry{DocID: docID, CreateTime: pasteCreateTime(meta), ExpireTime: pasteExpireTime(meta)}
+}
+
+// name returns name of entry in index, "<inverted create time>.<id>.<expire unix time or 0>"
+func (e indexEntry) name() string {
+	var expireTime int64
+	if !e.ExpireTime.IsZero() {
+		expireTime = e.ExpireTime.Unix()
+	}
+	return fmt.Sprintf("%019d.%s.%d", math.MaxInt64-e.CreateTime.UnixNano(), e.DocID, expireTime)
+}
+
+func (e indexEntry) expired(now time.Time) bool {
+	return !e.ExpireTime.IsZero() && !e.ExpireTime.After(now)
+}
+
+// parseIndexEntry returns entry by its name
+func parseIndexEntry(name string) (indexEntry, error) {
+	parts := strings.Split(name, ".")
+	if len(parts) != 3 || parts[1] == "" {
+		return indexEntry{}, errors.Errorf("malformed index entry %q", name)
+	}
+	invertedTime, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return indexEntry{}, errors.Wrapf(err, "malformed index entry %q", name)
+	}
+	expireTime, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return indexEntry{}, errors.Wrapf(err, "malformed index entry %q", name)
+	}
+	entry := indexEntry{DocID: parts[1], CreateTime: time.Unix(0, math.MaxInt64-invertedTime).UTC()}
+	if expireTime != 0 {
+		entry.ExpireTime = time.Unix(expireTime, 0).UTC()
+	}
+	return entry, nil
+}
+
+func indexEntryKey(name string, entry indexEntry) string {
+	return indexKeyPrefix + name + "/" + entry.name()
+}
+
+// Add puts entry to index stored by name with metadata, existing entry of the same paste is replaced
+func (idx *keyIndex) Add(ctx context.Context, name string, entry indexEntry, meta map[string]string) error {
+	var ttl time.Duration
+	if !entry.ExpireTime.IsZero() {
+		if ttl = time.Until(entry.ExpireTime); ttl <= 0 {
+			return nil
+		}
+	}
+	err := store.WithContext(ctx, idx.store).SetBlob(indexEntryKey(name, entry), bytes.NewReader(nil), meta, ttl)
+	return errors.Wrapf(err, "can't add %q to index %q", entry.DocID, name)
+}
+
+// Remove deletes entry from index stored by name
+func (idx *keyIndex) Remove(ctx context.Context, name string, entry indexEntry) error {
+	err := store.WithContext(ctx, idx.store).DeleteBlob(indexEntryKey(name, entry))
+	return errors.Wrapf(err, "can't remove %q from index %q", entry.DocID, name)
+}
+
+// RemoveAll deletes all entries of index stored by name
+func (idx *keyIndex) RemoveAll(ctx context.Context, name string) error {
+	s := store.WithContext(ctx, idx.store)
+	lister, ok := s.(KeyLister)
+	if !ok {
+		return errors.New("store can't list keys")
+	}
+	prefix := indexKeyPrefix + name + "/"
+	after := prefix
+	for {
+		keys, err := lister.ListKeys(prefix, after, indexPageSize)
+		if err != nil {
+			return errors.Wrapf(err, "can't list index %q", name)
+		}
+		if len(keys) == 0 {
+			return nil
+		}
+		for _, key := range keys {
+			if err = s.DeleteBlob(key); err != nil {
+				return errors.Wrapf(err, "can't remove index %q", name)
+			}
+		}
+		after = keys[len(keys)-1]
+	}
+}
+
+// List returns up to limit not expired entries of index stored by name following entry named after, most recent first.
+// Metadata of entries is not loaded. Returns name of the last listed entry to continue from, empty if there are no entries left
+func (idx *keyIndex) List(ctx context.Context, name string, after string, limit int) ([]indexEntry, string, error) {
+	lister, ok := store.WithContext(ctx, idx.store).(KeyLister)
+	if !ok {
+		return nil, "", errors.New("store can't list keys")
+	}
+	prefix := indexKeyPrefix + name + "/"
+	keys, err := lister.ListKeys(prefix, prefix+after, limit)
+	if err != nil {
+		return nil, "", errors.Wrapf(err, "can't list index %q", name)
+	}
+	now := time.Now()
+	entries := make([]indexEntry, 0, len(keys))
+	for _, key := range keys {
+		entry, err := parseIndexEntry(strings.TrimPrefix(key, prefix))
+		if err != nil {
+			return nil, "", err
+		}
+		if !entry.expired(now) {
+			entries = append(entries, entry)
+		}
+	}
+	next := ""
+	if len(keys) == limit {
+		next = strings.TrimPrefix(keys[len(keys)-1], prefix)
+	}
+	return entries, next, nil
+}
+
+// LoadMeta returns entries of index stored by name with their metadata, entries removed meanwhile are skipped
+func (idx *keyIndex) LoadMeta(ctx context.Context, name string, entries []indexEntry) ([]indexEntry, error) {
+	metaReader, ok := store.WithContext(ctx, idx.store).(MetaReader)
+	if !ok {
+		return nil, errors.New("store can't read metadata")
+	}
+	res := make([]indexEntry, 0, len(entries))
+	for _, entry := range entries {
+		meta, err := metaReader.GetMeta(indexEntryKey(name, entry))
+		if err != nil {
+			return nil, errors.Wrapf(err, "can't load entry %q of index %q", entry.DocID, name)
+		}
+		if meta == nil {
+			continue
+		}
+		entry.Meta = meta
+		res = append(res, entry)
+	}
+	return res, nil
+}
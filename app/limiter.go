@@ -0,0 +1,70 @@
+package app
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// limits of password attempts, key derivation is expensive and passwords shouldn't be guessed quickly
+const (
+	passwordAttemptsWindow   = time.Minute
+	passwordAttemptsPerIP    = 10
+	passwordAttemptsPerPaste = 30
+)
+
+// attemptLimiter counts attempts by keys within fixed time window.
+// Counters of all keys are reset when window ends, so memory is bounded by attempts within window
+type attemptLimiter struct {
+	mu          sync.Mutex
+	window      time.Duration
+	windowStart time.Time
+	counts      map[string]int
+}
+
+func newAttemptLimiter(window time.Duration) *attemptLimiter {
+	return &attemptLimiter{window: window, counts: map[string]int{}}
+}
+
+// limitedKey is key of attempt with maximal number of attempts within window
+type limitedKey struct {
+	key   string
+	limit int
+}
+
+// Allow counts attempt for each of keys if none of them reached its limit within current window
+func (l *attemptLimiter) Allow(now time.Time, keys ...limitedKey) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.windowStart) >= l.window {
+		l.windowStart = now
+		l.counts = map[string]int{}
+	}
+	for _, k := range keys {
+		if l.counts[k.key] >= k.limit {
+			return false
+		}
+	}
+	for _, k := range keys {
+		l.counts[k.key]++
+	}
+	return true
+}
+
+// allowPasswordAttempt checks that client may try password of paste, it's called before key is derived from password
+func (app *App) allowPasswordAttempt(client string, docID string) bool {
+	return app.passwordAttempts.Allow(time.Now(),
+		limitedKey{key: "ip:" + client, limit: passwordAttemptsPerIP},
+		limitedKey{key: "paste:" + docID, limit: passwordAttemptsPerPaste},
+	)
+}
+
+// clientAddr returns IP address of client without port
+func clientAddr(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
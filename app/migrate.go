@@ -0,0 +1,198 @@
+package app
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"io/ioutil"
+	"log"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/vdimir/markify/store"
+)
+
+const migrateBatchSize = 100
+
+// MigrateConfig describes copying of all blobs from one storage to another
+type MigrateConfig struct {
+	From   string // specification of source storage, it must be able to list keys
+	To     string // specification of target storage
+	DryRun bool   // read source blobs without writing to target
+	Resume bool   // skip blobs that target already has with the same data and metadata
+	After  string // continue with keys following this one, e.g. last key reported by interrupted migration
+}
+
+// MigrateStats counts blobs processed by migration
+type MigrateStats struct {
+	Copied  int    // blobs written to target, or would be written in dry run
+	Skipped int    // blobs already present in target
+	Expired int    // blobs expired before they were copied
+	Bytes   int64  // size of copied data
+	LastKey string // last processed key, migration can be continued after it
+}
+
+// Migrate copies every blob with its metadata and remaining ttl from one storage to another.
+// Data written to target is read back and compared with source by checksum.
+// Source is read with blobs stored without encryption and target without them,
+// so blobs are encrypted by migration to "encrypted+" storage, the storage itself included
+func Migrate(cfg MigrateConfig) (*MigrateStats, error) {
+	if _, storageType := splitStorageSpec(cfg.From); cfg.From == cfg.To && (storageType == "local" || storageType == "memory") {
+		return nil, errors.Errorf("storage of type %q can't be migrated into itself", storageType)
+	}
+	from, err := openStorage(cfg.From, true)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't open source storage")
+	}
+	defer closeStorage(from)
+	lister, ok := from.(KeyLister)
+	if !ok {
+		return nil, errors.Errorf("source storage %q can't list keys", cfg.From)
+	}
+	to, err := openStorage(cfg.To, false)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't open target storage")
+	}
+	defer closeStorage(to)
+
+	stats := &MigrateStats{LastKey: cfg.After}
+	for {
+		keys, err := lister.ListKeys("", stats.LastKey, migrateBatchSize)
+		if err != nil {
+			return stats, errors.Wrap(err, "can't list source keys")
+		}
+		if len(keys) == 0 {
+			return stats, nil
+		}
+		for _, key := range keys {
+			if err = migrateBlob(from, to, key, cfg, stats); err != nil {
+				return stats, errors.Wrapf(err, "can't migrate %q", key)
+			}
+			stats.LastKey = key
+		}
+		log.Printf("[INFO] migrated %d blobs, skipped %d, last key %q", stats.Copied, stats.Skipped, stats.LastKey)
+	}
+}
+
+// migrateBlob copies blob by key and updates stats
+func migrateBlob(from Store, to Store, key string, cfg MigrateConfig, stats *MigrateStats) error {
+	reader, meta, err := from.GetBlob(key)
+	if err != nil {
+		return errors.Wrap(err, "can't read source")
+	}
+	if reader == nil {
+		// expired or deleted after it was listed
+		stats.Expired++
+		return nil
+	}
+	data, err := ioutil.ReadAll(reader)
+	closeData(reader)
+	if err != nil {
+		return errors.Wrap(err, "can't read source")
+	}
+
+	ttl, err := blobTTL(from, key, meta)
+	if err != nil {
+		return errors.Wrap(err, "can't read source expiration time")
+	}
+	if ttl < 0 {
+		stats.Expired++
+		return nil
+	}
+
+	checksum := sha256.Sum256(data)
+	if cfg.Resume {
+		targetSum, targetMeta, err := blobChecksum(to, key)
+		if err != nil {
+			return errors.Wrap(err, "can't read target")
+		}
+		if targetSum == checksum && sameMeta(meta, targetMeta) {
+			stats.Skipped++
+			return nil
+		}
+	}
+	if cfg.DryRun {
+		stats.Copied++
+		stats.Bytes += int64(len(data))
+		return nil
+	}
+
+	if err = to.SetBlob(key, bytes.NewReader(data), meta, ttl); err != nil {
+		return errors.Wrap(err, "can't write target")
+	}
+	targetSum, _, err := blobChecksum(to, key)
+	if err != nil {
+		return errors.Wrap(err, "can't read target")
+	}
+	if targetSum != checksum {
+		return errors.New("checksum of written data mismatch")
+	}
+	stats.Copied++
+	stats.Bytes += int64(len(data))
+	return nil
+}
+
+// blobTTL returns remaining ttl of blob, zero if blob doesn't expire and negative if it's expired.
+// Expiration time is read from store if store reports it, otherwise it's computed from metadata of pastes and revisions
+func blobTTL(s Store, key string, meta map[string]string) (time.Duration, error) {
+	expireTime := pasteExpireTime(meta)
+	if expiryReader, ok := s.(ExpiryReader); ok {
+		storeExpireTime, err := expiryReader.GetExpireTime(key)
+		if err != nil && !errors.Is(err, store.ErrExpiryNotSupported) {
+			return 0, err
+		}
+		// zero time is returned for blob expired after it was read as well
+		if !storeExpireTime.IsZero() {
+			expireTime = storeExpireTime
+		}
+	}
+	if expireTime.IsZero() {
+		return 0, nil
+	}
+	if ttl := time.Until(expireTime); ttl > 0 {
+		return ttl, nil
+	}
+	return -1, nil
+}
+
+// blobChecksum returns hash of blob data and its metadata, zero hash if blob is missing or not encrypted yet
+func blobChecksum(s Store, key string) ([sha256.Size]byte, map[string]string, error) {
+	var sum [sha256.Size]byte
+	reader, meta, err := s.GetBlob(key)
+	if errors.Is(err, store.ErrNotEncrypted) {
+		return sum, nil, nil
+	}
+	if err != nil || reader == nil {
+		return sum, nil, err
+	}
+	defer closeData(reader)
+	h := sha256.New()
+	if _, err = io.Copy(h, reader); err != nil {
+		return sum, nil, err
+	}
+	copy(sum[:], h.Sum(nil))
+	return sum, meta, nil
+}
+
+func sameMeta(a map[string]string, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if bv, ok := b[k]; !ok || bv != v {
+			return false
+		}
+	}
+	return true
+}
+
+// closeStorage releases storage resources, e.g. lock of database file
+func closeStorage(s Store) {
+	closer, ok := s.(io.Closer)
+	if !ok {
+		return
+	}
+	if err := closer.Close(); err != nil {
+		log.Printf("[WARN] can't close storage: %s", err)
+	}
+}
@@ -0,0 +1,202 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vdimir/markify/store"
+	"github.com/vdimir/markify/store/storetest"
+)
+
+// createMigrateSource saves pastes to storage and closes it, returns ids of pastes
+func createMigrateSource(t *testing.T, storageSpec string) (string, string) {
+	tapp, teardown := createTestAppWithStorage(t, storageSpec)
+	defer closeStorage(tapp.blobStore)
+	defer teardown()
+
+	permanent, _, err := tapp.savePaste(context.Background(), &CreatePasteRequest{Text: "permanent", Syntax: "text", UserToken: "user"})
+	require.NoError(t, err)
+	expiring, _, err := tapp.savePaste(context.Background(), &CreatePasteRequest{Text: "expiring", Syntax: "markdown", Ttl: time.Hour})
+	require.NoError(t, err)
+
+	// paste stored without ttl in store, e.g. by backend that ignores it, expired according to metadata
+	expiredMeta := map[string]string{
+		"create_time": time.Now().Add(-2 * time.Hour).UTC().Format(time.RFC3339Nano),
+		"ttl":         time.Hour.String(),
+	}
+	require.NoError(t, tapp.blobStore.SetBlob("expired", bytes.NewReader([]byte("old")), expiredMeta, 0))
+	return permanent, expiring
+}
+
+func TestMigrate(t *testing.T) {
+	dir := t.TempDir()
+	from, to := "local:"+dir+"/bolt", "sqlite:"+dir+"/target.db"
+	permanent, expiring := createMigrateSource(t, from)
+
+	stats, err := Migrate(MigrateConfig{From: from, To: to, DryRun: true})
+	require.NoError(t, err)
+	assert.Equal(t, 3, stats.Copied, "two pastes and user index")
+	assert.Equal(t, 1, stats.Expired)
+
+	target, err := store.NewSqliteStorage(dir + "/target.db")
+	require.NoError(t, err)
+	keys, err := target.ListKeys("", "", 10)
+	require.NoError(t, err)
+	assert.Empty(t, keys, "nothing written in dry run")
+	require.NoError(t, target.Close())
+
+	stats, err = Migrate(MigrateConfig{From: from, To: to})
+	require.NoError(t, err)
+	assert.Equal(t, 3, stats.Copied)
+	assert.Equal(t, int64(len("permanent")+len("expiring")), stats.Bytes, "index entries are empty")
+
+	target, err = store.NewSqliteStorage(dir + "/target.db")
+	require.NoError(t, err)
+	storetest.RequireMissing(t, target, "expired")
+	blobs, err := target.QueryBlobs(store.Query{})
+	require.NoError(t, err)
+	expireTimes := map[string]time.Time{}
+	for _, blob := range blobs {
+		expireTimes[blob.Key] = blob.ExpireTime
+	}
+	require.Contains(t, expireTimes, permanent)
+	assert.True(t, expireTimes[permanent].IsZero())
+	require.Contains(t, expireTimes, expiring)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), expireTimes[expiring], time.Minute, "remaining ttl is kept")
+	require.NoError(t, target.Close())
+
+	migrated, teardown := createTestAppWithStorage(t, to)
+	doc, err := migrated.getDocument(context.Background(), expiring)
+	require.NoError(t, err)
+	require.NotNil(t, doc)
+	assert.Contains(t, doc.Body, "expiring")
+	entries, _, err := migrated.userPastes(context.Background(), "user", "")
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, permanent, entries[0].DocID)
+	teardown()
+	closeStorage(migrated.blobStore)
+
+	stats, err = Migrate(MigrateConfig{From: from, To: to, Resume: true})
+	require.NoError(t, err)
+	assert.Equal(t, 0, stats.Copied)
+	assert.Equal(t, 3, stats.Skipped, "already copied blobs are skipped")
+
+	stats, err = Migrate(MigrateConfig{From: from, To: to, After: stats.LastKey})
+	require.NoError(t, err)
+	assert.Equal(t, 0, stats.Copied+stats.Skipped, "nothing left after last key")
+
+	_, err = Migrate(MigrateConfig{From: "unknown:", To: to})
+	assert.Error(t, err)
+}
+
+func TestMigrateIndexExpiry(t *testing.T) {
+	dir := t.TempDir()
+	from, to := "local:"+dir+"/bolt", "sqlite:"+dir+"/target.db"
+	tapp, teardown := createTestAppWithStorage(t, from)
+	docID, _, err := tapp.savePaste(context.Background(), &CreatePasteRequest{Text: "expiring", Syntax: "text", UserToken: "user", Ttl: time.Hour})
+	require.NoError(t, err)
+	teardown()
+	closeStorage(tapp.blobStore)
+
+	stats, err := Migrate(MigrateConfig{From: from, To: to})
+	require.NoError(t, err)
+	assert.Equal(t, 2, stats.Copied, "paste and user index entry")
+
+	target, err := store.NewSqliteStorage(dir + "/target.db")
+	require.NoError(t, err)
+	defer target.Close()
+	keys, err := target.ListKeys(indexKeyPrefix, "", 10)
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+	assert.Contains(t, keys[0], docID)
+	for _, key := range []string{docID, keys[0]} {
+		expireTime, err := target.GetExpireTime(key)
+		require.NoError(t, err)
+		assert.WithinDuration(t, time.Now().Add(time.Hour), expireTime, time.Minute, "ttl of %q is kept", key)
+	}
+}
+
+func TestMigrateS3(t *testing.T) {
+	dir := t.TempDir()
+	s3 := storetest.NewS3Server(t, "pastes")
+	s3Spec := fmt.Sprintf(`s3:{"endpoint": %q, "bucket": "pastes"}`, s3.Endpoint())
+	from := "local:" + dir + "/from"
+	permanent, expiring := createMigrateSource(t, from)
+
+	stats, err := Migrate(MigrateConfig{From: from, To: "zstd+" + s3Spec})
+	require.NoError(t, err)
+	assert.Equal(t, 3, stats.Copied)
+
+	// and back to new local storage
+	to := "local:" + dir + "/to"
+	stats, err = Migrate(MigrateConfig{From: "zstd+" + s3Spec, To: to})
+	require.NoError(t, err)
+	assert.Equal(t, 3, stats.Copied)
+
+	target, err := store.NewBoltStorage(dir + "/to/data.bdb")
+	require.NoError(t, err)
+	defer target.Close()
+	keys, err := target.ListKeys("", "", 10)
+	require.NoError(t, err)
+	var pastes []string
+	for _, key := range keys {
+		if !strings.HasPrefix(key, indexKeyPrefix+userIndexName("user")+"/") {
+			pastes = append(pastes, key)
+		}
+	}
+	assert.Len(t, keys, 3, "pastes and user index entry")
+	assert.ElementsMatch(t, []string{permanent, expiring}, pastes)
+	data, meta, err := target.GetBlob(expiring)
+	require.NoError(t, err)
+	require.NotNil(t, data)
+	assert.Equal(t, "markdown", meta["syntax"])
+}
+
+func TestMigrateEncryptInPlace(t *testing.T) {
+	defer os.Setenv(encryptionKeysEnv, os.Getenv(encryptionKeysEnv))
+	key := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte("k"), 32))
+	require.NoError(t, os.Setenv(encryptionKeysEnv, "k1:"+key))
+
+	dir := t.TempDir()
+	plain, encrypted := "sqlite:"+dir+"/pastes.db", "encrypted+sqlite:"+dir+"/pastes.db"
+	permanent, _ := createMigrateSource(t, plain)
+
+	s, err := createStorage(encrypted)
+	require.NoError(t, err)
+	_, _, err = s.GetBlob(permanent)
+	assert.True(t, errors.Is(err, store.ErrNotEncrypted), "plain blobs are not read without migration")
+	closeStorage(s)
+
+	stats, err := Migrate(MigrateConfig{From: encrypted, To: encrypted, Resume: true})
+	require.NoError(t, err)
+	assert.Equal(t, 3, stats.Copied, "blobs are encrypted")
+	stats, err = Migrate(MigrateConfig{From: encrypted, To: encrypted, Resume: true})
+	require.NoError(t, err)
+	assert.Equal(t, 0, stats.Copied)
+	assert.Equal(t, 3, stats.Skipped, "encrypted blobs are skipped")
+
+	s, err = createStorage(encrypted)
+	require.NoError(t, err)
+	defer closeStorage(s)
+	data, meta, err := s.GetBlob(permanent)
+	require.NoError(t, err)
+	require.NotNil(t, data)
+	text, _ := ioutil.ReadAll(data)
+	assert.Equal(t, "permanent", string(text))
+	assert.Equal(t, "text", meta["syntax"])
+
+	_, err = Migrate(MigrateConfig{From: "encrypted+local:" + dir, To: "encrypted+local:" + dir})
+	assert.Error(t, err, "bolt file can't be opened twice")
+	assert.Contains(t, err.Error(), "can't be migrated into itself")
+}
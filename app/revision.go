@@ -0,0 +1,110 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// revisionKeyInfix separates paste id and revision number in keys of previous revisions.
+// Latest revision is stored under paste id, so pastes without edits have no extra keys
+const revisionKeyInfix = "/rev/"
+
+// Revision is version of paste saved on edit
+type Revision struct {
+	Number int
+	Time   time.Time
+}
+
+func revisionKey(docID string, rev int) string {
+	return fmt.Sprintf("%s%s%d", docID, revisionKeyInfix, rev)
+}
+
+// pasteRevision returns number of latest revision of paste, revisions are numbered from one
+func pasteRevision(meta map[string]string) int {
+	rev, err := strconv.Atoi(meta["revision"])
+	if err != nil || rev < 1 {
+		return 1
+	}
+	return rev
+}
+
+// pasteUpdateTime returns time when revision was saved
+func pasteUpdateTime(meta map[string]string) time.Time {
+	updateTime := time.Time{}
+	if err := updateTime.UnmarshalText([]byte(meta["update_time"])); err != nil {
+		return pasteCreateTime(meta)
+	}
+	return updateTime
+}
+
+// saveRevision keeps current revision of paste before it is overwritten.
+// Data is read to be copied under revision key, metadata is already loaded by caller
+func (app *App) saveRevision(ctx context.Context, docID string, meta map[string]string, ttl time.Duration) error {
+	data, _, err := app.blobs(ctx).GetBlob(docID)
+	if err != nil {
+		return errors.Wrapf(err, "can't get data")
+	}
+	if data == nil {
+		return nil
+	}
+	defer closeData(data)
+	return app.blobs(ctx).SetBlob(revisionKey(docID, pasteRevision(meta)), data, meta, ttl)
+}
+
+// loadRevision returns revision of paste with its metadata and metadata of latest revision
+func (app *App) loadRevision(ctx context.Context, docID string, rev int) (io.Reader, map[string]string, map[string]string, error) {
+	data, latestMeta, err := app.blobs(ctx).GetBlob(docID)
+	if err != nil {
+		return nil, nil, nil, errors.Wrapf(err, "can't get data")
+	}
+	if data == nil {
+		return nil, nil, nil, nil
+	}
+	if rev == pasteRevision(latestMeta) && isPlainPaste(latestMeta) {
+		return data, latestMeta, latestMeta, nil
+	}
+	closeData(data)
+	if !isPlainPaste(latestMeta) || rev < 1 || rev > pasteRevision(latestMeta) {
+		return nil, nil, nil, nil
+	}
+	data, meta, err := app.blobs(ctx).GetBlob(revisionKey(docID, rev))
+	if err != nil {
+		return nil, nil, nil, errors.Wrapf(err, "can't get revision %d", rev)
+	}
+	if data == nil {
+		return nil, nil, nil, nil
+	}
+	return data, meta, latestMeta, nil
+}
+
+// pasteRevisions returns revisions of paste starting from the latest
+func (app *App) pasteRevisions(ctx context.Context, docID string, latestMeta map[string]string) ([]Revision, error) {
+	latest := pasteRevision(latestMeta)
+	revisions := []Revision{{Number: latest, Time: pasteUpdateTime(latestMeta)}}
+	for rev := latest - 1; rev >= 1; rev-- {
+		meta, err := app.loadPasteMeta(ctx, revisionKey(docID, rev))
+		if err != nil {
+			return nil, errors.Wrapf(err, "can't get revision %d", rev)
+		}
+		if meta == nil {
+			continue
+		}
+		revisions = append(revisions, Revision{Number: rev, Time: pasteUpdateTime(meta)})
+	}
+	return revisions, nil
+}
+
+// deleteRevisions removes previous revisions of paste
+func (app *App) deleteRevisions(ctx context.Context, docID string, meta map[string]string) {
+	for rev := pasteRevision(meta) - 1; rev >= 1; rev-- {
+		if err := app.blobs(ctx).DeleteBlob(revisionKey(docID, rev)); err != nil {
+			log.Printf("[ERROR] revision %d of document %q not deleted: %s", rev, docID, err)
+		}
+	}
+}
@@ -54,15 +54,18 @@ func (app *App) Shutdown() {
 		}
 		log.Print("[DEBUG] shutdown http server completed")
 	}
+	app.stopSweeper()
 }
 func (app *App) respondError(err error, req *CreatePasteRequest, w http.ResponseWriter) {
 	if errUser, ok := err.(UserError); ok {
 		returnToPageCtx := &view.EditorContext{
-			Title:       fmt.Sprintf("%s :(", defaultTitle),
-			Msg:         errUser.String(),
+			Title:    fmt.Sprintf("%s :(", defaultTitle),
+			Msg:      errUser.String(),
+			Syntaxes: app.syntaxOptions,
 		}
 		if req != nil {
 			returnToPageCtx.InitialText = req.Text
+			returnToPageCtx.Syntax = req.Syntax
 		}
 		app.viewTemplate(http.StatusBadRequest, returnToPageCtx, w)
 	} else {
@@ -70,7 +73,36 @@ func (app *App) respondError(err error, req *CreatePasteRequest, w http.Response
 	}
 }
 
+// respondLinkError shows url prompt with error message to user
+func (app *App) respondLinkError(err error, docURL string, w http.ResponseWriter) {
+	errUser, ok := err.(UserError)
+	if !ok {
+		app.serverError(err, w)
+		return
+	}
+	ctx := &view.URLPromptContext{
+		Title:       fmt.Sprintf("%s :(", defaultTitle),
+		Msg:         errUser.String(),
+		InitialText: docURL,
+	}
+	app.viewTemplate(http.StatusBadRequest, ctx, w)
+}
+
 func (app *App) serverError(err error, w http.ResponseWriter) {
+	if errors.Is(err, context.Canceled) {
+		log.Printf("[INFO] request cancelled: %v", err)
+		return
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		log.Printf("[WARN] storage timeout: %v", err)
+		ctx := &view.StatusContext{
+			Title:     "Error",
+			HeaderMsg: "504",
+			Msg:       "Storage is not responding, try again later",
+		}
+		app.viewTemplate(http.StatusGatewayTimeout, ctx, w)
+		return
+	}
 	log.Printf("[ERROR] %v", err)
 	ctx := &view.StatusContext{
 		Title:     "Error",
@@ -119,7 +151,7 @@ func (app *App) addFixedPages(r chi.Router) {
 			if err != nil {
 				panic(err)
 			}
-			app.viewDocument(&Document{*doc, "",time.Time{}}, "", r.URL.Path, w)
+			app.viewDocument(&Document{Document: *doc}, "", r.URL.Path, w)
 		}
 		return handler
 	}
@@ -131,7 +163,7 @@ func (app *App) addFixedPages(r chi.Router) {
 			return err
 		}
 		handler := func(w http.ResponseWriter, r *http.Request) {
-			app.viewDocument(&Document{*doc, "",time.Time{}}, "", r.URL.Path, w)
+			app.viewDocument(&Document{Document: *doc}, "", r.URL.Path, w)
 		}
 
 		if app.cfg.Debug {
@@ -159,6 +191,15 @@ func (app *App) addFileServer(r chi.Router, path string) {
 			app.notFound(w, r)
 			return
 		}
+		if version, ok := app.assetVersion(chi.URLParam(r, "fileName")); ok {
+			// file server responds with 304 if ETag is set
+			w.Header().Set("ETag", quoteETag(version))
+			if r.URL.Query().Get("v") == version {
+				w.Header().Set("Cache-Control", assetCacheControl)
+			} else {
+				w.Header().Set("Cache-Control", "no-cache")
+			}
+		}
 		webFs.ServeHTTP(w, r)
 	})
 
@@ -1,7 +1,7 @@
 package app_test
 
 import (
-	"fmt"
+	"encoding/json"
 	"io"
 	"io/ioutil"
 	"net/http"
@@ -10,6 +10,7 @@ import (
 	"regexp"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -23,12 +24,10 @@ func createServer(t *testing.T, customCfg func(*app.Config)) (*app.App, func())
 	port := testutil.ChooseRandomUnusedPort()
 	require.NotEqual(t, port, 0)
 
-	tmpPath, tmpFolderClean := testutil.GetTempFolder(t, "test_app")
-
 	cfg := &app.Config{
 		Debug:        false,
 		AssetsPrefix: "assets",
-		StorageSpec:  fmt.Sprintf("local:%s", tmpPath),
+		StorageSpec:  "memory:",
 		StatusText:   `{"status": "ok"}`,
 	}
 	if customCfg != nil {
@@ -43,7 +42,6 @@ func createServer(t *testing.T, customCfg func(*app.Config)) (*app.App, func())
 	require.NoError(t, err)
 
 	return tapp, func() {
-		defer tmpFolderClean()
 		defer tapp.Shutdown()
 	}
 }
@@ -187,3 +185,145 @@ func TestServerCreatePage(t *testing.T) {
 		assert.Regexp(t, expected, respData)
 	}
 }
+
+func TestServerAPI(t *testing.T) {
+	tapp, teardown := createServer(t, nil)
+	defer teardown()
+
+	appPath := createPathHelper(tapp.Addr)
+
+	postJSON := func(body string) *http.Response {
+		resp, err := http.Post(appPath("/api/v1/pastes"), "application/json", strings.NewReader(body))
+		require.NoError(t, err)
+		assert.Contains(t, resp.Header.Get("Content-Type"), "application/json")
+		return resp
+	}
+
+	resp := postJSON(`{"text": "# Build report\n\nok", "syntax": "markdown"}`)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	created := &app.CreatePasteResponse{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(created))
+	assert.NotEmpty(t, created.ID)
+	assert.NotEmpty(t, created.DeleteToken)
+	assert.Equal(t, appPath("/p/"+created.ID), created.URL)
+	assert.Equal(t, appPath("/p/"+created.ID+"/text"), created.RawURL)
+
+	resp = getResp(t, created.RawURL, http.StatusOK)
+	assert.Equal(t, "# Build report\n\nok", mustReadAll(resp.Body))
+
+	resp = getResp(t, appPath("/api/v1/pastes/"+created.ID), http.StatusOK)
+	paste := &app.PasteResponse{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(paste))
+	assert.Equal(t, created.ID, paste.ID)
+	assert.Equal(t, "# Build report\n\nok", paste.Content)
+	assert.Equal(t, "markdown", paste.Syntax)
+	assert.False(t, paste.CreateTime.IsZero())
+	assert.Nil(t, paste.ExpireTime)
+
+	apiErr := &app.ErrorResponse{}
+	resp = getResp(t, appPath("/api/v1/pastes/__deadbeef__"), http.StatusNotFound)
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(apiErr))
+	assert.Equal(t, http.StatusNotFound, apiErr.Error.Code)
+
+	resp = postJSON(`{"text": "  "}`)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(apiErr))
+	assert.Equal(t, http.StatusBadRequest, apiErr.Error.Code)
+	assert.Equal(t, "empty input", apiErr.Error.Message)
+
+	resp = postJSON(`{"text": "foo", "syntax": "unknown_syntax"}`)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	for _, body := range []string{`{"text": "foo", "ttl": "1h"}`, `{"text": "foo", "ttl_seconds": 3600}`} {
+		resp = postJSON(body)
+		require.Equal(t, http.StatusCreated, resp.StatusCode, body)
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(created))
+		resp = getResp(t, appPath("/api/v1/pastes/"+created.ID), http.StatusOK)
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(paste))
+		require.NotNil(t, paste.ExpireTime, body)
+		assert.WithinDuration(t, time.Now().Add(time.Hour), *paste.ExpireTime, time.Minute, body)
+	}
+
+	for _, body := range []string{
+		`{"text": "foo", "ttl": 3600000000000}`,
+		`{"text": "foo", "ttl": "an hour"}`,
+		`{"text": "foo", "ttl": "-1h"}`,
+		`{"text": "foo", "ttl_seconds": "3600"}`,
+		`{"text": "foo", "ttl": "1h", "ttl_seconds": 3600}`,
+	} {
+		resp = postJSON(body)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, body)
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(apiErr))
+		assert.Contains(t, apiErr.Error.Message, "ttl", body)
+	}
+}
+
+func TestServerAPIModifyPaste(t *testing.T) {
+	tapp, teardown := createServer(t, nil)
+	defer teardown()
+
+	appPath := createPathHelper(tapp.Addr)
+
+	doRequest := func(method, path, token, body string) *http.Response {
+		req, err := http.NewRequest(method, appPath(path), strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("X-Paste-Token", token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		return resp
+	}
+
+	resp := doRequest("POST", "/api/v1/pastes", "", `{"text": "foo"}`)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	created := &app.CreatePasteResponse{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(created))
+	pastePath := "/api/v1/pastes/" + created.ID
+
+	resp = doRequest("PUT", pastePath, "", `{"text": "bar"}`)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	resp = doRequest("PUT", pastePath, "wrong_token", `{"text": "bar"}`)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	resp = doRequest("DELETE", pastePath, "wrong_token", "")
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	resp = doRequest("PUT", pastePath, created.DeleteToken, `{"text": "# bar", "syntax": "markdown"}`)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	paste := &app.PasteResponse{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(paste))
+	assert.Equal(t, "# bar", paste.Content)
+	assert.Equal(t, "markdown", paste.Syntax)
+
+	resp = getResp(t, appPath("/p/"+created.ID), http.StatusOK)
+	assert.Regexp(t, regexp.MustCompile("<h1[a-z\"= ]*>bar</h1>"), mustReadAll(resp.Body))
+
+	resp = doRequest("DELETE", pastePath, created.DeleteToken, "")
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	_ = getResp(t, appPath("/p/"+created.ID), http.StatusNotFound)
+	resp = doRequest("DELETE", pastePath, created.DeleteToken, "")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp = doRequest("POST", "/api/v1/pastes", "", `{"text": "foo", "burn": true}`)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	created = &app.CreatePasteResponse{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(created))
+	pastePath = "/api/v1/pastes/" + created.ID
+
+	resp = doRequest("PUT", pastePath, created.DeleteToken, `{"text": "bar"}`)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	paste = &app.PasteResponse{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(paste))
+	assert.Equal(t, "bar", paste.Content)
+	assert.True(t, paste.Burn)
+
+	resp = doRequest("GET", pastePath, "", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode, "burn paste isn't read on update")
+	paste = &app.PasteResponse{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(paste))
+	assert.Equal(t, "bar", paste.Content)
+	resp = doRequest("GET", pastePath, "", "")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
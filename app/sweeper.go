@@ -0,0 +1,62 @@
+package app
+
+import (
+	"log"
+	"time"
+)
+
+const sweepBatchSize = 100
+
+// startSweeper runs background goroutine that periodically removes expired blobs
+func (app *App) startSweeper(sweeper ExpirySweeper, interval time.Duration) {
+	app.sweeperStop = make(chan struct{})
+	app.sweeperWg.Add(1)
+	go func() {
+		defer app.sweeperWg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				app.sweepExpired(sweeper)
+			case <-app.sweeperStop:
+				return
+			}
+		}
+	}()
+}
+
+// stopSweeper stops sweeper goroutine and waits for it
+func (app *App) stopSweeper() {
+	if app.sweeperStop == nil {
+		return
+	}
+	close(app.sweeperStop)
+	app.sweeperWg.Wait()
+	app.sweeperStop = nil
+}
+
+// sweepExpired removes expired blobs in batches until nothing left
+func (app *App) sweepExpired(sweeper ExpirySweeper) {
+	startTime := time.Now()
+	total := 0
+	for {
+		select {
+		case <-app.sweeperStop:
+			return
+		default:
+		}
+		n, err := sweeper.DeleteExpired(time.Now(), sweepBatchSize)
+		if err != nil {
+			log.Printf("[ERROR] can't remove expired documents: %s", err)
+			return
+		}
+		total += n
+		if n < sweepBatchSize {
+			break
+		}
+	}
+	if total > 0 {
+		log.Printf("[DEBUG] %d expired documents removed in %dms", total, time.Since(startTime).Milliseconds())
+	}
+}
@@ -0,0 +1,298 @@
+// Package diff computes line based difference between texts
+package diff
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Op is kind of change applied to line
+type Op int
+
+// Line operations
+const (
+	Equal Op = iota
+	Delete
+	Insert
+)
+
+// maxEditDistance limits number of edits computed exactly,
+// texts that differ more are shown as replaced entirely to bound memory usage
+const maxEditDistance = 1024
+
+// Line is line of diff
+type Line struct {
+	Op        Op
+	Text      string
+	OldNum    int  // line number in old text starting from 1, zero for inserted line
+	NewNum    int  // line number in new text starting from 1, zero for deleted line
+	NoNewline bool // line is the last one of text that doesn't end with newline
+}
+
+// Hunk is group of changed lines with surrounding context
+type Hunk struct {
+	OldStart int
+	OldLines int
+	NewStart int
+	NewLines int
+	Lines    []Line
+}
+
+// Header returns hunk range header in unified format
+func (h *Hunk) Header() string {
+	return fmt.Sprintf("@@ -%s +%s @@", hunkRange(h.OldStart, h.OldLines), hunkRange(h.NewStart, h.NewLines))
+}
+
+func hunkRange(start int, count int) string {
+	if count == 1 {
+		return fmt.Sprintf("%d", start)
+	}
+	if count == 0 {
+		// empty range is denoted by line before it
+		start--
+	}
+	return fmt.Sprintf("%d,%d", start, count)
+}
+
+// Lines returns line diff transforming text a to b.
+// The last line without newline differs from the same line followed by newline
+func Lines(a string, b string) []Line {
+	oldLines, newLines := splitLines(a), splitLines(b)
+
+	prefix := 0
+	for prefix < len(oldLines) && prefix < len(newLines) && oldLines[prefix] == newLines[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(oldLines)-prefix && suffix < len(newLines)-prefix &&
+		oldLines[len(oldLines)-1-suffix] == newLines[len(newLines)-1-suffix] {
+		suffix++
+	}
+
+	ops := make([]Op, 0, len(oldLines)+len(newLines))
+	for i := 0; i < prefix; i++ {
+		ops = append(ops, Equal)
+	}
+	ops = append(ops, myers(oldLines[prefix:len(oldLines)-suffix], newLines[prefix:len(newLines)-suffix])...)
+	for i := 0; i < suffix; i++ {
+		ops = append(ops, Equal)
+	}
+
+	res := make([]Line, 0, len(ops))
+	oldIdx, newIdx := 0, 0
+	for _, op := range ops {
+		switch op {
+		case Equal:
+			res = append(res, newLine(Equal, oldLines[oldIdx], oldIdx+1, newIdx+1))
+			oldIdx++
+			newIdx++
+		case Delete:
+			res = append(res, newLine(Delete, oldLines[oldIdx], oldIdx+1, 0))
+			oldIdx++
+		case Insert:
+			res = append(res, newLine(Insert, newLines[newIdx], 0, newIdx+1))
+			newIdx++
+		}
+	}
+	return res
+}
+
+// newLine returns line of diff with text split by splitLines
+func newLine(op Op, text string, oldNum int, newNum int) Line {
+	noNewline := strings.HasSuffix(text, noNewlineMark)
+	return Line{Op: op, Text: strings.TrimSuffix(text, noNewlineMark), OldNum: oldNum, NewNum: newNum, NoNewline: noNewline}
+}
+
+// Hunks groups changed lines with context lines around them
+func Hunks(lines []Line, context int) []Hunk {
+	var hunks []Hunk
+	i := 0
+	for i < len(lines) {
+		if lines[i].Op == Equal {
+			i++
+			continue
+		}
+		start := i - context
+		if start < 0 {
+			start = 0
+		}
+		// extend hunk while next change is close enough to share context
+		end := i
+		for end < len(lines) {
+			if lines[end].Op != Equal {
+				end++
+				continue
+			}
+			next := end
+			for next < len(lines) && lines[next].Op == Equal {
+				next++
+			}
+			if next == len(lines) || next-end > 2*context {
+				end += context
+				if end > len(lines) {
+					end = len(lines)
+				}
+				break
+			}
+			end = next
+		}
+		oldBefore, newBefore := 0, 0
+		for _, l := range lines[:start] {
+			if l.Op != Insert {
+				oldBefore++
+			}
+			if l.Op != Delete {
+				newBefore++
+			}
+		}
+		hunks = append(hunks, newHunk(lines[start:end], oldBefore, newBefore))
+		i = end
+	}
+	return hunks
+}
+
+func newHunk(lines []Line, oldBefore int, newBefore int) Hunk {
+	h := Hunk{Lines: lines, OldStart: oldBefore + 1, NewStart: newBefore + 1}
+	for _, l := range lines {
+		if l.Op != Insert {
+			h.OldLines++
+		}
+		if l.Op != Delete {
+			h.NewLines++
+		}
+	}
+	return h
+}
+
+// Unified returns diff between a and b in unified format
+func Unified(fromName string, toName string, a string, b string, context int) string {
+	hunks := Hunks(Lines(a, b), context)
+	if len(hunks) == 0 {
+		return ""
+	}
+	buf := &strings.Builder{}
+	fmt.Fprintf(buf, "--- %s\n+++ %s\n", fromName, toName)
+	for _, h := range hunks {
+		buf.WriteString(h.Header())
+		buf.WriteByte('\n')
+		for _, l := range h.Lines {
+			switch l.Op {
+			case Equal:
+				buf.WriteByte(' ')
+			case Delete:
+				buf.WriteByte('-')
+			case Insert:
+				buf.WriteByte('+')
+			}
+			buf.WriteString(l.Text)
+			buf.WriteByte('\n')
+			if l.NoNewline {
+				buf.WriteString("\\ No newline at end of file\n")
+			}
+		}
+	}
+	return buf.String()
+}
+
+// noNewlineMark is appended to the last line if text doesn't end with newline,
+// lines don't contain newlines, so such line is never equal to line followed by newline
+const noNewlineMark = "\n"
+
+// splitLines returns lines of text, the last line without newline ends with noNewlineMark
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	lines := strings.Split(s, "\n")
+	if lines[len(lines)-1] == "" {
+		return lines[:len(lines)-1]
+	}
+	lines[len(lines)-1] += noNewlineMark
+	return lines
+}
+
+// myers returns shortest edit script transforming a to b
+// using algorithm from "An O(ND) Difference Algorithm and Its Variations" by E. Myers
+func myers(a []string, b []string) []Op {
+	n, m := len(a), len(b)
+	if n == 0 || m == 0 {
+		return replaceAll(n, m)
+	}
+
+	// v[k+offset] is furthest x on diagonal k, trace[d] is copy of v before step d
+	offset := n + m + 1
+	v := make([]int, 2*offset+1)
+	var trace [][]int
+	for d := 0; d <= n+m; d++ {
+		if d > maxEditDistance {
+			return replaceAll(n, m)
+		}
+		trace = append(trace, append([]int(nil), v[offset-d-1:offset+d+2]...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				return backtrack(trace, n, m)
+			}
+		}
+	}
+	return replaceAll(n, m)
+}
+
+func backtrack(trace [][]int, n int, m int) []Op {
+	var ops []Op
+	x, y := n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		v := trace[d]
+		// trace[d] holds diagonals from -d-1 to d+1
+		at := func(k int) int { return v[k+d+1] }
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && at(k-1) < at(k+1)) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := at(prevK)
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			ops = append(ops, Equal)
+			x--
+			y--
+		}
+		if d > 0 {
+			if x == prevX {
+				ops = append(ops, Insert)
+			} else {
+				ops = append(ops, Delete)
+			}
+		}
+		x, y = prevX, prevY
+	}
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+	return ops
+}
+
+func replaceAll(n int, m int) []Op {
+	ops := make([]Op, 0, n+m)
+	for i := 0; i < n; i++ {
+		ops = append(ops, Delete)
+	}
+	for i := 0; i < m; i++ {
+		ops = append(ops, Insert)
+	}
+	return ops
+}
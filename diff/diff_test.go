@@ -0,0 +1,81 @@
+package diff
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func applyLines(lines []Line) (string, string) {
+	var oldText, newText []string
+	for _, l := range lines {
+		if l.Op != Insert {
+			oldText = append(oldText, l.Text)
+		}
+		if l.Op != Delete {
+			newText = append(newText, l.Text)
+		}
+	}
+	return strings.Join(oldText, "\n"), strings.Join(newText, "\n")
+}
+
+func TestLines(t *testing.T) {
+	testCases := []struct {
+		a, b    string
+		changes int
+	}{
+		{"", "", 0},
+		{"a\nb\nc\n", "a\nb\nc\n", 0},
+		{"", "a\nb\n", 2},
+		{"a\nb\n", "", 2},
+		{"a\nb\nc\n", "a\nc\n", 1},
+		{"a\nc\n", "a\nb\nc\n", 1},
+		{"a\nb\nc\nd\n", "a\nx\nc\ny\n", 4},
+		{"a\nb\nc\na\nb\nb\na\n", "c\nb\na\nb\na\nc\n", 5},
+	}
+	for _, tc := range testCases {
+		lines := Lines(tc.a, tc.b)
+		oldText, newText := applyLines(lines)
+		assert.Equal(t, strings.TrimSuffix(tc.a, "\n"), oldText)
+		assert.Equal(t, strings.TrimSuffix(tc.b, "\n"), newText)
+		assert.Equal(t, tc.changes, len(lines)-countOp(lines, Equal), "%q -> %q", tc.a, tc.b)
+	}
+}
+
+func TestUnified(t *testing.T) {
+	a := "one\ntwo\nthree\nfour\nfive\nsix\nseven\neight\nnine\nten\n"
+	b := "one\n2\nthree\nfour\nfive\nsix\nseven\neight\nnine\nten\neleven\n"
+	expected := "--- a\n+++ b\n" +
+		"@@ -1,5 +1,5 @@\n one\n-two\n+2\n three\n four\n five\n" +
+		"@@ -8,3 +8,4 @@\n eight\n nine\n ten\n+eleven\n"
+	assert.Equal(t, expected, Unified("a", "b", a, b, 3))
+	assert.Equal(t, "", Unified("a", "b", a, a, 3))
+
+	assert.Equal(t, "--- a\n+++ b\n@@ -0,0 +1,2 @@\n+x\n+y\n", Unified("a", "b", "", "x\ny\n", 3))
+	assert.Equal(t, "--- a\n+++ b\n@@ -1 +0,0 @@\n-x\n", Unified("a", "b", "x\n", "", 3))
+}
+
+func TestNoNewlineAtEnd(t *testing.T) {
+	assert.Equal(t, "--- a\n+++ b\n@@ -1,2 +1,2 @@\n one\n-two\n\\ No newline at end of file\n+two\n",
+		Unified("a", "b", "one\ntwo", "one\ntwo\n", 3))
+	assert.Equal(t, "--- a\n+++ b\n@@ -1,2 +1,2 @@\n-one\n+1\n two\n\\ No newline at end of file\n",
+		Unified("a", "b", "one\ntwo", "1\ntwo", 3))
+	assert.Equal(t, "", Unified("a", "b", "one\ntwo", "one\ntwo", 3))
+
+	lines := Lines("x", "x\n")
+	assert.Equal(t, []Line{
+		{Op: Delete, Text: "x", OldNum: 1, NoNewline: true},
+		{Op: Insert, Text: "x", NewNum: 1},
+	}, lines)
+}
+
+func countOp(lines []Line, op Op) int {
+	cnt := 0
+	for _, l := range lines {
+		if l.Op == op {
+			cnt++
+		}
+	}
+	return cnt
+}
@@ -5,40 +5,143 @@ import (
 	"io"
 	"mime"
 	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
 )
 
+const maxRedirects = 5
+
 // Fetcher download data from source
 type Fetcher interface {
 	Fetch(url string) (io.ReadCloser, error)
 }
 
+// Config contains restrictions for SimpleFetcher
+type Config struct {
+	Timeout time.Duration // time limit for whole request including reading body
+	MaxSize int64         // maximal size of response body in bytes, 0 means unlimited
+	// AllowedHosts list of hosts to download from.
+	// Host may be specified as "*.example.com" to allow all subdomains or "*" to allow any host
+	AllowedHosts []string
+}
+
 // SimpleFetcher download data from source and checks content type
 type SimpleFetcher struct {
-	contetTypes map[string]struct{}
+	contetTypes  map[string]struct{}
+	client       *http.Client
+	maxSize      int64
+	allowedHosts []string
 }
 
 // NewFetcher create new Fetcher
-func NewFetcher() Fetcher {
+func NewFetcher(cfg Config) Fetcher {
 	contetTypes := map[string]struct{}{
-		"text/plain": struct{}{},
+		"text/plain":      struct{}{},
+		"text/markdown":   struct{}{},
+		"text/x-markdown": struct{}{},
 	}
-	return SimpleFetcher{
-		contetTypes: contetTypes,
+	f := SimpleFetcher{
+		contetTypes:  contetTypes,
+		maxSize:      cfg.MaxSize,
+		allowedHosts: cfg.AllowedHosts,
 	}
+	f.client = &http.Client{
+		Timeout: cfg.Timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxRedirects {
+				return errors.New("too many redirects")
+			}
+			return f.checkURL(req.URL)
+		},
+	}
+	return f
 }
 
 // Fetch retrieve data from url
-func (f SimpleFetcher) Fetch(url string) (io.ReadCloser, error) {
-	resp, err := http.Get(url)
+func (f SimpleFetcher) Fetch(rawURL string) (io.ReadCloser, error) {
+	parsedURL, err := url.Parse(rawURL)
 	if err != nil {
+		return nil, errors.Errorf("malformed url %q", rawURL)
+	}
+	if err = f.checkURL(parsedURL); err != nil {
 		return nil, err
 	}
+
+	resp, err := f.client.Get(parsedURL.String())
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, errors.Errorf("unexpected response status %q", resp.Status)
+	}
 	contentType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
 	if err != nil {
+		resp.Body.Close()
 		return nil, err
 	}
 	if _, ok := f.contetTypes[contentType]; !ok {
+		resp.Body.Close()
 		return nil, fmt.Errorf("unsupported content type %s", contentType)
 	}
-	return resp.Body, err
+	if f.maxSize > 0 {
+		if resp.ContentLength > f.maxSize {
+			resp.Body.Close()
+			return nil, errors.Errorf("document is too large, limit is %d bytes", f.maxSize)
+		}
+		return &limitedReadCloser{ReadCloser: resp.Body, left: f.maxSize}, nil
+	}
+	return resp.Body, nil
+}
+
+func (f SimpleFetcher) checkURL(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return errors.Errorf("unsupported url scheme %q", u.Scheme)
+	}
+	host := strings.ToLower(u.Hostname())
+	if !isHostAllowed(host, f.allowedHosts) {
+		return errors.Errorf("host %q is not allowed", host)
+	}
+	return nil
+}
+
+func isHostAllowed(host string, allowedHosts []string) bool {
+	for _, allowed := range allowedHosts {
+		allowed = strings.ToLower(allowed)
+		switch {
+		case allowed == "*":
+			return true
+		case strings.HasPrefix(allowed, "*."):
+			if strings.HasSuffix(host, allowed[1:]) {
+				return true
+			}
+		case host == allowed:
+			return true
+		}
+	}
+	return false
+}
+
+// limitedReadCloser returns error when more than left bytes read
+type limitedReadCloser struct {
+	io.ReadCloser
+	left int64
+}
+
+func (r *limitedReadCloser) Read(p []byte) (int, error) {
+	if r.left < 0 {
+		return 0, errors.New("document is too large")
+	}
+	if int64(len(p)) > r.left+1 {
+		p = p[:r.left+1]
+	}
+	n, err := r.ReadCloser.Read(p)
+	r.left -= int64(n)
+	if r.left < 0 {
+		return n, errors.New("document is too large")
+	}
+	return n, err
 }
@@ -0,0 +1,82 @@
+package fetch
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSimpleFetcher(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/page.md":
+			w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+			w.Write([]byte("# Header"))
+		case "/large.txt":
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write([]byte(strings.Repeat("a", 200)))
+		case "/image.png":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write([]byte("png"))
+		case "/slow.txt":
+			time.Sleep(time.Millisecond * 200)
+			w.Header().Set("Content-Type", "text/plain")
+		case "/redirect":
+			http.Redirect(w, r, "http://example.com/page.md", http.StatusFound)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	tsURL, err := url.Parse(ts.URL)
+	require.NoError(t, err)
+
+	f := NewFetcher(Config{
+		Timeout:      time.Millisecond * 100,
+		MaxSize:      100,
+		AllowedHosts: []string{tsURL.Hostname()},
+	})
+
+	body, err := f.Fetch(ts.URL + "/page.md")
+	require.NoError(t, err)
+	data, err := ioutil.ReadAll(body)
+	assert.NoError(t, err)
+	assert.Equal(t, "# Header", string(data))
+
+	body, err = f.Fetch(ts.URL + "/large.txt")
+	if err == nil {
+		_, err = ioutil.ReadAll(body)
+	}
+	assert.Error(t, err)
+
+	failingPaths := []string{"/image.png", "/slow.txt", "/redirect", "/not_found"}
+	for _, p := range failingPaths {
+		_, err = f.Fetch(ts.URL + p)
+		assert.Errorf(t, err, "fetch %s expected to fail", p)
+	}
+
+	_, err = f.Fetch("ftp://" + tsURL.Host + "/page.md")
+	assert.Error(t, err)
+
+	_, err = NewFetcher(Config{AllowedHosts: []string{"example.com"}}).Fetch(ts.URL + "/page.md")
+	assert.Error(t, err)
+}
+
+func TestIsHostAllowed(t *testing.T) {
+	allowed := []string{"example.com", "*.git.local"}
+	assert.True(t, isHostAllowed("example.com", allowed))
+	assert.True(t, isHostAllowed("raw.git.local", allowed))
+	assert.False(t, isHostAllowed("git.local", allowed))
+	assert.False(t, isHostAllowed("www.example.com", allowed))
+	assert.False(t, isHostAllowed("evilgit.local", allowed))
+	assert.False(t, isHostAllowed("example.com", nil))
+	assert.True(t, isHostAllowed("example.com", []string{"*"}))
+}
@@ -7,12 +7,15 @@ require (
 	github.com/go-chi/chi v4.0.3+incompatible
 	github.com/go-chi/render v1.0.1
 	github.com/jessevdk/go-flags v1.5.0
-	github.com/minio/minio-go/v7 v7.0.10
+	github.com/klauspost/compress v1.13.6
+	github.com/minio/minio-go/v7 v7.0.18
 	github.com/pkg/errors v0.9.1
 	github.com/rs/xid v1.2.1
 	github.com/stretchr/testify v1.4.0
-	github.com/yuin/goldmark v1.1.22
+	github.com/yuin/goldmark v1.2.1
 	github.com/yuin/goldmark-highlighting v0.0.0-20200307114337-60d527fdb691
 	go.etcd.io/bbolt v1.3.3
-	golang.org/x/net v0.0.0-20200707034311-ab3426394381
+	golang.org/x/crypto v0.0.0-20201216223049-8b5274cf687f
+	golang.org/x/net v0.0.0-20201021035429-f5854403a974
+	modernc.org/sqlite v1.17.3
 )
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"time"
 
 	"github.com/jessevdk/go-flags"
 	"github.com/vdimir/markify/app"
@@ -13,23 +14,67 @@ var revision = "local"
 
 // Opts contains command line options (see go-flags for details)
 type Opts struct {
-	Hostname      string `short:"h" long:"host" required:"false" description:"server host name" env:"MARKIFY_SERVER_HOSTNAME"`
-	Port          uint16 `short:"p" long:"port" required:"false" description:"server port" env:"MARKIFY_SERVER_PORT" default:"8080"`
-	Storage       string `short:"s" long:"storage" required:"false" description:"storage specification '<type_of_storage>:<config>', see storage code for details" env:"MARKIFY_STORAGE" default:"local:./"`
-	AdminPassword string `long:"admin_secret" required:"false" description:"Admin credential to access /_admin endpoint" env:"MARKIFY_ADMIN_PWD"`
-	SecretSeed    string `long:"seed_secret" required:"false" description:"Secret seed to generate tokens" env:"MARKIFY_SEED"`
-	Debug         bool   `long:"debug" description:"debug mode"`
+	Hostname       string        `short:"h" long:"host" required:"false" description:"server host name" env:"MARKIFY_SERVER_HOSTNAME"`
+	Port           uint16        `short:"p" long:"port" required:"false" description:"server port" env:"MARKIFY_SERVER_PORT" default:"8080"`
+	Storage        string        `short:"s" long:"storage" required:"false" description:"storage specification '<type_of_storage>:<config>' with optional prefixes 'dedup+', 'gzip+', 'zstd+', 'encrypted+', see README" env:"MARKIFY_STORAGE" default:"local:./"`
+	AdminPassword  string        `long:"admin_secret" required:"false" description:"Admin credential to access /_admin endpoint" env:"MARKIFY_ADMIN_PWD"`
+	SecretSeed     string        `long:"seed_secret" required:"false" description:"Secret seed to generate tokens" env:"MARKIFY_SEED"`
+	SweepInterval  time.Duration `long:"sweep_interval" required:"false" description:"interval to remove expired pastes from storage" env:"MARKIFY_SWEEP_INTERVAL" default:"1m"`
+	LinkHosts      []string      `long:"link_host" required:"false" description:"host allowed to import documents by link from, '*.example.com' matches subdomains, '*' matches any host" env:"MARKIFY_LINK_HOSTS" env-delim:","`
+	LinkTimeout    time.Duration `long:"link_timeout" required:"false" description:"time limit to download document by link" env:"MARKIFY_LINK_TIMEOUT" default:"10s"`
+	LinkMaxSize    int64         `long:"link_max_size" required:"false" description:"maximal size in bytes of document downloaded by link" env:"MARKIFY_LINK_MAX_SIZE" default:"1048576"`
+	StorageTimeout time.Duration `long:"storage_timeout" required:"false" description:"time limit of storage operations made to handle request, negative to disable" env:"MARKIFY_STORAGE_TIMEOUT" default:"30s"`
+	CacheSize      int64         `long:"render_cache_size" required:"false" description:"maximal size in bytes of rendered documents cached in memory, negative to disable" env:"MARKIFY_RENDER_CACHE_SIZE" default:"33554432"`
+	Debug          bool          `long:"debug" description:"debug mode"`
+
+	Migrate MigrateCommand `command:"migrate" description:"copy all pastes from one storage to another"`
+}
+
+// MigrateCommand copies blobs between storages, storage specifications are the same as for --storage
+type MigrateCommand struct {
+	From   string `long:"from" required:"true" description:"source storage specification, it's not modified"`
+	To     string `long:"to" required:"true" description:"target storage specification, 'encrypted+' storage can be the same as source to encrypt blobs stored without encryption"`
+	DryRun bool   `long:"dry_run" description:"only read source and report what would be copied"`
+	Resume bool   `long:"resume" description:"skip blobs that target already has with the same content"`
+	After  string `long:"after" description:"continue after key reported by interrupted migration"`
+}
+
+// Execute runs migration when migrate command is given
+func (cmd *MigrateCommand) Execute(args []string) error {
+	startTime := time.Now()
+	stats, err := app.Migrate(app.MigrateConfig{
+		From:   cmd.From,
+		To:     cmd.To,
+		DryRun: cmd.DryRun,
+		Resume: cmd.Resume,
+		After:  cmd.After,
+	})
+	if err != nil {
+		if stats != nil && stats.LastKey != "" {
+			log.Printf("[ERROR] migration stopped, run again with --after %q to continue", stats.LastKey)
+		}
+		return err
+	}
+	log.Printf("[INFO] migration finished in %s: copied %d blobs (%d bytes), skipped %d, expired %d, dry run: %v",
+		time.Since(startTime).Round(time.Millisecond), stats.Copied, stats.Bytes, stats.Skipped, stats.Expired, cmd.DryRun)
+	return nil
 }
 
 func main() {
 	log.Printf("[DEBUG] Starting app version %s\n", revision)
 	var opts Opts
 
-	_, err := flags.Parse(&opts)
+	parser := flags.NewParser(&opts, flags.Default)
+	parser.SubcommandsOptional = true
+	_, err := parser.Parse()
 
 	if err != nil {
 		os.Exit(1)
 	}
+	if parser.Active != nil {
+		// subcommand is executed by parser
+		return
+	}
 
 	appServer, err := app.NewApp(&app.Config{
 		Debug:         opts.Debug,
@@ -38,6 +83,14 @@ func main() {
 		StatusText:    fmt.Sprintf(`{"revision":"%s"}`, revision),
 		AdminPassword: opts.AdminPassword,
 		UIDSecret:     opts.SecretSeed,
+		SweepInterval: opts.SweepInterval,
+
+		LinkAllowedHosts: opts.LinkHosts,
+		LinkTimeout:      opts.LinkTimeout,
+		LinkMaxSize:      opts.LinkMaxSize,
+
+		StorageTimeout:  opts.StorageTimeout,
+		RenderCacheSize: opts.CacheSize,
 	})
 
 	if err != nil {
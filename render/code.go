@@ -1,11 +1,25 @@
 package render
 
 import (
+	"bytes"
 	"html"
 	"io"
 	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/alecthomas/chroma"
+	chromahtml "github.com/alecthomas/chroma/formatters/html"
+	"github.com/alecthomas/chroma/lexers"
+	"github.com/alecthomas/chroma/styles"
 )
 
+// codeStyle is the same style as used for fenced code blocks in markdown
+const codeStyle = "monokai"
+
+// lineStyle is style for separate lines shown on light background, e.g. in diff
+const lineStyle = "github"
+
 type plainText struct {
 }
 
@@ -19,3 +33,94 @@ func (r *plainText) Convert(reader io.Reader) (*Document, error) {
 		Body: "<pre><code>" + html.EscapeString(string(data)) + "</code></pre>",
 	}, nil
 }
+
+// codeHighlighter renders source code with syntax highlighting
+type codeHighlighter struct {
+	formatter *chromahtml.Formatter
+	style     *chroma.Style
+}
+
+func newCodeHighlighter() *codeHighlighter {
+	return &codeHighlighter{
+		formatter: chromahtml.New(chromahtml.WithLineNumbers(true)),
+		style:     styles.Get(codeStyle),
+	}
+}
+
+func (r *codeHighlighter) Convert(reader io.Reader, lexer chroma.Lexer) (*Document, error) {
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	iterator, err := chroma.Coalesce(lexer).Tokenise(nil, string(data))
+	if err != nil {
+		return nil, err
+	}
+	htmlBuf := &bytes.Buffer{}
+	if err = r.formatter.Format(htmlBuf, r.style, iterator); err != nil {
+		return nil, err
+	}
+	return &Document{
+		Body: htmlBuf.String(),
+	}, nil
+}
+
+// lineHighlighter renders each line of source code separately
+type lineHighlighter struct {
+	formatter *chromahtml.Formatter
+	style     *chroma.Style
+}
+
+func newLineHighlighter() *lineHighlighter {
+	return &lineHighlighter{
+		formatter: chromahtml.New(chromahtml.PreventSurroundingPre(true)),
+		style:     styles.Get(lineStyle),
+	}
+}
+
+func (r *lineHighlighter) HighlightLines(text string, lexer chroma.Lexer) ([]string, error) {
+	iterator, err := chroma.Coalesce(lexer).Tokenise(nil, text)
+	if err != nil {
+		return nil, err
+	}
+	var res []string
+	for _, tokens := range chroma.SplitTokensIntoLines(iterator.Tokens()) {
+		htmlBuf := &bytes.Buffer{}
+		if err = r.formatter.Format(htmlBuf, r.style, chroma.Literator(tokens...)); err != nil {
+			return nil, err
+		}
+		res = append(res, strings.TrimSuffix(htmlBuf.String(), "\n"))
+	}
+	return res, nil
+}
+
+// Syntax describes language supported for highlighting
+type Syntax struct {
+	Name  string // identifier to pass to Convert
+	Label string // human readable name
+}
+
+// CodeSyntaxes returns languages supported for source code highlighting sorted by label
+func CodeSyntaxes() []Syntax {
+	var res []Syntax
+	for _, lexer := range lexers.Registry.Lexers {
+		cfg := lexer.Config()
+		if cfg.Name == "markdown" || cfg.Name == "plaintext" {
+			// rendered as page and as plain text without highlighting
+			continue
+		}
+		res = append(res, Syntax{Name: lexerSyntaxName(cfg.Name, cfg.Aliases), Label: cfg.Name})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return strings.ToLower(res[i].Label) < strings.ToLower(res[j].Label)
+	})
+	return res
+}
+
+// lexerSyntaxName returns short name of lexer
+func lexerSyntaxName(name string, aliases []string) string {
+	if len(aliases) > 0 {
+		return aliases[0]
+	}
+	return strings.ToLower(name)
+}
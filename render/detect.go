@@ -0,0 +1,187 @@
+package render
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+
+	"github.com/alecthomas/chroma/lexers"
+)
+
+// minimal share of lines that should look like syntax to detect it
+const detectMinLinesRatio = 0.2
+
+// minimal number of lines nested into yaml mapping with the same indentation
+const yamlMinNestedLines = 2
+
+// syntaxRule matches lines typical for syntax
+type syntaxRule struct {
+	syntax   string
+	patterns []*regexp.Regexp
+}
+
+var syntaxRules = []syntaxRule{
+	{"markdown", []*regexp.Regexp{
+		regexp.MustCompile(`^#{1,6} \S`),
+		regexp.MustCompile(`^\s*[-*+] \S`),
+		regexp.MustCompile(`^\s*\d+\. \S`),
+		regexp.MustCompile("^```"),
+		regexp.MustCompile(`^> `),
+		regexp.MustCompile(`^\|.*\|\s*$`),
+		regexp.MustCompile(`\[[^\]]+\]\([^)]+\)`),
+		regexp.MustCompile(`(\*\*|__)\S.*\S(\*\*|__)`),
+		regexp.MustCompile(`^(=+|-+)\s*$`),
+	}},
+	{"go", []*regexp.Regexp{
+		regexp.MustCompile(`^package \w+$`),
+		regexp.MustCompile(`^import [("]`),
+		regexp.MustCompile(`^func (\(\w+ \*?\w+\) )?\w+\(`),
+		regexp.MustCompile(`\w+ := `),
+		regexp.MustCompile(`if err != nil \{`),
+	}},
+	{"python", []*regexp.Regexp{
+		regexp.MustCompile(`^\s*def \w+\(.*\):`),
+		regexp.MustCompile(`^\s*class \w+(\(.*\))?:`),
+		regexp.MustCompile(`^(from \w[\w.]* )?import \w`),
+		regexp.MustCompile(`^\s*(if|elif|for|while|with|try|except).*:\s*$`),
+		regexp.MustCompile(`^\s*self\.\w+`),
+	}},
+	{"sql", []*regexp.Regexp{
+		regexp.MustCompile(`(?i)^\s*(select|insert into|update|delete from|create (table|index|view)|alter table|drop table)\b`),
+		regexp.MustCompile(`(?i)^\s*(from|where|group by|order by|left join|inner join|join|values)\b`),
+	}},
+	{"diff", []*regexp.Regexp{
+		regexp.MustCompile(`^(---|\+\+\+) \S`),
+		regexp.MustCompile(`^@@ -\d+(,\d+)? \+\d+(,\d+)? @@`),
+		regexp.MustCompile(`^diff --git `),
+	}},
+	{"yaml", []*regexp.Regexp{
+		regexp.MustCompile(`^---\s*$`),
+		regexp.MustCompile(`^\s*[\w.-]+:(\s+\S.*)?$`),
+		regexp.MustCompile(`^\s*- [\w.-]+:`),
+	}},
+	{"xml", []*regexp.Regexp{
+		regexp.MustCompile(`^\s*<\?xml `),
+		regexp.MustCompile(`^\s*</?[\w:-]+(\s[^>]*)?/?>`),
+	}},
+}
+
+// syntaxChecks are applied to lines of text matching rule of syntax, matching lines are not enough for such syntaxes
+var syntaxChecks = map[string]func(lines []string) bool{
+	"yaml": looksLikeYAML,
+}
+
+var (
+	yamlDocumentStart = regexp.MustCompile(`^---\s*$`)
+	yamlBlockKey      = regexp.MustCompile(`^(\s*)(- )?[\w.-]+:\s*$`)
+	yamlBlockEntry    = regexp.MustCompile(`^((- )?[\w.-]+:(\s|$)|- \S)`)
+)
+
+var shebangSyntaxes = map[string]string{
+	"sh":      "bash",
+	"bash":    "bash",
+	"zsh":     "bash",
+	"python":  "python",
+	"python3": "python",
+	"node":    "javascript",
+	"ruby":    "ruby",
+	"perl":    "perl",
+}
+
+// DetectSyntax guesses syntax of text. Returns empty string if text looks like plain text
+func DetectSyntax(text string) string {
+	trimmed := strings.TrimSpace(text)
+	if (strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[")) && json.Valid([]byte(trimmed)) {
+		return "json"
+	}
+	if syntax := detectShebang(trimmed); syntax != "" {
+		return syntax
+	}
+
+	lines := strings.Split(trimmed, "\n")
+	nonEmpty := 0
+	for _, line := range lines {
+		if strings.TrimSpace(line) != "" {
+			nonEmpty++
+		}
+	}
+	bestSyntax, bestScore := "", 0
+	for _, rule := range syntaxRules {
+		score := 0
+		for _, line := range lines {
+			for _, pattern := range rule.patterns {
+				if pattern.MatchString(line) {
+					score++
+					break
+				}
+			}
+		}
+		if check := syntaxChecks[rule.syntax]; score > bestScore && (check == nil || check(lines)) {
+			bestSyntax, bestScore = rule.syntax, score
+		}
+	}
+	if bestScore > 0 && float64(bestScore) >= detectMinLinesRatio*float64(nonEmpty) {
+		return bestSyntax
+	}
+
+	if lexer := lexers.Analyse(text); lexer != nil {
+		return lexerSyntaxName(lexer.Config().Name, lexer.Config().Aliases)
+	}
+	return ""
+}
+
+// looksLikeYAML requires document start marker or mapping with several nested entries indented the same way,
+// so lines of logs like "level: info" are not taken as yaml
+func looksLikeYAML(lines []string) bool {
+	if len(lines) > 0 && yamlDocumentStart.MatchString(lines[0]) {
+		return true
+	}
+	for i, line := range lines {
+		key := yamlBlockKey.FindStringSubmatch(line)
+		if key == nil {
+			continue
+		}
+		indent, nested := "", 0
+		for _, next := range lines[i+1:] {
+			entry := strings.TrimLeft(next, " \t")
+			if entry == "" {
+				continue
+			}
+			nextIndent := next[:len(next)-len(entry)]
+			if len(nextIndent) <= len(key[1]) {
+				break
+			}
+			if indent == "" {
+				indent = nextIndent
+			}
+			if len(nextIndent) > len(indent) {
+				// entry of deeper mapping
+				continue
+			}
+			if nextIndent != indent || !yamlBlockEntry.MatchString(entry) {
+				break
+			}
+			nested++
+		}
+		if nested >= yamlMinNestedLines {
+			return true
+		}
+	}
+	return false
+}
+
+func detectShebang(text string) string {
+	if !strings.HasPrefix(text, "#!") {
+		return ""
+	}
+	firstLine := strings.SplitN(text, "\n", 2)[0]
+	fields := strings.Fields(strings.TrimPrefix(firstLine, "#!"))
+	if len(fields) == 0 {
+		return ""
+	}
+	interpreter := fields[0][strings.LastIndex(fields[0], "/")+1:]
+	if interpreter == "env" && len(fields) > 1 {
+		interpreter = fields[1]
+	}
+	return shebangSyntaxes[interpreter]
+}
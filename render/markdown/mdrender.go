@@ -5,6 +5,7 @@ import (
 	"github.com/yuin/goldmark"
 	"github.com/yuin/goldmark/extension"
 	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
 	"github.com/yuin/goldmark/util"
 
 	highlighting "github.com/yuin/goldmark-highlighting"
@@ -69,3 +70,13 @@ func (r *Converter) Convert(data []byte) (*Document, error) {
 	}
 	return doc, nil
 }
+
+// Title returns title extracted from first header without rendering document
+func (r *Converter) Title(data []byte) string {
+	var ctx = parser.NewContext()
+	r.markdown.Parser().Parse(text.NewReader(data), parser.WithContext(ctx))
+	if previewText, ok := ctx.Get(titleParserCtxKey).(*PagePreviewText); ok && previewText != nil {
+		return previewText.Title
+	}
+	return ""
+}
@@ -56,6 +56,7 @@ func TestTitleExtractor(t *testing.T) {
 		doc, err := rndr.Convert(mdData)
 		require.NoError(t, err)
 		require.Equal(t, "header 11", doc.Title)
+		require.Equal(t, doc.Title, rndr.Title(mdData), "title is extracted without rendering")
 		require.Equal(t, "", doc.Preview)
 	}
 	{
@@ -71,6 +72,7 @@ func TestTitleExtractor(t *testing.T) {
 		doc, err := rndr.Convert(mdData)
 		require.NoError(t, err)
 		require.Equal(t, "header 11", doc.Title)
+		require.Equal(t, doc.Title, rndr.Title(mdData), "title is extracted without rendering")
 		require.Equal(t, "text 123 456", doc.Preview)
 	}
 	{
@@ -85,6 +87,7 @@ func TestTitleExtractor(t *testing.T) {
 		doc, err := rndr.Convert(mdData)
 		require.NoError(t, err)
 		require.Equal(t, "", doc.Title)
+		require.Equal(t, doc.Title, rndr.Title(mdData), "title is extracted without rendering")
 		require.Equal(t, "text 11", doc.Preview)
 	}
 	{
@@ -99,6 +102,7 @@ func TestTitleExtractor(t *testing.T) {
 		doc, err := rndr.Convert(mdData)
 		require.NoError(t, err)
 		require.Equal(t, "text 11", doc.Title)
+		require.Equal(t, doc.Title, rndr.Title(mdData), "title is extracted without rendering")
 		require.Equal(t, "", doc.Preview)
 	}
 }
@@ -1,12 +1,22 @@
 package render
 
 import (
-	"github.com/pkg/errors"
-	"github.com/vdimir/markify/render/markdown"
+	"html"
 	"io"
 	"io/ioutil"
+	"strings"
+
+	"github.com/alecthomas/chroma/lexers"
+	"github.com/pkg/errors"
+	"github.com/vdimir/markify/render/markdown"
 )
 
+// PlainTextSyntax syntax of text rendered as is without highlighting
+const PlainTextSyntax = "text"
+
+// Version of rendering pipeline, it should be changed when output of converter changes
+const Version = "1"
+
 type Document struct {
 	Title   string
 	Preview string
@@ -14,21 +24,29 @@ type Document struct {
 }
 
 type DocConverter struct {
-	md   *markdown.Converter
-	code *plainText
+	md        *markdown.Converter
+	code      *plainText
+	highlight *codeHighlighter
+	lines     *lineHighlighter
 }
 
 func NewConverter() *DocConverter {
 	return &DocConverter{
-		md:   markdown.NewConverter(),
-		code: &plainText{},
+		md:        markdown.NewConverter(),
+		code:      &plainText{},
+		highlight: newCodeHighlighter(),
+		lines:     newLineHighlighter(),
 	}
 }
 
+// SupportSyntax checks that syntax is empty for plain text, "markdown" or name of programming language
 func (r *DocConverter) SupportSyntax(syntax string) error {
 	if syntax == "markdown" || syntax == "" {
 		return nil
 	}
+	if lexers.Get(syntax) != nil {
+		return nil
+	}
 	return errors.Errorf("syntax %q is not supported", syntax)
 }
 
@@ -48,5 +66,37 @@ func (r *DocConverter) Convert(reader io.Reader, syntax string) (*Document, erro
 			Body:    mdDoc.Body,
 		}, nil
 	}
+	if syntax != "" && syntax != PlainTextSyntax {
+		if lexer := lexers.Get(syntax); lexer != nil {
+			return r.highlight.Convert(reader, lexer)
+		}
+	}
 	return r.code.Convert(reader)
 }
+
+// Title returns title of document with syntax without rendering it, only markdown documents have title
+func (r *DocConverter) Title(text []byte, syntax string) string {
+	if syntax != "markdown" {
+		return ""
+	}
+	return r.md.Title(text)
+}
+
+// HighlightLines returns html of each line of text highlighted according to syntax.
+// Lines are split by "\n", so there is no item for text after trailing newline
+func (r *DocConverter) HighlightLines(text string, syntax string) ([]string, error) {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	if text == "" {
+		return nil, nil
+	}
+	if syntax != "" && syntax != PlainTextSyntax {
+		if lexer := lexers.Get(syntax); lexer != nil {
+			return r.lines.HighlightLines(text, lexer)
+		}
+	}
+	lines := strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+	for i := range lines {
+		lines[i] = html.EscapeString(lines[i])
+	}
+	return lines, nil
+}
@@ -0,0 +1,95 @@
+package render
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSupportSyntax(t *testing.T) {
+	conv := NewConverter()
+	for _, syntax := range []string{"", "markdown", "go", "python", "sql", "Go", "json"} {
+		assert.NoErrorf(t, conv.SupportSyntax(syntax), "syntax %q", syntax)
+	}
+	assert.Error(t, conv.SupportSyntax("unknown_syntax"))
+}
+
+func TestConvertCode(t *testing.T) {
+	conv := NewConverter()
+
+	doc, err := conv.Convert(strings.NewReader("package main\n\nfunc main() {}\n"), "go")
+	require.NoError(t, err)
+	assert.Contains(t, doc.Body, "<pre")
+	assert.Contains(t, doc.Body, "<span")
+	assert.Contains(t, doc.Body, "package")
+	assert.NotContains(t, doc.Body, "<code>package main")
+
+	doc, err = conv.Convert(strings.NewReader("<b>text</b>"), "")
+	require.NoError(t, err)
+	assert.Equal(t, "<pre><code>&lt;b&gt;text&lt;/b&gt;</code></pre>", doc.Body)
+
+	doc, err = conv.Convert(strings.NewReader("<script>alert(1)</script>"), "python")
+	require.NoError(t, err)
+	assert.NotContains(t, doc.Body, "<script>")
+}
+
+func TestHighlightLines(t *testing.T) {
+	conv := NewConverter()
+
+	lines, err := conv.HighlightLines("package main\r\n\nfunc main() {}\n", "go")
+	require.NoError(t, err)
+	require.Len(t, lines, 3)
+	assert.Contains(t, lines[0], "<span")
+	assert.Contains(t, lines[0], "package")
+	assert.Equal(t, "", lines[1])
+	assert.NotContains(t, lines[2], "\n")
+
+	lines, err = conv.HighlightLines("<b>text</b>\nline", "text")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"&lt;b&gt;text&lt;/b&gt;", "line"}, lines)
+
+	lines, err = conv.HighlightLines("", "go")
+	require.NoError(t, err)
+	assert.Empty(t, lines)
+}
+
+func TestCodeSyntaxes(t *testing.T) {
+	syntaxes := CodeSyntaxes()
+	names := map[string]bool{}
+	for _, syntax := range syntaxes {
+		names[syntax.Name] = true
+		assert.NotEmpty(t, syntax.Label)
+	}
+	assert.True(t, names["go"])
+	assert.True(t, names["python"])
+	assert.True(t, names["sql"])
+	assert.False(t, names["md"])
+}
+
+func TestDetectSyntax(t *testing.T) {
+	testCases := map[string]string{
+		"just some words\nin two lines":        "",
+		"":                                     "",
+		`{"status": "ok", "items": [1, 2, 3]}`: "json",
+		"# Title\n\nSome text with [link](http://example.com)\n\n* item 1\n* item 2\n": "markdown",
+		"package main\n\nimport \"fmt\"\n\nfunc main() {\n\tfmt.Println(\"hi\")\n}\n":  "go",
+		"#!/usr/bin/env python3\nprint('hello')\n":                                     "python",
+		"#!/bin/sh\necho hello\n":                                                      "bash",
+		"import os\n\ndef main():\n    if os.environ:\n        print('ok')\n":          "python",
+		"SELECT id, title\nFROM pastes\nWHERE ttl > 0\nORDER BY id;\n":                 "sql",
+		"--- a/file.txt\n+++ b/file.txt\n@@ -1,2 +1,2 @@\n-foo\n+bar\n baz\n":          "diff",
+		"---\nname: markify\nversion: 1\n":                                             "yaml",
+		"server:\n  listen: 80\n  tls:\n    cert: a.pem\n  workers: 4\nlog: info\n":    "yaml",
+		"INFO: server started\nWARN: disk is almost full\nERROR: connection refused\n": "",
+		"level: info\nmsg: started\nlevel: error\nmsg: connection refused\n":           "",
+		"server:\n  listen: 80\nINFO: started\n":                                       "",
+	}
+	conv := NewConverter()
+	for text, expected := range testCases {
+		detected := DetectSyntax(text)
+		assert.Equalf(t, expected, detected, "wrong syntax detected for %q", text)
+		assert.NoError(t, conv.SupportSyntax(detected))
+	}
+}
@@ -2,6 +2,7 @@ package store
 
 import (
 	"bytes"
+	"encoding/binary"
 	"encoding/json"
 	"io"
 	"io/ioutil"
@@ -17,6 +18,13 @@ import (
 const dataBktName = "__data__"
 const metaBktName = "__metadata__"
 
+// expiryBktName bucket maps key to expiration time of blobs stored with ttl
+const expiryBktName = "__expiry__"
+
+// expiryQueueBktName bucket has empty values by big-endian expiration time followed by key,
+// so blobs expired by some moment are at the beginning of the bucket
+const expiryQueueBktName = "__expiry_queue__"
+
 // Bolt store data in BoldDB
 type Bolt struct {
 	fileName string
@@ -29,16 +37,34 @@ func NewBoltStorage(fileName string) (*Bolt, error) {
 		return nil, err
 	}
 
-	bkts := [][]byte{[]byte(dataBktName), []byte(metaBktName)}
+	bkts := [][]byte{[]byte(dataBktName), []byte(metaBktName), []byte(expiryBktName), []byte(expiryQueueBktName)}
 	db, err := newBoltWithBuckets(fileName, bkts, bbolt.Options{})
+	if err != nil {
+		return nil, err
+	}
+	if err = db.Update(fillExpiryQueue); err != nil {
+		db.Close()
+		return nil, errors.Wrapf(err, "failed to build expiry queue of boltdb %q", fileName)
+	}
 	return &Bolt{
 		db:       db,
 		fileName: fileName,
-	}, err
+	}, nil
 }
 
-// Save data in storage
-func (b *Bolt) SetBlob(key string, reader io.Reader, meta map[string]string, _ time.Duration) error {
+// fillExpiryQueue adds blobs with ttl to expiry queue if it's empty, database could be created before queue was added
+func fillExpiryQueue(tx *bolt.Tx) error {
+	queueBkt := tx.Bucket([]byte(expiryQueueBktName))
+	if k, _ := queueBkt.Cursor().First(); k != nil {
+		return nil
+	}
+	return tx.Bucket([]byte(expiryBktName)).ForEach(func(k, v []byte) error {
+		return queueBkt.Put(expiryQueueKey(v, k), []byte{})
+	})
+}
+
+// SetBlob save data in storage. Blob with positive ttl expires after ttl elapsed
+func (b *Bolt) SetBlob(key string, reader io.Reader, meta map[string]string, ttl time.Duration) error {
 	data, err := ioutil.ReadAll(reader)
 	if err != nil {
 		return errors.Wrap(err, "can't read data from reader")
@@ -51,50 +77,219 @@ func (b *Bolt) SetBlob(key string, reader io.Reader, meta map[string]string, _ t
 		if err := tx.Bucket([]byte(metaBktName)).Put([]byte(key), metadata); err != nil {
 			return err
 		}
+		if err := deleteExpiry(tx, []byte(key)); err != nil {
+			return err
+		}
+		if ttl > 0 {
+			expTime := encodeTime(time.Now().Add(ttl))
+			if err := tx.Bucket([]byte(expiryBktName)).Put([]byte(key), expTime); err != nil {
+				return err
+			}
+			if err := tx.Bucket([]byte(expiryQueueBktName)).Put(expiryQueueKey(expTime, []byte(key)), []byte{}); err != nil {
+				return err
+			}
+		}
 		return tx.Bucket([]byte(dataBktName)).Put([]byte(key), data)
 	})
 }
 
+// GetBlob returns data and metadata stored by key. Returns nil reader if key not found or expired
 func (b *Bolt) GetBlob(key string) (io.Reader, map[string]string, error) {
 	var data []byte
+	var meta map[string]string
 	err := b.db.View(func(tx *bolt.Tx) error {
-		data = tx.Bucket([]byte(dataBktName)).Get([]byte(key))
-		return nil
+		if isExpired(tx, []byte(key), time.Now()) {
+			return nil
+		}
+		value := tx.Bucket([]byte(dataBktName)).Get([]byte(key))
+		if value == nil {
+			return nil
+		}
+		// value is valid only during transaction
+		data = append([]byte{}, value...)
+		return json.Unmarshal(tx.Bucket([]byte(metaBktName)).Get([]byte(key)), &meta)
 	})
-	if data == nil {
-		return nil, nil, nil
-	}
 	if err != nil {
 		return nil, nil, err
 	}
-	meta, err := b.getMeta(key)
-	if err != nil {
-		return nil, nil, err
+	if data == nil {
+		return nil, nil, nil
 	}
-	return bytes.NewReader(data), meta, err
+	return bytes.NewReader(data), meta, nil
 }
 
-func (b *Bolt) getMeta(key string) (map[string]string, error) {
-	var data []byte
+// GetMeta returns metadata stored by key without data. Returns nil if key not found or expired
+func (b *Bolt) GetMeta(key string) (map[string]string, error) {
+	var meta map[string]string
 	err := b.db.View(func(tx *bolt.Tx) error {
-		data = tx.Bucket([]byte(metaBktName)).Get([]byte(key))
+		if isExpired(tx, []byte(key), time.Now()) {
+			return nil
+		}
+		value := tx.Bucket([]byte(metaBktName)).Get([]byte(key))
+		if value == nil {
+			return nil
+		}
+		if err := json.Unmarshal(value, &meta); err != nil {
+			return err
+		}
+		if meta == nil {
+			// blob saved without metadata
+			meta = map[string]string{}
+		}
 		return nil
 	})
 	if err != nil {
 		return nil, err
 	}
+	return meta, nil
+}
+
+// TakeBlob returns data and metadata and removes them in single transaction.
+// Returns nil reader if key not found or expired
+func (b *Bolt) TakeBlob(key string) (io.Reader, map[string]string, error) {
+	var data []byte
 	var meta map[string]string
-	err = json.Unmarshal(data, &meta)
-	return meta, err
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		if isExpired(tx, []byte(key), time.Now()) {
+			return nil
+		}
+		value := tx.Bucket([]byte(dataBktName)).Get([]byte(key))
+		if value == nil {
+			return nil
+		}
+		data = append([]byte{}, value...)
+		if err := json.Unmarshal(tx.Bucket([]byte(metaBktName)).Get([]byte(key)), &meta); err != nil {
+			return err
+		}
+		return deleteKey(tx, []byte(key))
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if data == nil {
+		return nil, nil, nil
+	}
+	return bytes.NewReader(data), meta, nil
 }
 
+// DeleteBlob removes data and metadata
 func (b *Bolt) DeleteBlob(key string) error {
 	return b.db.Update(func(tx *bolt.Tx) error {
-		if err := tx.Bucket([]byte(metaBktName)).Delete([]byte(key)); err != nil {
+		return deleteKey(tx, []byte(key))
+	})
+}
+
+// DeleteExpired removes up to limit blobs expired at the moment now. Returns number of removed blobs.
+// Expiry queue is ordered by time, so only expired blobs are read
+func (b *Bolt) DeleteExpired(now time.Time, limit int) (int, error) {
+	deleted := 0
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		var expiredKeys [][]byte
+		c := tx.Bucket([]byte(expiryQueueBktName)).Cursor()
+		for k, _ := c.First(); k != nil && len(expiredKeys) < limit; k, _ = c.Next() {
+			if len(k) < 8 {
+				return errors.Errorf("malformed expiry queue key %q", k)
+			}
+			if decodeTime(k[:8]).After(now) {
+				break
+			}
+			expiredKeys = append(expiredKeys, append([]byte{}, k[8:]...))
+		}
+		for _, k := range expiredKeys {
+			if err := deleteKey(tx, k); err != nil {
+				return err
+			}
+		}
+		deleted = len(expiredKeys)
+		return nil
+	})
+	return deleted, err
+}
+
+// ListKeys returns up to limit keys of not expired blobs with prefix that follow key after in lexicographic order
+func (b *Bolt) ListKeys(prefix string, after string, limit int) ([]string, error) {
+	var keys []string
+	err := b.db.View(func(tx *bolt.Tx) error {
+		now := time.Now()
+		start := prefix
+		if after > start {
+			start = after
+		}
+		c := tx.Bucket([]byte(metaBktName)).Cursor()
+		for k, _ := c.Seek([]byte(start)); k != nil && len(keys) < limit; k, _ = c.Next() {
+			if !bytes.HasPrefix(k, []byte(prefix)) {
+				break
+			}
+			if string(k) == after || isExpired(tx, k, now) {
+				continue
+			}
+			keys = append(keys, string(k))
+		}
+		return nil
+	})
+	return keys, err
+}
+
+func deleteKey(tx *bolt.Tx, key []byte) error {
+	if err := deleteExpiry(tx, key); err != nil {
+		return err
+	}
+	for _, bkt := range []string{metaBktName, dataBktName} {
+		if err := tx.Bucket([]byte(bkt)).Delete(key); err != nil {
 			return err
 		}
-		return tx.Bucket([]byte(dataBktName)).Delete([]byte(key))
+	}
+	return nil
+}
+
+// deleteExpiry removes expiration time of key and its entry in expiry queue
+func deleteExpiry(tx *bolt.Tx, key []byte) error {
+	expBkt := tx.Bucket([]byte(expiryBktName))
+	expTime := expBkt.Get(key)
+	if expTime == nil {
+		return nil
+	}
+	if err := tx.Bucket([]byte(expiryQueueBktName)).Delete(expiryQueueKey(expTime, key)); err != nil {
+		return err
+	}
+	return expBkt.Delete(key)
+}
+
+func expiryQueueKey(expTime []byte, key []byte) []byte {
+	return append(append(make([]byte, 0, len(expTime)+len(key)), expTime...), key...)
+}
+
+// GetExpireTime returns expiration time of blob, zero time if blob doesn't expire, not found or expired
+func (b *Bolt) GetExpireTime(key string) (time.Time, error) {
+	var expireTime time.Time
+	err := b.db.View(func(tx *bolt.Tx) error {
+		if value := tx.Bucket([]byte(expiryBktName)).Get([]byte(key)); value != nil {
+			expireTime = decodeTime(value)
+		}
+		return nil
 	})
+	if err != nil || !expireTime.After(time.Now()) {
+		return time.Time{}, err
+	}
+	return expireTime, nil
+}
+
+func isExpired(tx *bolt.Tx, key []byte, now time.Time) bool {
+	expTime := tx.Bucket([]byte(expiryBktName)).Get(key)
+	return expTime != nil && !decodeTime(expTime).After(now)
+}
+
+func encodeTime(t time.Time) []byte {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, uint64(t.UnixNano()))
+	return buf
+}
+
+func decodeTime(data []byte) time.Time {
+	if len(data) != 8 {
+		return time.Time{}
+	}
+	return time.Unix(0, int64(binary.BigEndian.Uint64(data)))
 }
 
 // Close storage
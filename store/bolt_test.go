@@ -0,0 +1,186 @@
+package store
+
+import (
+	"io/ioutil"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vdimir/markify/store/storetest"
+	"github.com/vdimir/markify/testutil"
+	bolt "go.etcd.io/bbolt"
+)
+
+func createTestBolt(t *testing.T) (*Bolt, func()) {
+	tmpPath, tmpFolderClean := testutil.GetTempFolder(t, "test_bolt")
+	db, err := NewBoltStorage(path.Join(tmpPath, "data.bdb"))
+	require.NoError(t, err)
+	return db, func() {
+		defer tmpFolderClean()
+		assert.NoError(t, db.Close())
+	}
+}
+
+func TestBoltTTL(t *testing.T) {
+	db, teardown := createTestBolt(t)
+	defer teardown()
+
+	meta := map[string]string{"syntax": "markdown"}
+	require.NoError(t, db.SetBlob("short", strings.NewReader("foo"), meta, time.Millisecond*10))
+	require.NoError(t, db.SetBlob("long", strings.NewReader("bar"), meta, time.Hour))
+	require.NoError(t, db.SetBlob("forever", strings.NewReader("baz"), meta, 0))
+
+	data, gotMeta, err := db.GetBlob("short")
+	require.NoError(t, err)
+	require.NotNil(t, data)
+	assert.Equal(t, meta, gotMeta)
+
+	time.Sleep(time.Millisecond * 20)
+
+	data, gotMeta, err = db.GetBlob("short")
+	assert.NoError(t, err)
+	assert.Nil(t, data)
+	assert.Nil(t, gotMeta)
+
+	for key, expected := range map[string]string{"long": "bar", "forever": "baz"} {
+		data, _, err = db.GetBlob(key)
+		require.NoError(t, err)
+		require.NotNil(t, data)
+		text, err := ioutil.ReadAll(data)
+		require.NoError(t, err)
+		assert.Equal(t, expected, string(text))
+	}
+
+	// overwriting without ttl makes blob persistent
+	require.NoError(t, db.SetBlob("long", strings.NewReader("bar"), meta, 0))
+	n, err := db.DeleteExpired(time.Now().Add(time.Hour*2), 100)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	data, _, err = db.GetBlob("long")
+	assert.NoError(t, err)
+	assert.NotNil(t, data)
+}
+
+func TestBoltDeleteExpiredBatches(t *testing.T) {
+	db, teardown := createTestBolt(t)
+	defer teardown()
+
+	keys := []string{"a", "b", "c", "d", "e"}
+	for _, key := range keys {
+		require.NoError(t, db.SetBlob(key, strings.NewReader(key), nil, time.Minute))
+	}
+
+	now := time.Now().Add(time.Hour)
+	n, err := db.DeleteExpired(now, 2)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	n, err = db.DeleteExpired(now, 2)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	n, err = db.DeleteExpired(now, 2)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	n, err = db.DeleteExpired(now, 2)
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	err = db.db.View(func(tx *bolt.Tx) error {
+		for _, bkt := range []string{dataBktName, metaBktName, expiryBktName, expiryQueueBktName} {
+			assert.Equal(t, 0, tx.Bucket([]byte(bkt)).Stats().KeyN, "bucket %s is not empty", bkt)
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+}
+
+func TestBoltExpiryQueue(t *testing.T) {
+	tmpPath, tmpFolderClean := testutil.GetTempFolder(t, "test_bolt")
+	defer tmpFolderClean()
+	fileName := path.Join(tmpPath, "data.bdb")
+	db, err := NewBoltStorage(fileName)
+	require.NoError(t, err)
+
+	require.NoError(t, db.SetBlob("later", strings.NewReader("foo"), nil, time.Hour*2))
+	require.NoError(t, db.SetBlob("sooner", strings.NewReader("bar"), nil, time.Hour*3))
+	// ttl is changed, entry with previous expiration time is removed from queue
+	require.NoError(t, db.SetBlob("sooner", strings.NewReader("bar"), nil, time.Hour))
+	queueLen := func() int {
+		n := 0
+		assert.NoError(t, db.db.View(func(tx *bolt.Tx) error {
+			n = tx.Bucket([]byte(expiryQueueBktName)).Stats().KeyN
+			return nil
+		}))
+		return n
+	}
+	assert.Equal(t, 2, queueLen())
+
+	// database created before expiry queue was added
+	require.NoError(t, db.db.Update(func(tx *bolt.Tx) error {
+		return tx.DeleteBucket([]byte(expiryQueueBktName))
+	}))
+	require.NoError(t, db.Close())
+	db, err = NewBoltStorage(fileName)
+	require.NoError(t, err)
+	defer func() { assert.NoError(t, db.Close()) }()
+	assert.Equal(t, 2, queueLen())
+
+	n, err := db.DeleteExpired(time.Now().Add(time.Hour+time.Minute), 100)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	data, _, err := db.GetBlob("sooner")
+	require.NoError(t, err)
+	assert.Nil(t, data)
+	data, _, err = db.GetBlob("later")
+	require.NoError(t, err)
+	assert.NotNil(t, data)
+	assert.Equal(t, 1, queueLen())
+}
+
+func TestBoltTakeBlob(t *testing.T) {
+	db, teardown := createTestBolt(t)
+	defer teardown()
+
+	require.NoError(t, db.SetBlob("key", strings.NewReader("secret"), map[string]string{"burn": "true"}, 0))
+
+	const readers = 10
+	var wg sync.WaitGroup
+	var taken int32
+	for i := 0; i < readers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			data, meta, err := db.TakeBlob("key")
+			assert.NoError(t, err)
+			if data != nil {
+				atomic.AddInt32(&taken, 1)
+				assert.Equal(t, "true", meta["burn"])
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), taken)
+
+	data, _, err := db.GetBlob("key")
+	assert.NoError(t, err)
+	assert.Nil(t, data)
+
+	require.NoError(t, db.SetBlob("expired", strings.NewReader("secret"), nil, time.Millisecond))
+	time.Sleep(time.Millisecond * 5)
+	data, _, err = db.TakeBlob("expired")
+	assert.NoError(t, err)
+	assert.Nil(t, data)
+}
+
+func TestBoltConformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) storetest.Store {
+		db, teardown := createTestBolt(t)
+		t.Cleanup(teardown)
+		return db
+	})
+}
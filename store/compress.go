@@ -0,0 +1,282 @@
+package store
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+)
+
+// compressionMetaKey is reserved metadata key with codec of compressed blob
+const compressionMetaKey = "compression"
+
+// compression codecs
+const (
+	CodecGzip = "gzip"
+	CodecZstd = "zstd"
+)
+
+// compressMinSize is size of data which is stored as is, compression of smaller blobs doesn't pay off
+const compressMinSize = 256
+
+// Compress stores blobs compressed with codec and records codec in metadata.
+// Blobs stored without compression or with other codec are readable,
+// so it can wrap store with existing data and codec can be changed
+type Compress struct {
+	store Store
+	codec string
+
+	// encoder is created only for zstd codec, decoder is created on first read of blob compressed with zstd
+	zstdEncoder     *zstd.Encoder
+	zstdDecoderOnce sync.Once
+	zstdDecoder     *zstd.Decoder
+	zstdDecoderErr  error
+}
+
+// NewCompress creates Compress storing data in store compressed with codec
+func NewCompress(store Store, codec string) (*Compress, error) {
+	if codec != CodecGzip && codec != CodecZstd {
+		return nil, errors.Errorf("unknown compression codec %q", codec)
+	}
+	c := &Compress{store: store, codec: codec}
+	if codec == CodecZstd {
+		encoder, err := zstd.NewWriter(nil)
+		if err != nil {
+			return nil, errors.Wrap(err, "can't create zstd encoder")
+		}
+		c.zstdEncoder = encoder
+	}
+	return c, nil
+}
+
+// SetBlob saves compressed data, data is saved as is if it's too small or doesn't compress
+func (c *Compress) SetBlob(key string, reader io.Reader, meta map[string]string, ttl time.Duration) error {
+	return c.setBlob(c.store, key, reader, meta, ttl)
+}
+
+// SetBlobContext saves compressed data with underlying store bound to ctx
+func (c *Compress) SetBlobContext(ctx context.Context, key string, reader io.Reader, meta map[string]string, ttl time.Duration) error {
+	return c.setBlob(WithContext(ctx, c.store), key, reader, meta, ttl)
+}
+
+func (c *Compress) setBlob(s Store, key string, reader io.Reader, meta map[string]string, ttl time.Duration) error {
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return errors.Wrap(err, "can't read data from reader")
+	}
+	storedMeta := withoutMetaKeys(meta, compressionMetaKey)
+	if len(data) >= compressMinSize {
+		compressed, err := c.compress(data)
+		if err != nil {
+			return err
+		}
+		if len(compressed) < len(data) {
+			data = compressed
+			storedMeta[compressionMetaKey] = c.codec
+		}
+	}
+	return s.SetBlob(key, bytes.NewReader(data), storedMeta, ttl)
+}
+
+// GetBlob returns decompressed data
+func (c *Compress) GetBlob(key string) (io.Reader, map[string]string, error) {
+	data, meta, err := c.store.GetBlob(key)
+	return c.decompress(data, meta, err)
+}
+
+// GetBlobContext returns decompressed data with underlying store bound to ctx
+func (c *Compress) GetBlobContext(ctx context.Context, key string) (io.Reader, map[string]string, error) {
+	data, meta, err := WithContext(ctx, c.store).GetBlob(key)
+	return c.decompress(data, meta, err)
+}
+
+// TakeBlob returns decompressed data and removes it
+func (c *Compress) TakeBlob(key string) (io.Reader, map[string]string, error) {
+	data, meta, err := c.store.TakeBlob(key)
+	return c.decompress(data, meta, err)
+}
+
+// TakeBlobContext returns decompressed data and removes it with underlying store bound to ctx
+func (c *Compress) TakeBlobContext(ctx context.Context, key string) (io.Reader, map[string]string, error) {
+	data, meta, err := WithContext(ctx, c.store).TakeBlob(key)
+	return c.decompress(data, meta, err)
+}
+
+// DeleteBlob removes data
+func (c *Compress) DeleteBlob(key string) error {
+	return c.store.DeleteBlob(key)
+}
+
+// DeleteBlobContext removes data with underlying store bound to ctx
+func (c *Compress) DeleteBlobContext(ctx context.Context, key string) error {
+	return WithContext(ctx, c.store).DeleteBlob(key)
+}
+
+// DeleteExpired removes expired blobs if underlying store has to do it explicitly
+func (c *Compress) DeleteExpired(now time.Time, limit int) (int, error) {
+	sweeper, ok := c.store.(interface {
+		DeleteExpired(now time.Time, limit int) (int, error)
+	})
+	if !ok {
+		return 0, nil
+	}
+	return sweeper.DeleteExpired(now, limit)
+}
+
+// GetMeta returns metadata without reading data
+func (c *Compress) GetMeta(key string) (map[string]string, error) {
+	meta, err := blobMeta(c.store, key)
+	return withoutCompressionMeta(meta), err
+}
+
+// GetMetaContext returns metadata with request to underlying store bound to ctx
+func (c *Compress) GetMetaContext(ctx context.Context, key string) (map[string]string, error) {
+	meta, err := blobMeta(WithContext(ctx, c.store), key)
+	return withoutCompressionMeta(meta), err
+}
+
+// GetExpireTime returns expiration time of blob in underlying store
+func (c *Compress) GetExpireTime(key string) (time.Time, error) {
+	return blobExpireTime(c.store, key)
+}
+
+// ListKeys returns keys of underlying store
+func (c *Compress) ListKeys(prefix string, after string, limit int) ([]string, error) {
+	lister, ok := c.store.(interface {
+		ListKeys(prefix string, after string, limit int) ([]string, error)
+	})
+	if !ok {
+		return nil, errors.New("underlying store can't list keys")
+	}
+	return lister.ListKeys(prefix, after, limit)
+}
+
+// QueryBlobs returns blobs of underlying store matching query without reserved metadata keys.
+// Returns ErrQueryNotSupported if underlying store can't query blobs
+func (c *Compress) QueryBlobs(q Query) ([]BlobInfo, error) {
+	blobs, err := queryBlobs(c.store, q)
+	for i := range blobs {
+		blobs[i].Meta = withoutCompressionMeta(blobs[i].Meta)
+	}
+	return blobs, err
+}
+
+// Close closes underlying store if it has to be closed
+func (c *Compress) Close() error {
+	if c.zstdEncoder != nil {
+		c.zstdEncoder.Close()
+	}
+	// decoder isn't created after store is closed
+	c.zstdDecoderOnce.Do(func() {})
+	if c.zstdDecoder != nil {
+		c.zstdDecoder.Close()
+	}
+	if closer, ok := c.store.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+func (c *Compress) compress(data []byte) ([]byte, error) {
+	if c.codec == CodecZstd {
+		return c.zstdEncoder.EncodeAll(data, nil), nil
+	}
+	buf := &bytes.Buffer{}
+	w := gzip.NewWriter(buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, errors.Wrap(err, "gzip compression error")
+	}
+	if err := w.Close(); err != nil {
+		return nil, errors.Wrap(err, "gzip compression error")
+	}
+	return buf.Bytes(), nil
+}
+
+// decompress returns reader of decompressed data and metadata without reserved keys
+func (c *Compress) decompress(data io.Reader, meta map[string]string, err error) (io.Reader, map[string]string, error) {
+	if err != nil || data == nil {
+		return nil, nil, err
+	}
+	switch codec := meta[compressionMetaKey]; codec {
+	case "":
+		return data, meta, nil
+	case CodecGzip:
+		r, err := gzip.NewReader(data)
+		if err != nil {
+			closeReader(data)
+			return nil, nil, errors.Wrap(err, "gzip decompression error")
+		}
+		return &gzipReader{Reader: r, data: data}, withoutCompressionMeta(meta), nil
+	case CodecZstd:
+		compressed, err := ioutil.ReadAll(data)
+		closeReader(data)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "can't read compressed data")
+		}
+		decoder, err := c.decoder()
+		if err != nil {
+			return nil, nil, err
+		}
+		res, err := decoder.DecodeAll(compressed, nil)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "zstd decompression error")
+		}
+		return bytes.NewReader(res), withoutCompressionMeta(meta), nil
+	default:
+		closeReader(data)
+		return nil, nil, errors.Errorf("unknown compression codec %q", codec)
+	}
+}
+
+// decoder returns zstd decoder, blobs compressed with zstd are read by store with any codec
+func (c *Compress) decoder() (*zstd.Decoder, error) {
+	c.zstdDecoderOnce.Do(func() {
+		c.zstdDecoder, c.zstdDecoderErr = zstd.NewReader(nil)
+	})
+	return c.zstdDecoder, errors.Wrap(c.zstdDecoderErr, "can't create zstd decoder")
+}
+
+// gzipReader closes reader of compressed data with gzip.Reader, it doesn't close underlying reader itself
+type gzipReader struct {
+	*gzip.Reader
+	data io.Reader
+}
+
+func (r *gzipReader) Close() error {
+	err := r.Reader.Close()
+	closeReader(r.data)
+	return err
+}
+
+// closeReader closes reader of underlying store, e.g. releases connection of s3 object
+func closeReader(r io.Reader) {
+	if closer, ok := r.(io.Closer); ok {
+		_ = closer.Close()
+	}
+}
+
+// withoutCompressionMeta returns copy of metadata without reserved key, nil for nil meta
+func withoutCompressionMeta(meta map[string]string) map[string]string {
+	if meta == nil {
+		return nil
+	}
+	return withoutMetaKeys(meta, compressionMetaKey)
+}
+
+// withoutMetaKeys returns copy of metadata without keys
+func withoutMetaKeys(meta map[string]string, keys ...string) map[string]string {
+	res := make(map[string]string, len(meta))
+	for k, v := range meta {
+		res[k] = v
+	}
+	for _, k := range keys {
+		delete(res, k)
+	}
+	return res
+}
@@ -0,0 +1,133 @@
+package store
+
+import (
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vdimir/markify/store/storetest"
+)
+
+func createTestCompress(t *testing.T, store Store, codec string) *Compress {
+	c, err := NewCompress(store, codec)
+	require.NoError(t, err)
+	return c
+}
+
+func TestCompressConformance(t *testing.T) {
+	for _, codec := range []string{CodecGzip, CodecZstd} {
+		t.Run(codec, func(t *testing.T) {
+			storetest.Run(t, func(t *testing.T) storetest.Store {
+				return createTestCompress(t, NewMemoryStorage(0), codec)
+			})
+		})
+	}
+	t.Run("s3", func(t *testing.T) {
+		storetest.Run(t, func(t *testing.T) storetest.Store {
+			return createTestCompress(t, createTestS3(t), CodecZstd)
+		})
+	})
+}
+
+func TestCompressContent(t *testing.T) {
+	logs := strings.Repeat("2021-03-01 12:00:00 [INFO] request handled in 5ms\n", 1000)
+	meta := map[string]string{"syntax": "log"}
+
+	for _, codec := range []string{CodecGzip, CodecZstd} {
+		t.Run(codec, func(t *testing.T) {
+			inner := NewMemoryStorage(0)
+			c := createTestCompress(t, inner, codec)
+
+			require.NoError(t, c.SetBlob("logs", strings.NewReader(logs), meta, 0))
+			assert.Less(t, inner.Size(), int64(len(logs)/5), "data is compressed")
+			_, innerMeta, err := inner.GetBlob("logs")
+			require.NoError(t, err)
+			assert.Equal(t, codec, innerMeta[compressionMetaKey])
+			storetest.RequireBlob(t, c, "logs", []byte(logs), meta)
+
+			// small data is stored as is
+			require.NoError(t, c.SetBlob("small", strings.NewReader("text"), meta, 0))
+			storetest.RequireBlob(t, inner, "small", []byte("text"), meta)
+
+			// blobs stored before compression are readable
+			require.NoError(t, inner.SetBlob("legacy", strings.NewReader(logs), meta, 0))
+			storetest.RequireBlob(t, c, "legacy", []byte(logs), meta)
+
+			// reserved metadata key can't be set by caller
+			require.NoError(t, c.SetBlob("fake", strings.NewReader("text"), map[string]string{compressionMetaKey: CodecGzip}, 0))
+			storetest.RequireBlob(t, c, "fake", []byte("text"), map[string]string{})
+		})
+	}
+
+	// codec can be changed, data compressed with previous one is readable
+	inner := NewMemoryStorage(0)
+	require.NoError(t, createTestCompress(t, inner, CodecGzip).SetBlob("gzip", strings.NewReader(logs), meta, 0))
+	c := createTestCompress(t, inner, CodecZstd)
+	storetest.RequireBlob(t, c, "gzip", []byte(logs), meta)
+	gzipStore := createTestCompress(t, inner, CodecGzip)
+	assert.Nil(t, gzipStore.zstdEncoder, "zstd encoder is created only for zstd codec")
+	require.NoError(t, c.SetBlob("zstd", strings.NewReader(logs), meta, 0))
+	storetest.RequireBlob(t, gzipStore, "zstd", []byte(logs), meta)
+	require.NoError(t, gzipStore.Close())
+
+	require.NoError(t, inner.SetBlob("broken", strings.NewReader(logs), map[string]string{compressionMetaKey: CodecZstd}, 0))
+	_, _, err := c.GetBlob("broken")
+	assert.Error(t, err)
+	require.NoError(t, inner.SetBlob("unknown", strings.NewReader(logs), map[string]string{compressionMetaKey: "lz4"}, 0))
+	_, _, err = c.GetBlob("unknown")
+	assert.Error(t, err)
+
+	_, err = NewCompress(inner, "lz4")
+	assert.Error(t, err)
+}
+
+// closeTrackingStore returns readers that record whether they are closed
+type closeTrackingStore struct {
+	Store
+	readers []*trackedReader
+}
+
+type trackedReader struct {
+	io.Reader
+	closed bool
+}
+
+func (r *trackedReader) Close() error {
+	r.closed = true
+	return nil
+}
+
+func (s *closeTrackingStore) GetBlob(key string) (io.Reader, map[string]string, error) {
+	data, meta, err := s.Store.GetBlob(key)
+	if err != nil || data == nil {
+		return data, meta, err
+	}
+	r := &trackedReader{Reader: data}
+	s.readers = append(s.readers, r)
+	return r, meta, nil
+}
+
+func TestCompressClosesReader(t *testing.T) {
+	logs := strings.Repeat("2021-03-01 12:00:00 [INFO] request handled in 5ms\n", 100)
+	for _, codec := range []string{CodecGzip, CodecZstd} {
+		t.Run(codec, func(t *testing.T) {
+			inner := &closeTrackingStore{Store: NewMemoryStorage(0)}
+			c := createTestCompress(t, inner, codec)
+			require.NoError(t, c.SetBlob("logs", strings.NewReader(logs), nil, 0))
+
+			data, _, err := c.GetBlob("logs")
+			require.NoError(t, err)
+			content, err := ioutil.ReadAll(data)
+			require.NoError(t, err)
+			assert.Equal(t, logs, string(content))
+			if closer, ok := data.(io.Closer); ok {
+				require.NoError(t, closer.Close())
+			}
+			require.Len(t, inner.readers, 1)
+			assert.True(t, inner.readers[0].closed, "reader of underlying store is closed")
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package store
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ContextStore is implemented by stores which operations can be cancelled with context,
+// methods are the same as in Store but take context as first argument
+type ContextStore interface {
+	SetBlobContext(ctx context.Context, key string, reader io.Reader, meta map[string]string, ttl time.Duration) error
+	GetBlobContext(ctx context.Context, key string) (io.Reader, map[string]string, error)
+	TakeBlobContext(ctx context.Context, key string) (io.Reader, map[string]string, error)
+	DeleteBlobContext(ctx context.Context, key string) error
+}
+
+// metaReaderContext is implemented by stores which metadata requests can be cancelled with context
+type metaReaderContext interface {
+	GetMetaContext(ctx context.Context, key string) (map[string]string, error)
+}
+
+// WithContext returns store with operations bound to ctx. Stores that don't implement ContextStore
+// are not interrupted by ctx, but operation is not started if ctx is already done
+func WithContext(ctx context.Context, s Store) Store {
+	if bound, ok := s.(*contextStore); ok {
+		s = bound.store
+	}
+	return &contextStore{ctx: ctx, store: s}
+}
+
+// contextStore calls methods of store with context
+type contextStore struct {
+	ctx   context.Context
+	store Store
+}
+
+// SetBlob saves data with context
+func (c *contextStore) SetBlob(key string, reader io.Reader, meta map[string]string, ttl time.Duration) error {
+	if s, ok := c.store.(ContextStore); ok {
+		return s.SetBlobContext(c.ctx, key, reader, meta, ttl)
+	}
+	if err := c.ctx.Err(); err != nil {
+		return err
+	}
+	return c.store.SetBlob(key, reader, meta, ttl)
+}
+
+// GetBlob returns data with context, reader of some stores loads data with the same context
+func (c *contextStore) GetBlob(key string) (io.Reader, map[string]string, error) {
+	if s, ok := c.store.(ContextStore); ok {
+		return s.GetBlobContext(c.ctx, key)
+	}
+	if err := c.ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	return c.store.GetBlob(key)
+}
+
+// TakeBlob returns data and removes it with context
+func (c *contextStore) TakeBlob(key string) (io.Reader, map[string]string, error) {
+	if s, ok := c.store.(ContextStore); ok {
+		return s.TakeBlobContext(c.ctx, key)
+	}
+	if err := c.ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	return c.store.TakeBlob(key)
+}
+
+// DeleteBlob removes data with context
+func (c *contextStore) DeleteBlob(key string) error {
+	if s, ok := c.store.(ContextStore); ok {
+		return s.DeleteBlobContext(c.ctx, key)
+	}
+	if err := c.ctx.Err(); err != nil {
+		return err
+	}
+	return c.store.DeleteBlob(key)
+}
+
+// GetMeta returns metadata with context, data is read if store can't read metadata separately
+func (c *contextStore) GetMeta(key string) (map[string]string, error) {
+	if s, ok := c.store.(metaReaderContext); ok {
+		return s.GetMetaContext(c.ctx, key)
+	}
+	if err := c.ctx.Err(); err != nil {
+		return nil, err
+	}
+	return blobMeta(c.store, key)
+}
+
+// blobMeta returns metadata of blob, nil if blob not found. Data is read if store can't read metadata separately
+func blobMeta(s Store, key string) (map[string]string, error) {
+	if metaReader, ok := s.(interface {
+		GetMeta(key string) (map[string]string, error)
+	}); ok {
+		return metaReader.GetMeta(key)
+	}
+	data, meta, err := s.GetBlob(key)
+	if err != nil || data == nil {
+		return nil, err
+	}
+	if meta == nil {
+		meta = map[string]string{}
+	}
+	return meta, nil
+}
+
+// GetExpireTime returns expiration time of blob if store reports it, it's not interrupted by context
+func (c *contextStore) GetExpireTime(key string) (time.Time, error) {
+	if err := c.ctx.Err(); err != nil {
+		return time.Time{}, err
+	}
+	return blobExpireTime(c.store, key)
+}
+
+// ListKeys returns keys of store if it can list them, it's not interrupted by context
+func (c *contextStore) ListKeys(prefix string, after string, limit int) ([]string, error) {
+	lister, ok := c.store.(interface {
+		ListKeys(prefix string, after string, limit int) ([]string, error)
+	})
+	if !ok {
+		return nil, errors.New("store can't list keys")
+	}
+	if err := c.ctx.Err(); err != nil {
+		return nil, err
+	}
+	return lister.ListKeys(prefix, after, limit)
+}
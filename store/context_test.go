@@ -0,0 +1,71 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vdimir/markify/store/storetest"
+)
+
+func TestContextStoreConformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) storetest.Store {
+		return WithContext(context.Background(), NewMemoryStorage(0))
+	})
+	storetest.Run(t, func(t *testing.T) storetest.Store {
+		return WithContext(context.Background(), createTestS3(t))
+	})
+}
+
+func TestContextCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	stores := map[string]Store{
+		"memory": NewMemoryStorage(0),
+		"s3":     createTestS3(t),
+		"dedup":  NewDedup(createTestS3(t)),
+	}
+	for name, s := range stores {
+		t.Run(name, func(t *testing.T) {
+			require.NoError(t, s.SetBlob("key", strings.NewReader("data"), nil, 0))
+
+			bound := WithContext(ctx, s)
+			err := bound.SetBlob("other", strings.NewReader("data"), nil, 0)
+			assert.True(t, errors.Is(err, context.Canceled), "set: %v", err)
+			_, _, err = bound.GetBlob("key")
+			assert.True(t, errors.Is(err, context.Canceled), "get: %v", err)
+			_, _, err = bound.TakeBlob("key")
+			assert.True(t, errors.Is(err, context.Canceled), "take: %v", err)
+			err = bound.DeleteBlob("key")
+			assert.True(t, errors.Is(err, context.Canceled), "delete: %v", err)
+
+			storetest.RequireBlob(t, s, "key", []byte("data"), nil)
+			storetest.RequireMissing(t, s, "other")
+		})
+	}
+}
+
+func TestContextAbortsDownload(t *testing.T) {
+	s3 := createTestS3(t)
+	data := make([]byte, 16<<20)
+	rand.New(rand.NewSource(42)).Read(data)
+	require.NoError(t, s3.SetBlob("large", bytes.NewReader(data), nil, 0))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	reader, _, err := WithContext(ctx, s3).GetBlob("large")
+	require.NoError(t, err)
+	require.NotNil(t, reader)
+	_, err = reader.Read(make([]byte, 1024))
+	require.NoError(t, err)
+
+	cancel()
+	_, err = ioutil.ReadAll(reader)
+	assert.True(t, errors.Is(err, context.Canceled), "download is aborted: %v", err)
+}
@@ -0,0 +1,450 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"hash/fnv"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Store is interface of blob storage wrapped by decorators, it's the same as app.Store
+type Store interface {
+	SetBlob(key string, reader io.Reader, meta map[string]string, ttl time.Duration) error
+	GetBlob(key string) (io.Reader, map[string]string, error)
+	TakeBlob(key string) (io.Reader, map[string]string, error)
+	DeleteBlob(key string) error
+}
+
+const dedupDataPrefix = "_dedup/data/"
+const dedupRefsPrefix = "_dedup/refs/"
+
+// dedupHashMetaKey is reserved metadata key of reference with hash of its content
+const dedupHashMetaKey = "dedup_hash"
+
+// dedupMinSize is minimal size of data stored once, smaller blobs are stored under their keys directly,
+// so empty blobs like index entries don't share one growing list of references
+const dedupMinSize = 256
+
+const dedupLocksCount = 64
+const dedupReadAttempts = 3
+
+var errContentNotFound = errors.New("referenced content not found")
+
+// Dedup stores content of identical blobs once. Blob key holds reference with its own metadata and ttl,
+// content is stored under hash of data with list of references to it.
+// Content is deleted with its last reference. Content referenced by blobs with ttl expires
+// not earlier than they do, so references expired without explicit deletion are not leaked.
+// References are counted under locks within process, so store can't be shared by several instances,
+// concurrent updates of references by other process are lost and content still referenced could be deleted.
+// Blobs smaller than dedupMinSize and blobs with keys of direct prefixes are stored as is
+type Dedup struct {
+	store          Store
+	directPrefixes []string
+
+	keyLocks  *[dedupLocksCount]sync.Mutex
+	hashLocks *[dedupLocksCount]sync.Mutex
+}
+
+// dedupRefs is list of references to content with their expiration times
+type dedupRefs struct {
+	Refs       map[string]int64 `json:"refs"`        // key to unix nano expiration time, zero for no ttl
+	ExpireTime int64            `json:"expire_time"` // expiration time of content, zero for no ttl
+}
+
+// NewDedup creates Dedup storing data in store, blobs with keys starting with one of directPrefixes are not deduplicated
+func NewDedup(store Store, directPrefixes ...string) *Dedup {
+	return &Dedup{
+		store:          store,
+		directPrefixes: directPrefixes,
+		keyLocks:       &[dedupLocksCount]sync.Mutex{},
+		hashLocks:      &[dedupLocksCount]sync.Mutex{},
+	}
+}
+
+// SetBlob saves reference to content, content is saved if it's not stored yet
+func (d *Dedup) SetBlob(key string, reader io.Reader, meta map[string]string, ttl time.Duration) error {
+	return d.setBlob(d.store, key, reader, meta, ttl)
+}
+
+// SetBlobContext saves reference and content with operations of underlying store bound to ctx
+func (d *Dedup) SetBlobContext(ctx context.Context, key string, reader io.Reader, meta map[string]string, ttl time.Duration) error {
+	return d.setBlob(WithContext(ctx, d.store), key, reader, meta, ttl)
+}
+
+func (d *Dedup) setBlob(s Store, key string, reader io.Reader, meta map[string]string, ttl time.Duration) error {
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return errors.Wrap(err, "can't read data from reader")
+	}
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+
+	lock := stripeLock(d.keyLocks, key)
+	lock.Lock()
+	defer lock.Unlock()
+
+	oldHash, err := d.refHash(s, key)
+	if err != nil {
+		return err
+	}
+	if d.storedDirectly(key, data) {
+		if err = s.SetBlob(key, bytes.NewReader(data), withoutMetaKeys(meta, dedupHashMetaKey), ttl); err != nil {
+			return err
+		}
+		if oldHash != "" {
+			return d.releaseRef(oldHash, key)
+		}
+		return nil
+	}
+	if err = d.addRef(s, hash, key, data, ttl); err != nil {
+		return err
+	}
+	refMeta := make(map[string]string, len(meta)+1)
+	for k, v := range meta {
+		refMeta[k] = v
+	}
+	refMeta[dedupHashMetaKey] = hash
+	if err = s.SetBlob(key, bytes.NewReader(nil), refMeta, ttl); err != nil {
+		return err
+	}
+	if oldHash != "" && oldHash != hash {
+		return d.releaseRef(oldHash, key)
+	}
+	return nil
+}
+
+// storedDirectly returns true if blob is stored under its key without deduplication
+func (d *Dedup) storedDirectly(key string, data []byte) bool {
+	if len(data) < dedupMinSize {
+		return true
+	}
+	for _, prefix := range d.directPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetBlob returns content referenced by key. Blobs saved without Dedup are returned as is
+func (d *Dedup) GetBlob(key string) (io.Reader, map[string]string, error) {
+	return d.getBlob(d.store, key)
+}
+
+// GetBlobContext returns content referenced by key with operations of underlying store bound to ctx
+func (d *Dedup) GetBlobContext(ctx context.Context, key string) (io.Reader, map[string]string, error) {
+	return d.getBlob(WithContext(ctx, d.store), key)
+}
+
+func (d *Dedup) getBlob(s Store, key string) (io.Reader, map[string]string, error) {
+	for attempt := 0; ; attempt++ {
+		data, meta, err := s.GetBlob(key)
+		if err != nil || data == nil {
+			return nil, nil, err
+		}
+		data, meta, err = d.resolve(s, key, data, meta)
+		// content could be released by concurrent update of reference, then reference is read again
+		if err != errContentNotFound || attempt == dedupReadAttempts-1 {
+			return data, meta, err
+		}
+	}
+}
+
+// TakeBlob returns content referenced by key and removes reference
+func (d *Dedup) TakeBlob(key string) (io.Reader, map[string]string, error) {
+	return d.takeBlob(d.store, key)
+}
+
+// TakeBlobContext returns content and removes reference with operations of underlying store bound to ctx
+func (d *Dedup) TakeBlobContext(ctx context.Context, key string) (io.Reader, map[string]string, error) {
+	return d.takeBlob(WithContext(ctx, d.store), key)
+}
+
+func (d *Dedup) takeBlob(s Store, key string) (io.Reader, map[string]string, error) {
+	lock := stripeLock(d.keyLocks, key)
+	lock.Lock()
+	defer lock.Unlock()
+
+	data, meta, err := s.TakeBlob(key)
+	if err != nil || data == nil {
+		return nil, nil, err
+	}
+	hash := meta[dedupHashMetaKey]
+	data, meta, err = d.resolve(s, key, data, meta)
+	if err != nil || hash == "" {
+		return data, meta, err
+	}
+	// reader of some stores loads data lazily, so content is read before it could be deleted
+	content, err := ioutil.ReadAll(data)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "can't read content")
+	}
+	if err = d.releaseRef(hash, key); err != nil {
+		return nil, nil, err
+	}
+	return bytes.NewReader(content), meta, nil
+}
+
+// DeleteBlob removes reference and content if it's not referenced anymore
+func (d *Dedup) DeleteBlob(key string) error {
+	return d.deleteBlob(d.store, key)
+}
+
+// DeleteBlobContext removes reference with operations of underlying store bound to ctx
+func (d *Dedup) DeleteBlobContext(ctx context.Context, key string) error {
+	return d.deleteBlob(WithContext(ctx, d.store), key)
+}
+
+func (d *Dedup) deleteBlob(s Store, key string) error {
+	lock := stripeLock(d.keyLocks, key)
+	lock.Lock()
+	defer lock.Unlock()
+
+	hash, err := d.refHash(s, key)
+	if err != nil {
+		return err
+	}
+	if err = s.DeleteBlob(key); err != nil {
+		return err
+	}
+	if hash != "" {
+		return d.releaseRef(hash, key)
+	}
+	return nil
+}
+
+// DeleteExpired removes expired blobs if underlying store has to do it explicitly
+func (d *Dedup) DeleteExpired(now time.Time, limit int) (int, error) {
+	sweeper, ok := d.store.(interface {
+		DeleteExpired(now time.Time, limit int) (int, error)
+	})
+	if !ok {
+		return 0, nil
+	}
+	return sweeper.DeleteExpired(now, limit)
+}
+
+// GetMeta returns metadata of reference without reading content
+func (d *Dedup) GetMeta(key string) (map[string]string, error) {
+	return d.getMeta(d.store, key)
+}
+
+// GetMetaContext returns metadata of reference with request to underlying store bound to ctx
+func (d *Dedup) GetMetaContext(ctx context.Context, key string) (map[string]string, error) {
+	return d.getMeta(WithContext(ctx, d.store), key)
+}
+
+func (d *Dedup) getMeta(s Store, key string) (map[string]string, error) {
+	meta, err := blobMeta(s, key)
+	if err != nil || meta == nil {
+		return nil, err
+	}
+	res := make(map[string]string, len(meta))
+	for k, v := range meta {
+		if k != dedupHashMetaKey {
+			res[k] = v
+		}
+	}
+	return res, nil
+}
+
+// GetExpireTime returns expiration time of reference, content expires not earlier than its references
+func (d *Dedup) GetExpireTime(key string) (time.Time, error) {
+	return blobExpireTime(d.store, key)
+}
+
+// ListKeys returns keys of underlying store without keys of content and references
+func (d *Dedup) ListKeys(prefix string, after string, limit int) ([]string, error) {
+	lister, ok := d.store.(interface {
+		ListKeys(prefix string, after string, limit int) ([]string, error)
+	})
+	if !ok {
+		return nil, errors.New("underlying store can't list keys")
+	}
+	for {
+		keys, err := lister.ListKeys(prefix, after, limit)
+		if err != nil || len(keys) == 0 {
+			return nil, err
+		}
+		res := make([]string, 0, len(keys))
+		for _, key := range keys {
+			if !strings.HasPrefix(key, dedupDataPrefix) && !strings.HasPrefix(key, dedupRefsPrefix) {
+				res = append(res, key)
+			}
+		}
+		// page with only reserved keys is skipped, empty result means there are no keys left
+		if len(res) > 0 {
+			return res, nil
+		}
+		after = keys[len(keys)-1]
+	}
+}
+
+// QueryBlobs returns references of underlying store matching query without reserved metadata keys.
+// Content and lists of references are stored without metadata, so they match only queries by expiration time
+// and are skipped then, page of result could be shorter than limit.
+// Returns ErrQueryNotSupported if underlying store can't query blobs
+func (d *Dedup) QueryBlobs(q Query) ([]BlobInfo, error) {
+	blobs, err := queryBlobs(d.store, q)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]BlobInfo, 0, len(blobs))
+	for _, blob := range blobs {
+		if strings.HasPrefix(blob.Key, dedupDataPrefix) || strings.HasPrefix(blob.Key, dedupRefsPrefix) {
+			continue
+		}
+		blob.Meta = withoutMetaKeys(blob.Meta, dedupHashMetaKey)
+		res = append(res, blob)
+	}
+	return res, nil
+}
+
+// Close closes underlying store if it has to be closed
+func (d *Dedup) Close() error {
+	if closer, ok := d.store.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// resolve returns content of reference and metadata without reserved keys
+func (d *Dedup) resolve(s Store, key string, data io.Reader, meta map[string]string) (io.Reader, map[string]string, error) {
+	hash, ok := meta[dedupHashMetaKey]
+	if !ok {
+		return data, meta, nil
+	}
+	content, _, err := s.GetBlob(dedupDataPrefix + hash)
+	if err != nil {
+		return nil, nil, err
+	}
+	if content == nil {
+		return nil, nil, errContentNotFound
+	}
+	res := make(map[string]string, len(meta)-1)
+	for k, v := range meta {
+		if k != dedupHashMetaKey {
+			res[k] = v
+		}
+	}
+	return content, res, nil
+}
+
+// refHash returns hash of content referenced by key, empty if key is missing or saved without Dedup
+func (d *Dedup) refHash(s Store, key string) (string, error) {
+	data, meta, err := s.GetBlob(key)
+	if err != nil || data == nil {
+		return "", err
+	}
+	return meta[dedupHashMetaKey], nil
+}
+
+// addRef adds reference to content and saves content if it's missing or expires earlier than reference
+func (d *Dedup) addRef(s Store, hash string, key string, data []byte, ttl time.Duration) error {
+	lock := stripeLock(d.hashLocks, hash)
+	lock.Lock()
+	defer lock.Unlock()
+
+	now := time.Now()
+	refs, err := d.loadRefs(s, hash, now)
+	if err != nil {
+		return err
+	}
+	var refExpire int64
+	if ttl > 0 {
+		refExpire = now.Add(ttl).UnixNano()
+	}
+	saveContent := len(refs.Refs) == 0 || (refs.ExpireTime != 0 && (refExpire == 0 || refExpire > refs.ExpireTime))
+	refs.Refs[key] = refExpire
+	if saveContent {
+		// content lives twice as long as reference, so it's not uploaded again for every new reference
+		refs.ExpireTime = 0
+		if refExpire != 0 {
+			refs.ExpireTime = now.Add(2 * ttl).UnixNano()
+		}
+		if err = s.SetBlob(dedupDataPrefix+hash, bytes.NewReader(data), nil, refs.ttl(now)); err != nil {
+			return errors.Wrap(err, "can't save content")
+		}
+	}
+	return d.saveRefs(s, hash, refs, now)
+}
+
+// releaseRef removes reference to content, content without references is deleted.
+// It's done after reference is changed, so operations are not bound to context of caller
+// and have time limits of underlying store, otherwise content could be left without references
+func (d *Dedup) releaseRef(hash string, key string) error {
+	s := d.store
+	lock := stripeLock(d.hashLocks, hash)
+	lock.Lock()
+	defer lock.Unlock()
+
+	now := time.Now()
+	refs, err := d.loadRefs(s, hash, now)
+	if err != nil {
+		return err
+	}
+	delete(refs.Refs, key)
+	if len(refs.Refs) > 0 {
+		return d.saveRefs(s, hash, refs, now)
+	}
+	if err = s.DeleteBlob(dedupDataPrefix + hash); err != nil {
+		return errors.Wrap(err, "can't delete content")
+	}
+	return s.DeleteBlob(dedupRefsPrefix + hash)
+}
+
+// loadRefs returns references to content which are not expired
+func (d *Dedup) loadRefs(s Store, hash string, now time.Time) (*dedupRefs, error) {
+	refs := &dedupRefs{Refs: map[string]int64{}}
+	data, _, err := s.GetBlob(dedupRefsPrefix + hash)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't load references")
+	}
+	if data == nil {
+		return refs, nil
+	}
+	if err = json.NewDecoder(data).Decode(refs); err != nil {
+		return nil, errors.Wrapf(err, "broken references of %q", hash)
+	}
+	if refs.Refs == nil {
+		refs.Refs = map[string]int64{}
+	}
+	for key, expireTime := range refs.Refs {
+		if expireTime != 0 && expireTime <= now.UnixNano() {
+			delete(refs.Refs, key)
+		}
+	}
+	return refs, nil
+}
+
+// saveRefs saves references with the same ttl as content
+func (d *Dedup) saveRefs(s Store, hash string, refs *dedupRefs, now time.Time) error {
+	data, err := json.Marshal(refs)
+	if err != nil {
+		return err
+	}
+	return errors.Wrap(s.SetBlob(dedupRefsPrefix+hash, bytes.NewReader(data), nil, refs.ttl(now)),
+		"can't save references")
+}
+
+func (r *dedupRefs) ttl(now time.Time) time.Duration {
+	if r.ExpireTime == 0 {
+		return 0
+	}
+	return time.Unix(0, r.ExpireTime).Sub(now)
+}
+
+func stripeLock(locks *[dedupLocksCount]sync.Mutex, key string) *sync.Mutex {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return &locks[h.Sum32()%dedupLocksCount]
+}
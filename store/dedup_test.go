@@ -0,0 +1,129 @@
+package store
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vdimir/markify/store/storetest"
+)
+
+func TestDedupConformance(t *testing.T) {
+	t.Run("memory", func(t *testing.T) {
+		storetest.Run(t, func(t *testing.T) storetest.Store {
+			return NewDedup(NewMemoryStorage(0))
+		})
+	})
+	t.Run("bolt", func(t *testing.T) {
+		storetest.Run(t, func(t *testing.T) storetest.Store {
+			db, teardown := createTestBolt(t)
+			t.Cleanup(teardown)
+			return NewDedup(db)
+		})
+	})
+	t.Run("s3", func(t *testing.T) {
+		storetest.Run(t, func(t *testing.T) storetest.Store {
+			return NewDedup(createTestS3(t))
+		})
+	})
+}
+
+func TestDedupContent(t *testing.T) {
+	inner := NewMemoryStorage(0)
+	d := NewDedup(inner)
+	report := strings.Repeat("generated report\n", 100)
+	sum := sha256.Sum256([]byte(report))
+	contentKey := dedupDataPrefix + hex.EncodeToString(sum[:])
+
+	require.NoError(t, d.SetBlob("a", strings.NewReader(report), map[string]string{"user": "bot1"}, 0))
+	require.NoError(t, d.SetBlob("b", strings.NewReader(report), map[string]string{"user": "bot2"}, time.Hour))
+	sizeWithTwo := inner.Size()
+	require.NoError(t, d.SetBlob("c", strings.NewReader(report), nil, 0))
+	assert.Less(t, inner.Size()-sizeWithTwo, int64(len(report)), "content is stored once")
+
+	storetest.RequireBlob(t, d, "a", []byte(report), map[string]string{"user": "bot1"})
+	storetest.RequireBlob(t, d, "b", []byte(report), map[string]string{"user": "bot2"})
+
+	// reference is lightweight and keeps own metadata
+	ref, refMeta, err := inner.GetBlob("b")
+	require.NoError(t, err)
+	require.NotNil(t, ref)
+	assert.Equal(t, "bot2", refMeta["user"])
+	storetest.RequireBlob(t, inner, "b", []byte{}, refMeta)
+
+	require.NoError(t, d.DeleteBlob("a"))
+	_, _, err = d.TakeBlob("b")
+	require.NoError(t, err)
+	storetest.RequireBlob(t, inner, contentKey, []byte(report), nil)
+
+	// overwriting last reference with other data releases content
+	require.NoError(t, d.SetBlob("c", strings.NewReader("other"), nil, 0))
+	storetest.RequireMissing(t, inner, contentKey)
+	storetest.RequireMissing(t, inner, dedupRefsPrefix+hex.EncodeToString(sum[:]))
+	storetest.RequireBlob(t, d, "c", []byte("other"), nil)
+
+	// blobs stored before deduplication are readable
+	require.NoError(t, inner.SetBlob("legacy", strings.NewReader("old"), map[string]string{"k": "v"}, 0))
+	storetest.RequireBlob(t, d, "legacy", []byte("old"), map[string]string{"k": "v"})
+	require.NoError(t, d.DeleteBlob("legacy"))
+	storetest.RequireMissing(t, d, "legacy")
+}
+
+func TestDedupExpiration(t *testing.T) {
+	inner := NewMemoryStorage(0)
+	d := NewDedup(inner)
+	data := strings.Repeat("data", dedupMinSize)
+	sum := sha256.Sum256([]byte(data))
+	contentKey := dedupDataPrefix + hex.EncodeToString(sum[:])
+
+	require.NoError(t, d.SetBlob("short", strings.NewReader(data), nil, time.Minute))
+	require.NoError(t, d.SetBlob("long", strings.NewReader(data), nil, time.Hour))
+
+	// content outlives references expired without deletion
+	n, err := inner.DeleteExpired(time.Now().Add(time.Hour+time.Minute), 100)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n, "references are expired")
+	n, err = inner.DeleteExpired(time.Now().Add(time.Hour*3), 100)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n, "content and references list are expired")
+	storetest.RequireMissing(t, inner, contentKey)
+
+	// content of permanent blob doesn't expire
+	require.NoError(t, d.SetBlob("short", strings.NewReader(data), nil, time.Minute))
+	require.NoError(t, d.SetBlob("forever", strings.NewReader(data), nil, 0))
+	n, err = inner.DeleteExpired(time.Now().Add(time.Hour*24), 100)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	storetest.RequireBlob(t, d, "forever", []byte(data), nil)
+}
+
+func TestDedupDirect(t *testing.T) {
+	inner := NewMemoryStorage(0)
+	d := NewDedup(inner, "_index/")
+	report := strings.Repeat("generated report\n", 100)
+
+	require.NoError(t, d.SetBlob("empty", strings.NewReader(""), map[string]string{"k": "v"}, 0))
+	require.NoError(t, d.SetBlob("_index/user/a", strings.NewReader(report), nil, time.Hour))
+	for _, key := range []string{"empty", "_index/user/a"} {
+		_, meta, err := inner.GetBlob(key)
+		require.NoError(t, err)
+		assert.NotContains(t, meta, dedupHashMetaKey, "%s is stored directly", key)
+	}
+	storetest.RequireBlob(t, d, "empty", []byte{}, map[string]string{"k": "v"})
+	storetest.RequireBlob(t, d, "_index/user/a", []byte(report), nil)
+	keys, err := inner.ListKeys("_dedup/", "", 10)
+	require.NoError(t, err)
+	assert.Empty(t, keys, "no content and references are stored")
+
+	// overwriting reference with small data releases content
+	require.NoError(t, d.SetBlob("report", strings.NewReader(report), nil, 0))
+	require.NoError(t, d.SetBlob("report", strings.NewReader("small"), nil, 0))
+	keys, err = inner.ListKeys("_dedup/", "", 10)
+	require.NoError(t, err)
+	assert.Empty(t, keys)
+	storetest.RequireBlob(t, d, "report", []byte("small"), nil)
+}
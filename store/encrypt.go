@@ -0,0 +1,302 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// reserved metadata keys of encrypted blob with id of master key and data key encrypted with it
+const (
+	encryptionKeyIDMetaKey   = "envelope_key_id"
+	encryptionDataKeyMetaKey = "envelope_data_key"
+)
+
+const dataKeySize = 32
+
+// ErrNotEncrypted is returned on read of blob stored without encryption if it's not allowed
+var ErrNotEncrypted = errors.New("blob is not encrypted")
+
+// EncryptionKey is master key used to encrypt data keys of blobs
+type EncryptionKey struct {
+	ID  string
+	Key []byte // AES key of 16, 24 or 32 bytes
+}
+
+// ParseEncryptionKeys parses comma separated list of keys in format "<id>:<base64 key>"
+func ParseEncryptionKeys(s string) ([]EncryptionKey, error) {
+	var keys []EncryptionKey
+	for _, item := range strings.Split(s, ",") {
+		idAndKey := strings.SplitN(strings.TrimSpace(item), ":", 2)
+		if len(idAndKey) != 2 {
+			return nil, errors.New("encryption key should be in format '<id>:<base64 key>'")
+		}
+		key, err := base64.StdEncoding.DecodeString(idAndKey[1])
+		if err != nil {
+			return nil, errors.Wrapf(err, "error decode encryption key %q", idAndKey[0])
+		}
+		keys = append(keys, EncryptionKey{ID: idAndKey[0], Key: key})
+	}
+	return keys, nil
+}
+
+// Encrypted encrypts data of blobs with AES-GCM. Each blob is encrypted with its own data key,
+// data key is encrypted with master key and stored in metadata with id of master key.
+// Blobs are encrypted with the first master key and decrypted with key they were encrypted with,
+// so keys can be rotated by adding new key to the front, previous keys are kept while blobs encrypted with them exist.
+// Metadata is not encrypted. Blobs stored without encryption are not read unless it's allowed
+// to migrate existing store, otherwise data written to underlying store by someone else would be trusted.
+// Encrypted data doesn't compress, so Compress should wrap Encrypted and not vice versa
+type Encrypted struct {
+	store Store
+
+	currentKey     string
+	masterKeys     map[string]cipher.AEAD
+	allowPlaintext bool
+}
+
+// NewEncrypted creates Encrypted storing data in store, the first of keys is used to encrypt new blobs.
+// If allowPlaintext is set, blobs stored without encryption are returned as is
+func NewEncrypted(store Store, keys []EncryptionKey, allowPlaintext bool) (*Encrypted, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("no encryption keys")
+	}
+	e := &Encrypted{store: store, currentKey: keys[0].ID, masterKeys: map[string]cipher.AEAD{}, allowPlaintext: allowPlaintext}
+	for _, key := range keys {
+		if key.ID == "" {
+			return nil, errors.New("empty encryption key id")
+		}
+		if _, ok := e.masterKeys[key.ID]; ok {
+			return nil, errors.Errorf("duplicate encryption key id %q", key.ID)
+		}
+		aead, err := newAEAD(key.Key)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid encryption key %q", key.ID)
+		}
+		e.masterKeys[key.ID] = aead
+	}
+	return e, nil
+}
+
+// SetBlob saves data encrypted with new data key
+func (e *Encrypted) SetBlob(key string, reader io.Reader, meta map[string]string, ttl time.Duration) error {
+	return e.setBlob(e.store, key, reader, meta, ttl)
+}
+
+// SetBlobContext saves encrypted data with underlying store bound to ctx
+func (e *Encrypted) SetBlobContext(ctx context.Context, key string, reader io.Reader, meta map[string]string, ttl time.Duration) error {
+	return e.setBlob(WithContext(ctx, e.store), key, reader, meta, ttl)
+}
+
+func (e *Encrypted) setBlob(s Store, key string, reader io.Reader, meta map[string]string, ttl time.Duration) error {
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return errors.Wrap(err, "can't read data from reader")
+	}
+	dataKey := make([]byte, dataKeySize)
+	if _, err = rand.Read(dataKey); err != nil {
+		return errors.Wrap(err, "can't generate data key")
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return err
+	}
+	// key of blob is authenticated, so encrypted data can't be swapped between keys
+	ciphertext, err := gcmSeal(aead, data, key)
+	if err != nil {
+		return err
+	}
+	wrappedKey, err := gcmSeal(e.masterKeys[e.currentKey], dataKey, key)
+	if err != nil {
+		return err
+	}
+	storedMeta := withoutMetaKeys(meta, encryptionKeyIDMetaKey, encryptionDataKeyMetaKey)
+	storedMeta[encryptionKeyIDMetaKey] = e.currentKey
+	storedMeta[encryptionDataKeyMetaKey] = base64.StdEncoding.EncodeToString(wrappedKey)
+	return s.SetBlob(key, bytes.NewReader(ciphertext), storedMeta, ttl)
+}
+
+// GetBlob returns decrypted data
+func (e *Encrypted) GetBlob(key string) (io.Reader, map[string]string, error) {
+	data, meta, err := e.store.GetBlob(key)
+	return e.decrypt(key, data, meta, err)
+}
+
+// GetBlobContext returns decrypted data with underlying store bound to ctx
+func (e *Encrypted) GetBlobContext(ctx context.Context, key string) (io.Reader, map[string]string, error) {
+	data, meta, err := WithContext(ctx, e.store).GetBlob(key)
+	return e.decrypt(key, data, meta, err)
+}
+
+// TakeBlob returns decrypted data and removes it
+func (e *Encrypted) TakeBlob(key string) (io.Reader, map[string]string, error) {
+	return e.takeBlob(e.store, key)
+}
+
+// TakeBlobContext returns decrypted data and removes it with underlying store bound to ctx
+func (e *Encrypted) TakeBlobContext(ctx context.Context, key string) (io.Reader, map[string]string, error) {
+	return e.takeBlob(WithContext(ctx, e.store), key)
+}
+
+func (e *Encrypted) takeBlob(s Store, key string) (io.Reader, map[string]string, error) {
+	if !e.allowPlaintext {
+		// blob that can't be read is not removed
+		meta, err := blobMeta(s, key)
+		if err != nil {
+			return nil, nil, err
+		}
+		if _, ok := meta[encryptionKeyIDMetaKey]; meta != nil && !ok {
+			return nil, nil, errors.Wrapf(ErrNotEncrypted, "can't read %q", key)
+		}
+	}
+	data, meta, err := s.TakeBlob(key)
+	return e.decrypt(key, data, meta, err)
+}
+
+// DeleteBlob removes data
+func (e *Encrypted) DeleteBlob(key string) error {
+	return e.store.DeleteBlob(key)
+}
+
+// DeleteBlobContext removes data with underlying store bound to ctx
+func (e *Encrypted) DeleteBlobContext(ctx context.Context, key string) error {
+	return WithContext(ctx, e.store).DeleteBlob(key)
+}
+
+// DeleteExpired removes expired blobs if underlying store has to do it explicitly
+func (e *Encrypted) DeleteExpired(now time.Time, limit int) (int, error) {
+	sweeper, ok := e.store.(interface {
+		DeleteExpired(now time.Time, limit int) (int, error)
+	})
+	if !ok {
+		return 0, nil
+	}
+	return sweeper.DeleteExpired(now, limit)
+}
+
+// GetMeta returns metadata without reading data
+func (e *Encrypted) GetMeta(key string) (map[string]string, error) {
+	meta, err := blobMeta(e.store, key)
+	return withoutEncryptionMeta(meta), err
+}
+
+// GetMetaContext returns metadata with request to underlying store bound to ctx
+func (e *Encrypted) GetMetaContext(ctx context.Context, key string) (map[string]string, error) {
+	meta, err := blobMeta(WithContext(ctx, e.store), key)
+	return withoutEncryptionMeta(meta), err
+}
+
+// GetExpireTime returns expiration time of blob in underlying store
+func (e *Encrypted) GetExpireTime(key string) (time.Time, error) {
+	return blobExpireTime(e.store, key)
+}
+
+// ListKeys returns keys of underlying store
+func (e *Encrypted) ListKeys(prefix string, after string, limit int) ([]string, error) {
+	lister, ok := e.store.(interface {
+		ListKeys(prefix string, after string, limit int) ([]string, error)
+	})
+	if !ok {
+		return nil, errors.New("underlying store can't list keys")
+	}
+	return lister.ListKeys(prefix, after, limit)
+}
+
+// QueryBlobs returns blobs of underlying store matching query without reserved metadata keys.
+// Returns ErrQueryNotSupported if underlying store can't query blobs
+func (e *Encrypted) QueryBlobs(q Query) ([]BlobInfo, error) {
+	blobs, err := queryBlobs(e.store, q)
+	for i := range blobs {
+		blobs[i].Meta = withoutEncryptionMeta(blobs[i].Meta)
+	}
+	return blobs, err
+}
+
+// Close closes underlying store if it has to be closed
+func (e *Encrypted) Close() error {
+	if closer, ok := e.store.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// decrypt returns reader of decrypted data and metadata without reserved keys
+func (e *Encrypted) decrypt(key string, data io.Reader, meta map[string]string, err error) (io.Reader, map[string]string, error) {
+	if err != nil || data == nil {
+		return nil, nil, err
+	}
+	keyID, ok := meta[encryptionKeyIDMetaKey]
+	if !ok {
+		if !e.allowPlaintext {
+			return nil, nil, errors.Wrapf(ErrNotEncrypted, "can't read %q", key)
+		}
+		return data, meta, nil
+	}
+	masterKey, ok := e.masterKeys[keyID]
+	if !ok {
+		return nil, nil, errors.Errorf("unknown encryption key %q", keyID)
+	}
+	wrappedKey, err := base64.StdEncoding.DecodeString(meta[encryptionDataKeyMetaKey])
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "error decode data key")
+	}
+	dataKey, err := gcmOpen(masterKey, wrappedKey, key)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "can't decrypt data key")
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	ciphertext, err := ioutil.ReadAll(data)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "can't read encrypted data")
+	}
+	plaintext, err := gcmOpen(aead, ciphertext, key)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "can't decrypt data")
+	}
+	return bytes.NewReader(plaintext), withoutEncryptionMeta(meta), nil
+}
+
+// withoutEncryptionMeta returns copy of metadata without reserved keys, nil for nil meta
+func withoutEncryptionMeta(meta map[string]string) map[string]string {
+	if meta == nil {
+		return nil
+	}
+	return withoutMetaKeys(meta, encryptionKeyIDMetaKey, encryptionDataKeyMetaKey)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// gcmSeal encrypts data with random nonce, nonce is prepended to result
+func gcmSeal(aead cipher.AEAD, data []byte, key string) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(data)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, errors.Wrap(err, "can't generate nonce")
+	}
+	return aead.Seal(nonce, nonce, data, []byte(key)), nil
+}
+
+// gcmOpen decrypts data encrypted by gcmSeal
+func gcmOpen(aead cipher.AEAD, data []byte, key string) ([]byte, error) {
+	if len(data) < aead.NonceSize() {
+		return nil, errors.New("encrypted data is too short")
+	}
+	return aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], []byte(key))
+}
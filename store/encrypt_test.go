@@ -0,0 +1,127 @@
+package store
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vdimir/markify/store/storetest"
+)
+
+func testEncryptionKey(id string) EncryptionKey {
+	return EncryptionKey{ID: id, Key: bytes.Repeat([]byte(id[:1]), 32)}
+}
+
+func createTestEncrypted(t *testing.T, store Store, keys ...EncryptionKey) *Encrypted {
+	e, err := NewEncrypted(store, keys, false)
+	require.NoError(t, err)
+	return e
+}
+
+func TestEncryptedConformance(t *testing.T) {
+	t.Run("memory", func(t *testing.T) {
+		storetest.Run(t, func(t *testing.T) storetest.Store {
+			return createTestEncrypted(t, NewMemoryStorage(0), testEncryptionKey("k1"))
+		})
+	})
+	t.Run("s3", func(t *testing.T) {
+		storetest.Run(t, func(t *testing.T) storetest.Store {
+			return createTestEncrypted(t, createTestS3(t), testEncryptionKey("k1"))
+		})
+	})
+}
+
+func TestEncryptedContent(t *testing.T) {
+	inner := NewMemoryStorage(0)
+	e := createTestEncrypted(t, inner, testEncryptionKey("k1"))
+	meta := map[string]string{"syntax": "text"}
+
+	require.NoError(t, e.SetBlob("secret", strings.NewReader("top secret text"), meta, 0))
+	storetest.RequireBlob(t, e, "secret", []byte("top secret text"), meta)
+	data, innerMeta, err := inner.GetBlob("secret")
+	require.NoError(t, err)
+	stored := new(bytes.Buffer)
+	_, err = stored.ReadFrom(data)
+	require.NoError(t, err)
+	assert.NotContains(t, stored.String(), "secret", "data is encrypted")
+	assert.Equal(t, "k1", innerMeta[encryptionKeyIDMetaKey])
+	assert.Equal(t, "text", innerMeta["syntax"], "metadata is not encrypted")
+
+	// each blob has own data key
+	require.NoError(t, e.SetBlob("other", strings.NewReader("top secret text"), meta, 0))
+	_, otherMeta, err := inner.GetBlob("other")
+	require.NoError(t, err)
+	assert.NotEqual(t, innerMeta[encryptionDataKeyMetaKey], otherMeta[encryptionDataKeyMetaKey])
+
+	// encrypted data is bound to its key
+	require.NoError(t, inner.SetBlob("moved", bytes.NewReader(stored.Bytes()), innerMeta, 0))
+	_, _, err = e.GetBlob("moved")
+	assert.Error(t, err)
+
+	// blobs stored without encryption are readable only if it's allowed
+	require.NoError(t, inner.SetBlob("legacy", strings.NewReader("old"), meta, 0))
+	_, _, err = e.GetBlob("legacy")
+	assert.True(t, errors.Is(err, ErrNotEncrypted), err)
+	_, _, err = e.TakeBlob("legacy")
+	assert.True(t, errors.Is(err, ErrNotEncrypted), err)
+	storetest.RequireBlob(t, inner, "legacy", []byte("old"), meta)
+	migrating, err := NewEncrypted(inner, []EncryptionKey{testEncryptionKey("k1")}, true)
+	require.NoError(t, err)
+	storetest.RequireBlob(t, migrating, "legacy", []byte("old"), meta)
+	storetest.RequireBlob(t, migrating, "secret", []byte("top secret text"), meta)
+}
+
+func TestEncryptedKeyRotation(t *testing.T) {
+	inner := NewMemoryStorage(0)
+	require.NoError(t, createTestEncrypted(t, inner, testEncryptionKey("k1")).SetBlob("old", strings.NewReader("old data"), nil, 0))
+
+	e := createTestEncrypted(t, inner, testEncryptionKey("k2"), testEncryptionKey("k1"))
+	require.NoError(t, e.SetBlob("new", strings.NewReader("new data"), nil, 0))
+	storetest.RequireBlob(t, e, "old", []byte("old data"), map[string]string{})
+	storetest.RequireBlob(t, e, "new", []byte("new data"), map[string]string{})
+	_, newMeta, err := inner.GetBlob("new")
+	require.NoError(t, err)
+	assert.Equal(t, "k2", newMeta[encryptionKeyIDMetaKey])
+
+	// blob encrypted with removed key can't be read
+	e = createTestEncrypted(t, inner, testEncryptionKey("k2"))
+	_, _, err = e.GetBlob("old")
+	assert.Error(t, err)
+	storetest.RequireBlob(t, e, "new", []byte("new data"), map[string]string{})
+
+	// wrong key with the same id
+	e = createTestEncrypted(t, inner, EncryptionKey{ID: "k2", Key: bytes.Repeat([]byte("x"), 32)})
+	_, _, err = e.GetBlob("new")
+	assert.Error(t, err)
+}
+
+func TestParseEncryptionKeys(t *testing.T) {
+	key1 := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte("a"), 32))
+	key2 := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte("b"), 16))
+	keys, err := ParseEncryptionKeys("2021-03:" + key1 + ", 2020-01:" + key2)
+	require.NoError(t, err)
+	require.Len(t, keys, 2)
+	assert.Equal(t, "2021-03", keys[0].ID)
+	assert.Equal(t, bytes.Repeat([]byte("a"), 32), keys[0].Key)
+	assert.Equal(t, "2020-01", keys[1].ID)
+
+	for _, s := range []string{"", key1, "k1:not base64"} {
+		_, err = ParseEncryptionKeys(s)
+		assert.Error(t, err, s)
+	}
+
+	inner := NewMemoryStorage(0)
+	for _, keys := range [][]EncryptionKey{
+		nil,
+		{{ID: "", Key: bytes.Repeat([]byte("a"), 32)}},
+		{{ID: "k1", Key: []byte("short")}},
+		{testEncryptionKey("k1"), testEncryptionKey("k1")},
+	} {
+		_, err = NewEncrypted(inner, keys, false)
+		assert.Error(t, err)
+	}
+}
@@ -0,0 +1,21 @@
+package store
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrExpiryNotSupported is returned by decorators if underlying store can't report expiration time of blobs
+var ErrExpiryNotSupported = errors.New("underlying store can't report expiration time")
+
+// blobExpireTime returns expiration time of blob from underlying store of decorator
+func blobExpireTime(s Store, key string) (time.Time, error) {
+	reader, ok := s.(interface {
+		GetExpireTime(key string) (time.Time, error)
+	})
+	if !ok {
+		return time.Time{}, ErrExpiryNotSupported
+	}
+	return reader.GetExpireTime(key)
+}
@@ -0,0 +1,414 @@
+package store
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	fsMetaExt    = ".json"
+	fsBlobExt    = ".blob"
+	fsClaimExt   = ".claim"
+	fsTempPrefix = ".tmp-"
+)
+
+// fsOrphanAge is age of data files not referenced by metadata, temporary files and claims
+// after which they are considered left by crashed writer and removed by DeleteExpired
+const fsOrphanAge = time.Hour
+
+// fsReadAttempts is number of attempts to read blob replaced concurrently
+const fsReadAttempts = 3
+
+// FS stores each blob as data file and sidecar json file with metadata in directory tree sharded by hash of key.
+// Metadata file refers to data file with unique name, so blob is replaced by atomic rename of metadata file.
+// Blob is removed by renaming metadata file to unique claim file, only one of concurrent callers succeeds.
+// Only renames are used for synchronization, so directory can be shared by processes on the same volume
+type FS struct {
+	root string
+}
+
+// fsMeta is content of metadata file
+type fsMeta struct {
+	Key        string            `json:"key"`
+	Meta       map[string]string `json:"meta,omitempty"`
+	ExpireTime *time.Time        `json:"expire_time,omitempty"`
+	Data       string            `json:"data"` // name of data file in the same directory
+}
+
+func (s *fsMeta) expired(now time.Time) bool {
+	return s.ExpireTime != nil && !s.ExpireTime.After(now)
+}
+
+// NewFSStorage create FS Store in root directory
+func NewFSStorage(root string) (*FS, error) {
+	if err := os.MkdirAll(root, os.ModePerm); err != nil {
+		return nil, errors.Wrapf(err, "can't create storage directory %q", root)
+	}
+	return &FS{root: root}, nil
+}
+
+// SetBlob save data in storage. Blob with positive ttl expires after ttl elapsed
+func (f *FS) SetBlob(key string, reader io.Reader, meta map[string]string, ttl time.Duration) error {
+	base := f.basePath(key)
+	dir := filepath.Dir(base)
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return errors.Wrap(err, "can't create shard directory")
+	}
+	// data file isn't visible until metadata file referring it is written
+	dataFile, err := writeNewFile(dir, filepath.Base(base)+".*"+fsBlobExt, reader)
+	if err != nil {
+		return errors.Wrap(err, "can't write data")
+	}
+	header := fsMeta{Key: key, Meta: meta, Data: filepath.Base(dataFile)}
+	if ttl > 0 {
+		expireTime := time.Now().Add(ttl).UTC()
+		header.ExpireTime = &expireTime
+	}
+	headerData, err := json.Marshal(header)
+	if err != nil {
+		os.Remove(dataFile)
+		return err
+	}
+
+	// data of replaced blob is removed after metadata is replaced, readers retry reading if it's missing
+	prev, _ := readMetaFile(base + fsMetaExt)
+	tmpName, err := writeNewFile(dir, fsTempPrefix, bytes.NewReader(headerData))
+	if err == nil {
+		err = os.Rename(tmpName, base+fsMetaExt)
+		if err != nil {
+			os.Remove(tmpName)
+		}
+	}
+	if err != nil {
+		os.Remove(dataFile)
+		return errors.Wrap(err, "can't save metadata")
+	}
+	if prev != nil && prev.Data != header.Data {
+		if err = removeFile(dataPath(dir, prev)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetBlob returns data and metadata stored by key. Returns nil reader if key not found or expired
+func (f *FS) GetBlob(key string) (io.Reader, map[string]string, error) {
+	header, data, err := f.readBlob(key)
+	if err != nil || header == nil || header.expired(time.Now()) {
+		return nil, nil, err
+	}
+	return bytes.NewReader(data), header.Meta, nil
+}
+
+// GetMeta returns metadata stored by key reading only metadata file. Returns nil if key not found or expired
+func (f *FS) GetMeta(key string) (map[string]string, error) {
+	header, err := readMetaFile(f.basePath(key) + fsMetaExt)
+	if err != nil || header == nil || header.expired(time.Now()) {
+		return nil, err
+	}
+	if header.Meta == nil {
+		return map[string]string{}, nil
+	}
+	return header.Meta, nil
+}
+
+// TakeBlob returns data and metadata and removes them.
+// Blob is claimed by rename of its metadata file, so concurrent calls of all processes can't both get the data
+func (f *FS) TakeBlob(key string) (io.Reader, map[string]string, error) {
+	for i := 0; i < fsReadAttempts; i++ {
+		claimName, err := f.claim(key)
+		if err != nil || claimName == "" {
+			return nil, nil, err
+		}
+		header, err := readMetaFile(claimName)
+		if err != nil {
+			return nil, nil, err
+		}
+		data, err := ioutil.ReadFile(dataPath(filepath.Dir(claimName), header))
+		if os.IsNotExist(err) {
+			// blob was replaced after it was claimed, data is removed by writer
+			if err = removeFile(claimName); err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "can't read data")
+		}
+		if err = removeClaimed(claimName, header); err != nil {
+			return nil, nil, err
+		}
+		if header.expired(time.Now()) {
+			return nil, nil, nil
+		}
+		return bytes.NewReader(data), header.Meta, nil
+	}
+	return nil, nil, errors.Errorf("data of blob %q is missing", key)
+}
+
+// DeleteBlob removes data and metadata
+func (f *FS) DeleteBlob(key string) error {
+	claimName, err := f.claim(key)
+	if err != nil || claimName == "" {
+		return err
+	}
+	header, err := readMetaFile(claimName)
+	if err != nil {
+		return err
+	}
+	return removeClaimed(claimName, header)
+}
+
+// DeleteExpired removes up to limit blobs expired at the moment now. Returns number of removed blobs.
+// Files left by crashed writers are removed as well
+func (f *FS) DeleteExpired(now time.Time, limit int) (int, error) {
+	deleted := 0
+	orphanTime := time.Now().Add(-fsOrphanAge)
+	errStop := errors.New("limit reached")
+	err := f.walk(func(path string, info os.FileInfo) error {
+		if deleted >= limit {
+			return errStop
+		}
+		name := info.Name()
+		switch {
+		case strings.HasSuffix(name, fsMetaExt):
+			header, err := readMetaFile(path)
+			if err != nil || header == nil || !header.expired(now) {
+				return err
+			}
+			removed, err := f.deleteExpired(header.Key, now)
+			if removed {
+				deleted++
+			}
+			return err
+		case info.ModTime().After(orphanTime):
+			return nil
+		case strings.HasSuffix(name, fsBlobExt):
+			return removeOrphanData(path)
+		case strings.HasSuffix(name, fsClaimExt):
+			// claim could be left empty if process crashed before metadata file was renamed
+			if header, err := readMetaFile(path); err == nil && header != nil {
+				return removeClaimed(path, header)
+			}
+			return removeFile(path)
+		case strings.HasPrefix(name, fsTempPrefix):
+			return removeFile(path)
+		}
+		return nil
+	})
+	if err == errStop {
+		err = nil
+	}
+	return deleted, err
+}
+
+// GetExpireTime returns expiration time of blob, zero time if blob doesn't expire, not found or expired
+func (f *FS) GetExpireTime(key string) (time.Time, error) {
+	header, err := readMetaFile(f.basePath(key) + fsMetaExt)
+	if err != nil || header == nil || header.ExpireTime == nil || header.expired(time.Now()) {
+		return time.Time{}, err
+	}
+	return *header.ExpireTime, nil
+}
+
+// ListKeys returns up to limit keys with prefix that follow key after in lexicographic order.
+// Files are named by hash of key, so metadata files of all blobs are read on each call
+func (f *FS) ListKeys(prefix string, after string, limit int) ([]string, error) {
+	now := time.Now()
+	var keys []string
+	err := f.walk(func(path string, info os.FileInfo) error {
+		if !strings.HasSuffix(info.Name(), fsMetaExt) {
+			return nil
+		}
+		header, err := readMetaFile(path)
+		if err != nil || header == nil || header.expired(now) {
+			return err
+		}
+		if strings.HasPrefix(header.Key, prefix) && header.Key > after {
+			keys = append(keys, header.Key)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "can't list blobs")
+	}
+	sort.Strings(keys)
+	if len(keys) > limit {
+		keys = keys[:limit]
+	}
+	return keys, nil
+}
+
+// deleteExpired removes blob if it's still expired, it could be overwritten after expiration was checked
+func (f *FS) deleteExpired(key string, now time.Time) (bool, error) {
+	claimName, err := f.claim(key)
+	if err != nil || claimName == "" {
+		return false, err
+	}
+	header, err := readMetaFile(claimName)
+	if err != nil {
+		return false, err
+	}
+	if !header.expired(now) {
+		// link doesn't replace blob written after it was claimed
+		if err = os.Link(claimName, f.basePath(key)+fsMetaExt); err != nil && !os.IsExist(err) {
+			return false, errors.Wrap(err, "can't restore claimed blob")
+		}
+		if os.IsExist(err) {
+			return false, removeClaimed(claimName, header)
+		}
+		return false, removeFile(claimName)
+	}
+	return true, removeClaimed(claimName, header)
+}
+
+// claim renames metadata file of key to new claim file, so blob is not visible and can't be claimed again.
+// Returns name of claim file or empty string if blob not found
+func (f *FS) claim(key string) (string, error) {
+	base := f.basePath(key)
+	claimFile, err := ioutil.TempFile(filepath.Dir(base), filepath.Base(base)+".*"+fsClaimExt)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", errors.Wrap(err, "can't create claim")
+	}
+	claimFile.Close()
+	if err = os.Rename(base+fsMetaExt, claimFile.Name()); err != nil {
+		os.Remove(claimFile.Name())
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", errors.Wrap(err, "can't claim blob")
+	}
+	// renamed file keeps time of metadata, claims are considered stale by modification time
+	now := time.Now()
+	if err = os.Chtimes(claimFile.Name(), now, now); err != nil {
+		return "", errors.Wrap(err, "can't claim blob")
+	}
+	return claimFile.Name(), nil
+}
+
+// readBlob returns metadata and data of blob, nil if blob is missing.
+// Data file of replaced blob is removed, so metadata is read again if data file is missing
+func (f *FS) readBlob(key string) (*fsMeta, []byte, error) {
+	base := f.basePath(key)
+	for i := 0; i < fsReadAttempts; i++ {
+		header, err := readMetaFile(base + fsMetaExt)
+		if err != nil || header == nil {
+			return nil, nil, err
+		}
+		data, err := ioutil.ReadFile(dataPath(filepath.Dir(base), header))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "can't read data")
+		}
+		return header, data, nil
+	}
+	return nil, nil, errors.Errorf("data of blob %q is missing", key)
+}
+
+// walk calls fn for each file in storage, files removed concurrently are skipped
+func (f *FS) walk(fn func(path string, info os.FileInfo) error) error {
+	return filepath.Walk(f.root, func(path string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		return fn(path, info)
+	})
+}
+
+// basePath returns path of blob files without extension: root/ab/cd/abcd...
+func (f *FS) basePath(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	name := hex.EncodeToString(sum[:])
+	return filepath.Join(f.root, name[:2], name[2:4], name)
+}
+
+// dataPath returns path of data file referred by metadata file in dir
+func dataPath(dir string, header *fsMeta) string {
+	return filepath.Join(dir, filepath.Base(header.Data))
+}
+
+// removeClaimed removes data file of claimed blob and then claim file
+func removeClaimed(claimName string, header *fsMeta) error {
+	if header.Data != "" {
+		if err := removeFile(dataPath(filepath.Dir(claimName), header)); err != nil {
+			return err
+		}
+	}
+	return removeFile(claimName)
+}
+
+// removeOrphanData removes data file if metadata file of blob doesn't refer it
+func removeOrphanData(path string) error {
+	name := filepath.Base(path)
+	base := filepath.Join(filepath.Dir(path), name[:strings.IndexByte(name, '.')])
+	header, err := readMetaFile(base + fsMetaExt)
+	if err != nil || (header != nil && header.Data == name) {
+		return err
+	}
+	return removeFile(path)
+}
+
+func removeFile(path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "can't remove blob file")
+	}
+	return nil
+}
+
+// readMetaFile returns content of metadata file, nil if file is missing
+func readMetaFile(fileName string) (*fsMeta, error) {
+	data, err := ioutil.ReadFile(fileName)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "can't read metadata")
+	}
+	header := &fsMeta{}
+	if err = json.Unmarshal(data, header); err != nil {
+		return nil, errors.Wrapf(err, "broken metadata file %q", fileName)
+	}
+	return header, nil
+}
+
+// writeNewFile writes data to new file in dir with name by pattern of ioutil.TempFile and returns its name
+func writeNewFile(dir string, pattern string, reader io.Reader) (string, error) {
+	file, err := ioutil.TempFile(dir, pattern)
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(file, reader)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+	return file.Name(), nil
+}
@@ -0,0 +1,184 @@
+package store
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vdimir/markify/store/storetest"
+	"github.com/vdimir/markify/testutil"
+)
+
+func createTestFS(t *testing.T) *FS {
+	tmpPath, tmpFolderClean := testutil.GetTempFolder(t, "test_fs")
+	t.Cleanup(tmpFolderClean)
+	f, err := NewFSStorage(filepath.Join(tmpPath, "blobs"))
+	require.NoError(t, err)
+	return f
+}
+
+func TestFSConformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) storetest.Store {
+		return createTestFS(t)
+	})
+}
+
+func TestFSLayout(t *testing.T) {
+	f := createTestFS(t)
+	require.NoError(t, f.SetBlob("doc/rev/1", strings.NewReader("some text"), map[string]string{"syntax": "go"}, 0))
+
+	base := f.basePath("doc/rev/1")
+	rel, err := filepath.Rel(f.root, base)
+	require.NoError(t, err)
+	parts := strings.Split(rel, string(filepath.Separator))
+	require.Len(t, parts, 3)
+	assert.Equal(t, parts[2][:2], parts[0])
+	assert.Equal(t, parts[2][2:4], parts[1])
+
+	// metadata file refers data file, no temporary files left
+	files, err := ioutil.ReadDir(filepath.Dir(base))
+	require.NoError(t, err)
+	require.Len(t, files, 2)
+	metaContent, err := ioutil.ReadFile(base + fsMetaExt)
+	require.NoError(t, err)
+	header := &fsMeta{}
+	require.NoError(t, json.Unmarshal(metaContent, header))
+	assert.Equal(t, "doc/rev/1", header.Key)
+	assert.Equal(t, map[string]string{"syntax": "go"}, header.Meta)
+	assert.True(t, strings.HasPrefix(header.Data, parts[2]+"."))
+	assert.True(t, strings.HasSuffix(header.Data, fsBlobExt))
+	data, err := ioutil.ReadFile(filepath.Join(filepath.Dir(base), header.Data))
+	require.NoError(t, err)
+	assert.Equal(t, "some text", string(data))
+
+	// data of replaced blob is removed
+	require.NoError(t, f.SetBlob("doc/rev/1", strings.NewReader("new text"), nil, 0))
+	storetest.RequireBlob(t, f, "doc/rev/1", []byte("new text"), nil)
+	files, err = ioutil.ReadDir(filepath.Dir(base))
+	require.NoError(t, err)
+	assert.Len(t, files, 2)
+
+	// data with newlines and empty data
+	require.NoError(t, f.SetBlob("doc", strings.NewReader("line 1\nline 2\n"), nil, 0))
+	storetest.RequireBlob(t, f, "doc", []byte("line 1\nline 2\n"), nil)
+	require.NoError(t, f.SetBlob("empty", strings.NewReader(""), map[string]string{"k": "v"}, 0))
+	storetest.RequireBlob(t, f, "empty", []byte{}, map[string]string{"k": "v"})
+
+	// truncated metadata file is reported
+	require.NoError(t, ioutil.WriteFile(base+fsMetaExt, []byte(`{"key": "doc/rev/1"`), 0o600))
+	_, _, err = f.GetBlob("doc/rev/1")
+	assert.Error(t, err)
+	_, err = f.GetMeta("doc/rev/1")
+	assert.Error(t, err)
+
+	require.NoError(t, os.Remove(base+fsMetaExt))
+	storetest.RequireMissing(t, f, "doc/rev/1")
+}
+
+func TestFSShared(t *testing.T) {
+	f := createTestFS(t)
+	other, err := NewFSStorage(f.root)
+	require.NoError(t, err)
+
+	keys, err := f.ListKeys("doc/", "", 10)
+	require.NoError(t, err)
+	assert.Empty(t, keys)
+	require.NoError(t, other.SetBlob("doc/1", strings.NewReader("foo"), nil, 0))
+	require.NoError(t, other.SetBlob("doc/2", strings.NewReader("bar"), nil, 0))
+	keys, err = f.ListKeys("doc/", "", 10)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"doc/1", "doc/2"}, keys, "keys written by other instance are listed")
+	require.NoError(t, other.DeleteBlob("doc/1"))
+	keys, err = f.ListKeys("doc/", "", 10)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"doc/2"}, keys)
+
+	// only one of instances takes blob
+	for i := 0; i < 20; i++ {
+		require.NoError(t, f.SetBlob("burn", strings.NewReader("secret"), nil, 0))
+		var taken int32
+		var wg sync.WaitGroup
+		for _, s := range []*FS{f, other, f, other} {
+			wg.Add(1)
+			go func(s *FS) {
+				defer wg.Done()
+				data, _, err := s.TakeBlob("burn")
+				assert.NoError(t, err)
+				if data != nil {
+					atomic.AddInt32(&taken, 1)
+				}
+			}(s)
+		}
+		wg.Wait()
+		require.Equal(t, int32(1), taken)
+		storetest.RequireMissing(t, f, "burn")
+	}
+}
+
+func TestFSCrashedWriter(t *testing.T) {
+	f := createTestFS(t)
+	require.NoError(t, f.SetBlob("doc", strings.NewReader("foo"), nil, 0))
+	require.NoError(t, f.SetBlob("taken", strings.NewReader("bar"), nil, 0))
+	base := f.basePath("doc")
+	dir := filepath.Dir(base)
+
+	// data written without metadata, claim of crashed TakeBlob and temporary file
+	orphan := filepath.Join(dir, filepath.Base(base)+".1"+fsBlobExt)
+	require.NoError(t, ioutil.WriteFile(orphan, []byte("baz"), 0o600))
+	claimName, err := f.claim("taken")
+	require.NoError(t, err)
+	require.NotEmpty(t, claimName)
+	storetest.RequireMissing(t, f, "taken")
+	tmp := filepath.Join(dir, fsTempPrefix+"1")
+	require.NoError(t, ioutil.WriteFile(tmp, []byte("{}"), 0o600))
+
+	n, err := f.DeleteExpired(time.Now(), 100)
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+	for _, name := range []string{orphan, claimName, tmp} {
+		_, err = os.Stat(name)
+		assert.NoError(t, err, "recent files are kept")
+		old := time.Now().Add(-fsOrphanAge - time.Minute)
+		require.NoError(t, os.Chtimes(name, old, old))
+	}
+
+	n, err = f.DeleteExpired(time.Now(), 100)
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+	for _, name := range []string{orphan, claimName, tmp} {
+		_, err = os.Stat(name)
+		assert.True(t, os.IsNotExist(err), "stale file %q is removed", name)
+	}
+	storetest.RequireBlob(t, f, "doc", []byte("foo"), nil)
+	files, err := ioutil.ReadDir(filepath.Dir(f.basePath("taken")))
+	require.NoError(t, err)
+	assert.Empty(t, files, "data of claimed blob is removed")
+}
+
+func TestFSDeleteExpired(t *testing.T) {
+	f := createTestFS(t)
+	require.NoError(t, f.SetBlob("short", strings.NewReader("foo"), nil, time.Minute))
+	require.NoError(t, f.SetBlob("long", strings.NewReader("bar"), nil, time.Hour))
+	require.NoError(t, f.SetBlob("forever", strings.NewReader("baz"), nil, 0))
+
+	n, err := f.DeleteExpired(time.Now().Add(time.Hour*2), 1)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	n, err = f.DeleteExpired(time.Now().Add(time.Hour*2), 100)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	storetest.RequireBlob(t, f, "forever", []byte("baz"), nil)
+	for _, key := range []string{"short", "long"} {
+		_, err = os.Stat(f.basePath(key) + fsMetaExt)
+		assert.True(t, os.IsNotExist(err))
+	}
+}
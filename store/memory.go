@@ -0,0 +1,213 @@
+package store
+
+import (
+	"bytes"
+	"container/list"
+	"io"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Memory stores data in process memory, content is lost on restart.
+// If maxBytes is positive, least recently used blobs are evicted to keep total size of blobs under limit
+type Memory struct {
+	mu       sync.Mutex
+	blobs    map[string]*list.Element
+	lru      *list.List // front is most recently used
+	size     int64
+	maxBytes int64
+}
+
+type memoryBlob struct {
+	key      string
+	data     []byte
+	meta     map[string]string
+	expireAt time.Time
+}
+
+// size of blob counted against limit
+func (b *memoryBlob) size() int64 {
+	n := len(b.key) + len(b.data)
+	for k, v := range b.meta {
+		n += len(k) + len(v)
+	}
+	return int64(n)
+}
+
+func (b *memoryBlob) expired(now time.Time) bool {
+	return !b.expireAt.IsZero() && !b.expireAt.After(now)
+}
+
+// NewMemoryStorage create Memory Store, zero maxBytes means no limit
+func NewMemoryStorage(maxBytes int64) *Memory {
+	return &Memory{
+		blobs:    map[string]*list.Element{},
+		lru:      list.New(),
+		maxBytes: maxBytes,
+	}
+}
+
+// SetBlob save data in storage. Blob with positive ttl expires after ttl elapsed
+func (m *Memory) SetBlob(key string, reader io.Reader, meta map[string]string, ttl time.Duration) error {
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return errors.Wrap(err, "can't read data from reader")
+	}
+	blob := &memoryBlob{key: key, data: data, meta: copyMeta(meta)}
+	if ttl > 0 {
+		blob.expireAt = time.Now().Add(ttl)
+	}
+	if m.maxBytes > 0 && blob.size() > m.maxBytes {
+		return errors.Errorf("blob of size %d exceeds memory storage limit %d", blob.size(), m.maxBytes)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.remove(key)
+	m.blobs[key] = m.lru.PushFront(blob)
+	m.size += blob.size()
+	m.evict()
+	return nil
+}
+
+// GetBlob returns data and metadata stored by key. Returns nil reader if key not found or expired
+func (m *Memory) GetBlob(key string) (io.Reader, map[string]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	blob := m.get(key)
+	if blob == nil {
+		return nil, nil, nil
+	}
+	return bytes.NewReader(blob.data), copyMeta(blob.meta), nil
+}
+
+// GetMeta returns metadata stored by key without data. Returns nil if key not found or expired
+func (m *Memory) GetMeta(key string) (map[string]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	blob := m.get(key)
+	if blob == nil {
+		return nil, nil
+	}
+	meta := copyMeta(blob.meta)
+	if meta == nil {
+		meta = map[string]string{}
+	}
+	return meta, nil
+}
+
+// TakeBlob returns data and metadata and removes them.
+// Returns nil reader if key not found or expired
+func (m *Memory) TakeBlob(key string) (io.Reader, map[string]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	blob := m.get(key)
+	if blob == nil {
+		return nil, nil, nil
+	}
+	m.remove(key)
+	return bytes.NewReader(blob.data), blob.meta, nil
+}
+
+// DeleteBlob removes data and metadata
+func (m *Memory) DeleteBlob(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.remove(key)
+	return nil
+}
+
+// DeleteExpired removes up to limit blobs expired at the moment now. Returns number of removed blobs
+func (m *Memory) DeleteExpired(now time.Time, limit int) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	deleted := 0
+	for key, elem := range m.blobs {
+		if deleted >= limit {
+			break
+		}
+		if elem.Value.(*memoryBlob).expired(now) {
+			m.remove(key)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// GetExpireTime returns expiration time of blob, zero time if blob doesn't expire or not found
+func (m *Memory) GetExpireTime(key string) (time.Time, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	blob := m.get(key)
+	if blob == nil {
+		return time.Time{}, nil
+	}
+	return blob.expireAt, nil
+}
+
+// ListKeys returns up to limit keys of not expired blobs with prefix that follow key after in lexicographic order
+func (m *Memory) ListKeys(prefix string, after string, limit int) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	keys := make([]string, 0, len(m.blobs))
+	for key, elem := range m.blobs {
+		if !elem.Value.(*memoryBlob).expired(now) {
+			keys = append(keys, key)
+		}
+	}
+	return pageKeys(keys, prefix, after, limit), nil
+}
+
+// Size returns total size of stored blobs
+func (m *Memory) Size() int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.size
+}
+
+// get returns live blob and marks it as recently used, expired blob is removed
+func (m *Memory) get(key string) *memoryBlob {
+	elem, ok := m.blobs[key]
+	if !ok {
+		return nil
+	}
+	blob := elem.Value.(*memoryBlob)
+	if blob.expired(time.Now()) {
+		m.remove(key)
+		return nil
+	}
+	m.lru.MoveToFront(elem)
+	return blob
+}
+
+func (m *Memory) remove(key string) {
+	elem, ok := m.blobs[key]
+	if !ok {
+		return
+	}
+	m.lru.Remove(elem)
+	delete(m.blobs, key)
+	m.size -= elem.Value.(*memoryBlob).size()
+}
+
+// evict removes least recently used blobs until size fits limit
+func (m *Memory) evict() {
+	for m.maxBytes > 0 && m.size > m.maxBytes {
+		m.remove(m.lru.Back().Value.(*memoryBlob).key)
+	}
+}
+
+func copyMeta(meta map[string]string) map[string]string {
+	if meta == nil {
+		return nil
+	}
+	res := make(map[string]string, len(meta))
+	for k, v := range meta {
+		res[k] = v
+	}
+	return res
+}
@@ -0,0 +1,65 @@
+package store
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vdimir/markify/store/storetest"
+)
+
+func TestMemoryConformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) storetest.Store {
+		return NewMemoryStorage(0)
+	})
+	storetest.Run(t, func(t *testing.T) storetest.Store {
+		return NewMemoryStorage(64 << 20)
+	})
+}
+
+func TestMemoryEviction(t *testing.T) {
+	m := NewMemoryStorage(300)
+	blob := func() *bytes.Reader { return bytes.NewReader(make([]byte, 99)) }
+
+	require.NoError(t, m.SetBlob("a", blob(), nil, 0))
+	require.NoError(t, m.SetBlob("b", blob(), nil, 0))
+	require.NoError(t, m.SetBlob("c", blob(), nil, 0))
+	assert.Equal(t, int64(300), m.Size())
+
+	// reading makes blob recently used, so "b" is evicted instead of "a"
+	storetest.RequireBlob(t, m, "a", make([]byte, 99), nil)
+	require.NoError(t, m.SetBlob("d", blob(), nil, 0))
+	storetest.RequireMissing(t, m, "b")
+
+	// metadata is counted too, "c" is the least recently used now
+	require.NoError(t, m.SetBlob("e", strings.NewReader("x"), map[string]string{"k": strings.Repeat("v", 97)}, 0))
+	storetest.RequireMissing(t, m, "c")
+	storetest.RequireBlob(t, m, "d", make([]byte, 99), nil)
+	storetest.RequireBlob(t, m, "e", []byte("x"), map[string]string{"k": strings.Repeat("v", 97)})
+	assert.Equal(t, int64(300), m.Size())
+
+	assert.Error(t, m.SetBlob("huge", bytes.NewReader(make([]byte, 301)), nil, 0))
+	storetest.RequireBlob(t, m, "a", make([]byte, 99), nil)
+
+	require.NoError(t, m.DeleteBlob("a"))
+	assert.Equal(t, int64(200), m.Size())
+}
+
+func TestMemoryDeleteExpired(t *testing.T) {
+	m := NewMemoryStorage(0)
+	require.NoError(t, m.SetBlob("short", strings.NewReader("foo"), nil, time.Minute))
+	require.NoError(t, m.SetBlob("long", strings.NewReader("bar"), nil, time.Hour))
+	require.NoError(t, m.SetBlob("forever", strings.NewReader("baz"), nil, 0))
+
+	n, err := m.DeleteExpired(time.Now().Add(time.Minute*2), 100)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	n, err = m.DeleteExpired(time.Now().Add(time.Hour*2), 100)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	storetest.RequireBlob(t, m, "forever", []byte("baz"), nil)
+	assert.Equal(t, int64(len("forever")+3), m.Size())
+}
@@ -0,0 +1,55 @@
+package store
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrQueryNotSupported is returned by decorators if underlying store can't find blobs by metadata
+var ErrQueryNotSupported = errors.New("underlying store can't query blobs")
+
+// Query selects blobs by metadata fields, zero fields are not used in filter
+type Query struct {
+	User          string    // "user" metadata field
+	Syntax        string    // "syntax" metadata field
+	CreatedAfter  time.Time // "create_time" metadata field is after time
+	CreatedBefore time.Time // "create_time" metadata field is before time
+	ExpiresBefore time.Time // blob expires before time, blobs without ttl don't match
+	Limit         int       // maximal number of results, zero for no limit
+}
+
+// BlobInfo describes blob found by Query
+type BlobInfo struct {
+	Key        string
+	Meta       map[string]string
+	ExpireTime time.Time // zero for blob without ttl
+}
+
+// queryBlobs returns blobs matching query from underlying store of decorator
+func queryBlobs(s Store, q Query) ([]BlobInfo, error) {
+	querier, ok := s.(interface {
+		QueryBlobs(q Query) ([]BlobInfo, error)
+	})
+	if !ok {
+		return nil, ErrQueryNotSupported
+	}
+	return querier.QueryBlobs(q)
+}
+
+// pageKeys returns up to limit sorted keys with prefix that follow key after, it's used by stores without ordered index
+func pageKeys(keys []string, prefix string, after string, limit int) []string {
+	var res []string
+	for _, key := range keys {
+		if strings.HasPrefix(key, prefix) && key > after {
+			res = append(res, key)
+		}
+	}
+	sort.Strings(res)
+	if len(res) > limit {
+		res = res[:limit]
+	}
+	return res
+}
@@ -1,14 +1,40 @@
 package store
 
 import (
+	"bytes"
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
-	"github.com/minio/minio-go/v7"
-	"github.com/minio/minio-go/v7/pkg/credentials"
-	"github.com/pkg/errors"
 	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
 	"strings"
+	"sync"
 	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/pkg/errors"
+)
+
+// s3ExpireMetaKey is reserved metadata key with expiration time of object.
+// S3 has no per-object ttl, so expired objects are hidden on read. Reads don't modify bucket,
+// so bucket lifecycle rule is needed to clean up expired objects
+const s3ExpireMetaKey = "markify-expire-time"
+
+// s3TakeClaimPrefix is prefix of objects created by TakeBlob to claim object before it's read and removed
+const s3TakeClaimPrefix = "_take/"
+
+// s3ClaimTimeMetaKey is metadata key of claim with time it was created
+const s3ClaimTimeMetaKey = "markify-claim-time"
+
+const (
+	// s3ClaimTimeout limits time of TakeBlob, so claim is not held longer than that by running process
+	s3ClaimTimeout = time.Minute
+	// s3ClaimStaleAge is age of claim after which it's considered left by crashed process and can be taken over
+	s3ClaimStaleAge = 5 * time.Minute
 )
 
 type S3Config struct {
@@ -16,46 +42,107 @@ type S3Config struct {
 	AccessKeyID     string `json:"access_key"`
 	SecretAccessKey string `json:"secret"`
 	Bucket          string `json:"bucket"`
+	Timeout         string `json:"timeout,omitempty"` // time limit of calls without context, e.g. "30s"
 }
 
+const defaultS3Timeout = time.Second * 5
+
+// S3Storage stores blobs as objects of bucket.
+// TakeBlob relies on conditional writes (If-None-Match and If-Match headers of PutObject),
+// it returns error if storage ignores them, so storage without their support can't be used
+// for pastes deleted after reading
 type S3Storage struct {
 	client  *minio.Client
 	bucket  string
-	timeout time.Duration
-	ctx context.Context
+	timeout time.Duration // time limit of methods without context
+
+	condMu      sync.Mutex
+	condChecked bool  // storage was checked for support of conditional writes
+	condErr     error // returned by TakeBlob if conditional writes are not supported
 }
 
 func NewS3Storage(cfg S3Config) (*S3Storage, error) {
+	timeout := defaultS3Timeout
+	if cfg.Timeout != "" {
+		var err error
+		if timeout, err = time.ParseDuration(cfg.Timeout); err != nil || timeout <= 0 {
+			return nil, errors.Errorf("invalid s3 timeout %q", cfg.Timeout)
+		}
+	}
+	transport, err := minio.DefaultTransport(false)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't create S3 transport")
+	}
 	minioClient, err := minio.New(cfg.Endpoint, &minio.Options{
-		Creds: credentials.NewStaticV4(cfg.AccessKeyID, cfg.SecretAccessKey, ""),
+		Creds:     credentials.NewStaticV4(cfg.AccessKeyID, cfg.SecretAccessKey, ""),
+		Transport: &conditionalTransport{RoundTripper: transport},
 	})
 	if err != nil {
 		return nil, errors.Wrap(err, "can't create S3 client")
 	}
-	exists, err := minioClient.BucketExists(context.Background(), cfg.Bucket)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	exists, err := minioClient.BucketExists(ctx, cfg.Bucket)
 	if err != nil {
 		return nil, errors.Wrap(err, "can't access to S3 storage")
 	}
 	if !exists {
 		return nil, fmt.Errorf("bucket %q does not exist", cfg.Bucket)
 	}
-	return &S3Storage{minioClient, cfg.Bucket, time.Second * 5, context.Background()}, nil
+	return &S3Storage{
+		client:  minioClient,
+		bucket:  cfg.Bucket,
+		timeout: timeout,
+	}, nil
 }
 
 func (s3 *S3Storage) SetBlob(key string, reader io.Reader, meta map[string]string, ttl time.Duration) error {
-	opts := minio.PutObjectOptions{}
-	if ttl > 0 {
-		opts.RetainUntilDate = time.Now().Add(ttl)
+	ctx, cancel := context.WithTimeout(context.Background(), s3.timeout)
+	defer cancel()
+	return s3.SetBlobContext(ctx, key, reader, meta, ttl)
+}
+
+// SetBlobContext uploads object, upload is aborted when ctx is done
+func (s3 *S3Storage) SetBlobContext(ctx context.Context, key string, reader io.Reader, meta map[string]string, ttl time.Duration) error {
+	opts := minio.PutObjectOptions{UserMetadata: map[string]string{}}
+	for k, v := range meta {
+		opts.UserMetadata[k] = v
 	}
-	if len(meta) > 0 {
-		opts.UserMetadata = meta
+	if ttl > 0 {
+		opts.UserMetadata[s3ExpireMetaKey] = time.Now().Add(ttl).UTC().Format(time.RFC3339Nano)
 	}
-	_, err := s3.client.PutObject(s3.ctx, s3.bucket, key, reader, -1, opts)
+	_, err := s3.client.PutObject(ctx, s3.bucket, key, reader, readerSize(reader), opts)
 	return errors.Wrap(err, "s3 put object error")
 }
 
+// readerSize returns size of data if it's known in advance or -1,
+// without size client buffers data to upload it in parts
+func readerSize(reader io.Reader) int64 {
+	if sized, ok := reader.(interface{ Size() int64 }); ok {
+		if seeker, ok := reader.(io.Seeker); ok {
+			pos, err := seeker.Seek(0, io.SeekCurrent)
+			if err == nil {
+				return sized.Size() - pos
+			}
+		}
+	}
+	return -1
+}
+
 func (s3 *S3Storage) GetBlob(key string) (io.Reader, map[string]string, error) {
-	obj, err := s3.client.GetObject(s3.ctx, s3.bucket, key, minio.GetObjectOptions{})
+	ctx, cancel := context.WithTimeout(context.Background(), s3.timeout)
+	obj, meta, err := s3.GetBlobContext(ctx, key)
+	if err != nil || obj == nil {
+		cancel()
+		return nil, nil, err
+	}
+	// object is downloaded while it's read, so time limit is released when reading is finished
+	return &cancelReader{Reader: obj, cancel: cancel}, meta, nil
+}
+
+// GetBlobContext returns reader of object, download is aborted when ctx is done
+func (s3 *S3Storage) GetBlobContext(ctx context.Context, key string) (io.Reader, map[string]string, error) {
+	obj, err := s3.client.GetObject(ctx, s3.bucket, key, minio.GetObjectOptions{})
 	if err != nil {
 		return nil, nil, errors.Wrap(err, "s3 get object error")
 	}
@@ -66,25 +153,300 @@ func (s3 *S3Storage) GetBlob(key string) (io.Reader, map[string]string, error) {
 		}
 		return nil, nil, errors.Wrap(err, "s3 get object meta error")
 	}
-	mapKeysToLower(stat.UserMetadata)
-	return obj, stat.UserMetadata, nil
+	meta, expired := objectMeta(stat.UserMetadata)
+	if expired {
+		_ = obj.Close()
+		return nil, nil, nil
+	}
+	return obj, meta, nil
+}
+
+// TakeBlob reads object and removes it. Concurrent calls, including ones of other processes, can't both get the data
+func (s3 *S3Storage) TakeBlob(key string) (io.Reader, map[string]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s3.timeout)
+	defer cancel()
+	return s3.TakeBlobContext(ctx, key)
+}
+
+// TakeBlobContext reads object and removes it, object is not removed if ctx is done before it's read.
+// S3 has no atomic read-and-delete operation, so object is claimed first by creating claim object
+// on condition that it doesn't exist, the only caller that created it reads and removes object.
+// Claim left by crashed process is taken over when it's older than s3ClaimStaleAge
+func (s3 *S3Storage) TakeBlobContext(ctx context.Context, key string) (io.Reader, map[string]string, error) {
+	if err := s3.checkConditionalWrites(ctx); err != nil {
+		return nil, nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, s3ClaimTimeout)
+	defer cancel()
+	claimed, err := s3.claim(ctx, key)
+	if err != nil || !claimed {
+		return nil, nil, err
+	}
+	// claim is removed after object, so next caller can't claim it while object exists
+	defer s3.releaseClaim(key)
+
+	obj, meta, err := s3.GetBlobContext(ctx, key)
+	if err != nil || obj == nil {
+		return nil, nil, err
+	}
+	data, err := ioutil.ReadAll(obj)
+	if closer, ok := obj.(io.Closer); ok {
+		_ = closer.Close()
+	}
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "s3 get object error")
+	}
+	if err = s3.DeleteBlobContext(ctx, key); err != nil {
+		return nil, nil, errors.Wrap(err, "s3 remove object error")
+	}
+	return bytes.NewReader(data), meta, nil
+}
+
+// claim creates claim object of key or replaces stale one, returns false if key is claimed by other caller
+func (s3 *S3Storage) claim(ctx context.Context, key string) (bool, error) {
+	claimKey := s3TakeClaimPrefix + key
+	created, err := s3.putClaim(withPrecondition(ctx, "If-None-Match", "*"), claimKey)
+	if err != nil || created {
+		return created, err
+	}
+	info, err := s3.client.StatObject(ctx, s3.bucket, claimKey, minio.StatObjectOptions{})
+	if err != nil {
+		if errResp, ok := err.(minio.ErrorResponse); ok && errResp.Code == "NoSuchKey" {
+			// claim is released meanwhile, object is already taken or it can be claimed again
+			return s3.putClaim(withPrecondition(ctx, "If-None-Match", "*"), claimKey)
+		}
+		return false, errors.Wrap(err, "s3 claim metadata error")
+	}
+	claimTime := info.LastModified
+	if v, ok := userMetaValue(info.UserMetadata, s3ClaimTimeMetaKey); ok {
+		if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+			claimTime = t
+		}
+	}
+	if time.Since(claimTime) < s3ClaimStaleAge {
+		return false, nil
+	}
+	log.Printf("[WARN] claim of object %q created at %s is stale, taking it over", key, claimTime.Format(time.RFC3339))
+	// claim is replaced only if it's not changed since stat, so concurrent callers can't both take it over
+	return s3.putClaim(withPrecondition(ctx, "If-Match", `"`+strings.Trim(info.ETag, `"`)+`"`), claimKey)
+}
+
+// putClaim uploads claim object with current time, returns false if precondition of ctx failed
+func (s3 *S3Storage) putClaim(ctx context.Context, claimKey string) (bool, error) {
+	opts := minio.PutObjectOptions{UserMetadata: map[string]string{
+		s3ClaimTimeMetaKey: time.Now().UTC().Format(time.RFC3339Nano),
+	}}
+	_, err := s3.client.PutObject(ctx, s3.bucket, claimKey, bytes.NewReader(nil), 0, opts)
+	if err != nil {
+		if isPreconditionFailed(err) {
+			return false, nil
+		}
+		return false, errors.Wrap(err, "s3 claim object error")
+	}
+	return true, nil
+}
+
+// checkConditionalWrites checks once that storage rejects upload with If-None-Match of existing object.
+// Conditional header is not signed, so storage or proxy in front of it may drop or ignore it silently,
+// then claims don't exclude each other and data of paste could be read twice
+func (s3 *S3Storage) checkConditionalWrites(ctx context.Context) error {
+	s3.condMu.Lock()
+	defer s3.condMu.Unlock()
+	if s3.condChecked {
+		return s3.condErr
+	}
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return errors.Wrap(err, "can't generate probe key")
+	}
+	probeKey := s3TakeClaimPrefix + ".probe-" + hex.EncodeToString(suffix)
+	defer func() {
+		if err := s3.DeleteBlobContext(ctx, probeKey); err != nil {
+			log.Printf("[WARN] probe object %q not removed: %s", probeKey, err)
+		}
+	}()
+	for i := 0; i < 2; i++ {
+		created, err := s3.putClaim(withPrecondition(ctx, "If-None-Match", "*"), probeKey)
+		if err != nil {
+			return errors.Wrap(err, "can't check conditional writes support")
+		}
+		if i == 0 && !created {
+			return errors.New("can't check conditional writes support: probe object exists")
+		}
+		if i == 1 && created {
+			log.Printf("[WARN] S3 storage ignores conditional writes, pastes deleted after reading can't be read")
+			s3.condErr = errors.New("s3 storage doesn't support conditional writes, object can't be taken")
+		}
+	}
+	s3.condChecked = true
+	return s3.condErr
 }
 
+// releaseClaim removes claim object, it's done even if ctx of TakeBlobContext is done
+func (s3 *S3Storage) releaseClaim(key string) {
+	ctx, cancel := context.WithTimeout(context.Background(), s3.timeout)
+	defer cancel()
+	if err := s3.DeleteBlobContext(ctx, s3TakeClaimPrefix+key); err != nil {
+		log.Printf("[WARN] claim of object %q not removed, it can't be taken until it's removed: %s", key, err)
+	}
+}
+
+// GetMeta returns metadata of object without downloading its data. Returns nil if key not found or expired
 func (s3 *S3Storage) GetMeta(key string) (map[string]string, error) {
-	objMeta, err := s3.client.StatObject(s3.ctx, s3.bucket, key, minio.StatObjectOptions{})
+	ctx, cancel := context.WithTimeout(context.Background(), s3.timeout)
+	defer cancel()
+	return s3.GetMetaContext(ctx, key)
+}
+
+// GetMetaContext returns metadata of object, request is aborted when ctx is done
+func (s3 *S3Storage) GetMetaContext(ctx context.Context, key string) (map[string]string, error) {
+	objMeta, err := s3.client.StatObject(ctx, s3.bucket, key, minio.StatObjectOptions{})
 	if err != nil {
+		if errResp, ok := err.(minio.ErrorResponse); ok && errResp.Code == "NoSuchKey" {
+			return nil, nil
+		}
 		return nil, errors.Wrap(err, "s3 metadata error")
 	}
-	mapKeysToLower(objMeta.UserMetadata)
-	return objMeta.UserMetadata, nil
+	meta, expired := objectMeta(objMeta.UserMetadata)
+	if expired {
+		return nil, nil
+	}
+	return meta, nil
+}
+
+// GetExpireTime returns expiration time of object, zero time if object doesn't expire, not found or expired
+func (s3 *S3Storage) GetExpireTime(key string) (time.Time, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s3.timeout)
+	defer cancel()
+	objMeta, err := s3.client.StatObject(ctx, s3.bucket, key, minio.StatObjectOptions{})
+	if err != nil {
+		if errResp, ok := err.(minio.ErrorResponse); ok && errResp.Code == "NoSuchKey" {
+			return time.Time{}, nil
+		}
+		return time.Time{}, errors.Wrap(err, "s3 metadata error")
+	}
+	v, ok := userMetaValue(objMeta.UserMetadata, s3ExpireMetaKey)
+	if !ok {
+		return time.Time{}, nil
+	}
+	expireTime, err := time.Parse(time.RFC3339Nano, v)
+	if err != nil || !expireTime.After(time.Now()) {
+		return time.Time{}, nil
+	}
+	return expireTime, nil
+}
+
+// ListKeys returns up to limit keys with prefix that follow key after in lexicographic order.
+// Listing has no object metadata, so it includes expired objects that are not removed yet
+func (s3 *S3Storage) ListKeys(prefix string, after string, limit int) ([]string, error) {
+	keys := make([]string, 0, limit)
+	for len(keys) < limit {
+		res, err := minio.Core{Client: s3.client}.ListObjectsV2(s3.bucket, prefix, after, "", "", limit-len(keys))
+		if err != nil {
+			return nil, errors.Wrap(err, "s3 list objects error")
+		}
+		for _, obj := range res.Contents {
+			// claims of objects being taken are not blobs
+			if !strings.HasPrefix(obj.Key, s3TakeClaimPrefix) {
+				keys = append(keys, obj.Key)
+			}
+			after = obj.Key
+		}
+		if !res.IsTruncated || len(res.Contents) == 0 {
+			break
+		}
+	}
+	return keys, nil
 }
 
 func (s3 *S3Storage) DeleteBlob(key string) error {
-	return s3.client.RemoveObject(s3.ctx, s3.bucket, key, minio.RemoveObjectOptions{})
+	ctx, cancel := context.WithTimeout(context.Background(), s3.timeout)
+	defer cancel()
+	return s3.DeleteBlobContext(ctx, key)
 }
 
-func mapKeysToLower(m map[string]string) {
-	for k, v := range m {
-		m[strings.ToLower(k)] = v
+// DeleteBlobContext removes object, request is aborted when ctx is done
+func (s3 *S3Storage) DeleteBlobContext(ctx context.Context, key string) error {
+	return s3.client.RemoveObject(ctx, s3.bucket, key, minio.RemoveObjectOptions{})
+}
+
+// isPreconditionFailed reports if request is rejected because its condition is not met
+func isPreconditionFailed(err error) bool {
+	errResp, ok := err.(minio.ErrorResponse)
+	return ok && errResp.Code == "PreconditionFailed"
+}
+
+// userMetaValue returns value of user metadata by key regardless of its case
+func userMetaValue(userMeta map[string]string, key string) (string, bool) {
+	for k, v := range userMeta {
+		if strings.ToLower(k) == key {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// objectMeta returns metadata with lowercase keys as they were set and reports if object is expired
+func objectMeta(userMeta map[string]string) (map[string]string, bool) {
+	meta := make(map[string]string, len(userMeta))
+	for k, v := range userMeta {
+		meta[strings.ToLower(k)] = v
+	}
+	expired := false
+	if expireTime, ok := meta[s3ExpireMetaKey]; ok {
+		delete(meta, s3ExpireMetaKey)
+		t, err := time.Parse(time.RFC3339Nano, expireTime)
+		expired = err == nil && !t.After(time.Now())
+	}
+	return meta, expired
+}
+
+// cancelReader releases context of reader when it's read to the end or closed
+type cancelReader struct {
+	io.Reader
+	cancel context.CancelFunc
+}
+
+func (r *cancelReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if err != nil {
+		r.cancel()
 	}
-}
\ No newline at end of file
+	return n, err
+}
+
+// Close closes underlying reader and releases context
+func (r *cancelReader) Close() error {
+	defer r.cancel()
+	if closer, ok := r.Reader.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+type preconditionKey struct{}
+
+type precondition struct {
+	header string
+	value  string
+}
+
+// withPrecondition returns ctx of request that is sent with conditional header.
+// Client has no options for conditional writes, so header is set by conditionalTransport
+func withPrecondition(ctx context.Context, header string, value string) context.Context {
+	return context.WithValue(ctx, preconditionKey{}, precondition{header: header, value: value})
+}
+
+// conditionalTransport adds conditional header to object uploads with context made by withPrecondition.
+// Header is not signed, signature of S3 requests covers only host and x-amz-* headers necessarily
+type conditionalTransport struct {
+	http.RoundTripper
+}
+
+func (t *conditionalTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if cond, ok := req.Context().Value(preconditionKey{}).(precondition); ok && req.Method == http.MethodPut {
+		req = req.Clone(req.Context())
+		req.Header.Set(cond.header, cond.value)
+	}
+	return t.RoundTripper.RoundTrip(req)
+}
@@ -0,0 +1,149 @@
+package store
+
+import (
+	"context"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vdimir/markify/store/storetest"
+)
+
+func createTestS3(t *testing.T) *S3Storage {
+	return connectTestS3(t, storetest.NewS3Server(t, "markify"))
+}
+
+func connectTestS3(t *testing.T, srv *storetest.S3Server) *S3Storage {
+	s3, err := NewS3Storage(S3Config{
+		Endpoint:        srv.Endpoint(),
+		AccessKeyID:     "access",
+		SecretAccessKey: "secret",
+		Bucket:          "markify",
+	})
+	require.NoError(t, err)
+	return s3
+}
+
+func TestS3Conformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) storetest.Store {
+		return createTestS3(t)
+	})
+}
+
+func TestS3Config(t *testing.T) {
+	srv := storetest.NewS3Server(t, "markify")
+	s3, err := NewS3Storage(S3Config{Endpoint: srv.Endpoint(), Bucket: "markify", Timeout: "30s"})
+	require.NoError(t, err)
+	require.Equal(t, "30s", s3.timeout.String())
+
+	_, err = NewS3Storage(S3Config{Endpoint: srv.Endpoint(), Bucket: "markify", Timeout: "30"})
+	require.Error(t, err)
+	_, err = NewS3Storage(S3Config{Endpoint: srv.Endpoint(), Bucket: "other"})
+	require.Error(t, err)
+}
+
+func TestS3TakeByInstances(t *testing.T) {
+	srv := storetest.NewS3Server(t, "markify")
+	instances := []*S3Storage{connectTestS3(t, srv), connectTestS3(t, srv), connectTestS3(t, srv)}
+	require.NoError(t, instances[0].SetBlob("key", strings.NewReader("data"), nil, 0))
+
+	var taken int
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < 12; i++ {
+		wg.Add(1)
+		go func(s3 *S3Storage) {
+			defer wg.Done()
+			reader, _, err := s3.TakeBlob("key")
+			assert.NoError(t, err)
+			if reader != nil {
+				mu.Lock()
+				taken++
+				mu.Unlock()
+			}
+		}(instances[i%len(instances)])
+	}
+	wg.Wait()
+	assert.Equal(t, 1, taken, "blob is taken exactly once")
+	keys, err := instances[0].ListKeys("", "", 10)
+	require.NoError(t, err)
+	assert.Empty(t, keys, "claim is removed")
+
+	// blob claimed by other instance is not taken
+	require.NoError(t, instances[0].SetBlob("key", strings.NewReader("data"), nil, 0))
+	claimed, err := instances[1].claim(context.Background(), "key")
+	require.NoError(t, err)
+	require.True(t, claimed)
+	reader, _, err := instances[2].TakeBlob("key")
+	require.NoError(t, err)
+	assert.Nil(t, reader)
+	keys, err = instances[0].ListKeys("", "", 10)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"key"}, keys, "claims are not listed")
+	instances[1].releaseClaim("key")
+	storetest.RequireBlob(t, instances[2], "key", []byte("data"), map[string]string{})
+}
+
+func TestS3StaleClaim(t *testing.T) {
+	s3 := createTestS3(t)
+	require.NoError(t, s3.SetBlob("key", strings.NewReader("data"), nil, 0))
+
+	// claim left by crashed process blocks taking until it's stale
+	claimTime := time.Now().Add(-s3ClaimStaleAge / 2).UTC().Format(time.RFC3339Nano)
+	require.NoError(t, s3.SetBlob(s3TakeClaimPrefix+"key", strings.NewReader(""), map[string]string{s3ClaimTimeMetaKey: claimTime}, 0))
+	reader, _, err := s3.TakeBlob("key")
+	require.NoError(t, err)
+	assert.Nil(t, reader)
+
+	claimTime = time.Now().Add(-s3ClaimStaleAge - time.Minute).UTC().Format(time.RFC3339Nano)
+	require.NoError(t, s3.SetBlob(s3TakeClaimPrefix+"key", strings.NewReader(""), map[string]string{s3ClaimTimeMetaKey: claimTime}, 0))
+	reader, _, err = s3.TakeBlob("key")
+	require.NoError(t, err)
+	require.NotNil(t, reader)
+	data, err := ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "data", string(data))
+
+	claim, _, err := s3.GetBlob(s3TakeClaimPrefix + "key")
+	require.NoError(t, err)
+	assert.Nil(t, claim, "claim is removed")
+	reader, _, err = s3.TakeBlob("key")
+	require.NoError(t, err)
+	assert.Nil(t, reader)
+}
+
+func TestS3IgnoredPreconditions(t *testing.T) {
+	srv := storetest.NewS3Server(t, "markify")
+	srv.IgnorePreconditions = true
+	s3 := connectTestS3(t, srv)
+	require.NoError(t, s3.SetBlob("key", strings.NewReader("data"), nil, 0))
+
+	_, _, err := s3.TakeBlob("key")
+	assert.Error(t, err, "object is not taken without conditional writes")
+	storetest.RequireBlob(t, s3, "key", []byte("data"), map[string]string{})
+	var keys []string
+	for obj := range s3.client.ListObjects(context.Background(), "markify", minio.ListObjectsOptions{Recursive: true}) {
+		require.NoError(t, obj.Err)
+		keys = append(keys, obj.Key)
+	}
+	assert.Equal(t, []string{"key"}, keys, "probe object is removed")
+}
+
+func TestS3ReadExpired(t *testing.T) {
+	s3 := createTestS3(t)
+	require.NoError(t, s3.SetBlob("expired", strings.NewReader("data"), nil, time.Millisecond))
+	time.Sleep(10 * time.Millisecond)
+
+	storetest.RequireMissing(t, s3, "expired")
+	reader, _, err := s3.TakeBlob("expired")
+	require.NoError(t, err)
+	assert.Nil(t, reader)
+	keys, err := s3.ListKeys("", "", 10)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"expired"}, keys, "expired object is not removed on read")
+}
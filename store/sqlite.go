@@ -0,0 +1,295 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	_ "modernc.org/sqlite" // cgo-free sqlite driver
+)
+
+// sqliteSchema keeps metadata fields used in queries in indexed columns,
+// full metadata map is stored as json. Times are unix nanoseconds
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS blobs (
+	key         TEXT PRIMARY KEY,
+	data        BLOB,
+	meta        TEXT NOT NULL,
+	user        TEXT,
+	syntax      TEXT,
+	create_time INTEGER,
+	ttl         INTEGER,
+	expire_time INTEGER
+);
+CREATE INDEX IF NOT EXISTS blobs_user ON blobs (user, create_time);
+CREATE INDEX IF NOT EXISTS blobs_syntax ON blobs (syntax);
+CREATE INDEX IF NOT EXISTS blobs_create_time ON blobs (create_time);
+CREATE INDEX IF NOT EXISTS blobs_expire_time ON blobs (expire_time);
+`
+
+// Sqlite store data in SQLite database file
+type Sqlite struct {
+	db *sql.DB
+}
+
+// NewSqliteStorage create Sqlite Store
+func NewSqliteStorage(fileName string) (*Sqlite, error) {
+	if err := os.MkdirAll(filepath.Dir(fileName), os.ModePerm); err != nil && !os.IsExist(err) {
+		return nil, err
+	}
+	db, err := sql.Open("sqlite", fileName)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't open sqlite database")
+	}
+	// sqlite allows single writer, so all requests share one connection instead of waiting for locks
+	db.SetMaxOpenConns(1)
+	if _, err = db.Exec("PRAGMA journal_mode=WAL;" + sqliteSchema); err != nil {
+		_ = db.Close()
+		return nil, errors.Wrap(err, "can't initialize sqlite database")
+	}
+	return &Sqlite{db: db}, nil
+}
+
+// SetBlob save data in storage. Blob with positive ttl expires after ttl elapsed
+func (s *Sqlite) SetBlob(key string, reader io.Reader, meta map[string]string, ttl time.Duration) error {
+	return s.SetBlobContext(context.Background(), key, reader, meta, ttl)
+}
+
+// SetBlobContext save data in storage, statement is interrupted when ctx is done
+func (s *Sqlite) SetBlobContext(ctx context.Context, key string, reader io.Reader, meta map[string]string, ttl time.Duration) error {
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return errors.Wrap(err, "can't read data from reader")
+	}
+	metadata, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	var expireTime sql.NullInt64
+	if ttl > 0 {
+		expireTime = sql.NullInt64{Int64: time.Now().Add(ttl).UnixNano(), Valid: true}
+	}
+	_, err = s.db.ExecContext(ctx, `INSERT OR REPLACE INTO blobs (key, data, meta, user, syntax, create_time, ttl, expire_time)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		key, data, string(metadata), nullString(meta["user"]), nullString(meta["syntax"]),
+		nullTime(meta["create_time"]), nullDuration(meta["ttl"]), expireTime)
+	return errors.Wrap(err, "sqlite insert error")
+}
+
+// GetBlob returns data and metadata stored by key. Returns nil reader if key not found or expired
+func (s *Sqlite) GetBlob(key string) (io.Reader, map[string]string, error) {
+	return s.GetBlobContext(context.Background(), key)
+}
+
+// GetBlobContext returns data and metadata stored by key, query is interrupted when ctx is done
+func (s *Sqlite) GetBlobContext(ctx context.Context, key string) (io.Reader, map[string]string, error) {
+	row := s.db.QueryRowContext(ctx, `SELECT data, meta FROM blobs
+		WHERE key = ? AND (expire_time IS NULL OR expire_time > ?)`, key, time.Now().UnixNano())
+	return scanBlob(row)
+}
+
+// GetMeta returns metadata stored by key without data. Returns nil if key not found or expired
+func (s *Sqlite) GetMeta(key string) (map[string]string, error) {
+	return s.GetMetaContext(context.Background(), key)
+}
+
+// GetMetaContext returns metadata stored by key, query is interrupted when ctx is done
+func (s *Sqlite) GetMetaContext(ctx context.Context, key string) (map[string]string, error) {
+	var metadata string
+	err := s.db.QueryRowContext(ctx, `SELECT meta FROM blobs
+		WHERE key = ? AND (expire_time IS NULL OR expire_time > ?)`, key, time.Now().UnixNano()).Scan(&metadata)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "sqlite select error")
+	}
+	var meta map[string]string
+	if err = json.Unmarshal([]byte(metadata), &meta); err != nil {
+		return nil, errors.Wrap(err, "broken metadata")
+	}
+	if meta == nil {
+		// blob saved without metadata
+		meta = map[string]string{}
+	}
+	return meta, nil
+}
+
+// TakeBlob returns data and metadata and removes them in single statement.
+// Returns nil reader if key not found or expired
+func (s *Sqlite) TakeBlob(key string) (io.Reader, map[string]string, error) {
+	return s.TakeBlobContext(context.Background(), key)
+}
+
+// TakeBlobContext returns data and metadata and removes them, statement is interrupted when ctx is done
+func (s *Sqlite) TakeBlobContext(ctx context.Context, key string) (io.Reader, map[string]string, error) {
+	row := s.db.QueryRowContext(ctx, `DELETE FROM blobs
+		WHERE key = ? AND (expire_time IS NULL OR expire_time > ?)
+		RETURNING data, meta`, key, time.Now().UnixNano())
+	return scanBlob(row)
+}
+
+// DeleteBlob removes data and metadata
+func (s *Sqlite) DeleteBlob(key string) error {
+	return s.DeleteBlobContext(context.Background(), key)
+}
+
+// DeleteBlobContext removes data and metadata, statement is interrupted when ctx is done
+func (s *Sqlite) DeleteBlobContext(ctx context.Context, key string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM blobs WHERE key = ?`, key)
+	return errors.Wrap(err, "sqlite delete error")
+}
+
+// GetExpireTime returns expiration time of blob, zero time if blob doesn't expire, not found or expired
+func (s *Sqlite) GetExpireTime(key string) (time.Time, error) {
+	var expireTime sql.NullInt64
+	err := s.db.QueryRow(`SELECT expire_time FROM blobs
+		WHERE key = ? AND (expire_time IS NULL OR expire_time > ?)`, key, time.Now().UnixNano()).Scan(&expireTime)
+	if err == sql.ErrNoRows {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, errors.Wrap(err, "sqlite select error")
+	}
+	if !expireTime.Valid {
+		return time.Time{}, nil
+	}
+	return time.Unix(0, expireTime.Int64), nil
+}
+
+// DeleteExpired removes up to limit blobs expired at the moment now. Returns number of removed blobs
+func (s *Sqlite) DeleteExpired(now time.Time, limit int) (int, error) {
+	res, err := s.db.Exec(`DELETE FROM blobs WHERE key IN
+		(SELECT key FROM blobs WHERE expire_time <= ? LIMIT ?)`, now.UnixNano(), limit)
+	if err != nil {
+		return 0, errors.Wrap(err, "sqlite delete error")
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+// ListKeys returns up to limit keys of not expired blobs with prefix that follow key after in lexicographic order
+func (s *Sqlite) ListKeys(prefix string, after string, limit int) ([]string, error) {
+	rows, err := s.db.Query(`SELECT key FROM blobs
+		WHERE key > ? AND substr(key, 1, length(?)) = ? AND (expire_time IS NULL OR expire_time > ?)
+		ORDER BY key LIMIT ?`, after, prefix, prefix, time.Now().UnixNano(), limit)
+	if err != nil {
+		return nil, errors.Wrap(err, "sqlite query error")
+	}
+	defer rows.Close()
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err = rows.Scan(&key); err != nil {
+			return nil, errors.Wrap(err, "sqlite query error")
+		}
+		keys = append(keys, key)
+	}
+	return keys, errors.Wrap(rows.Err(), "sqlite query error")
+}
+
+// QueryBlobs returns not expired blobs matching query, most recently created first
+func (s *Sqlite) QueryBlobs(q Query) ([]BlobInfo, error) {
+	conds := []string{"(expire_time IS NULL OR expire_time > ?)"}
+	args := []interface{}{time.Now().UnixNano()}
+	if q.User != "" {
+		conds = append(conds, "user = ?")
+		args = append(args, q.User)
+	}
+	if q.Syntax != "" {
+		conds = append(conds, "syntax = ?")
+		args = append(args, q.Syntax)
+	}
+	if !q.CreatedAfter.IsZero() {
+		conds = append(conds, "create_time > ?")
+		args = append(args, q.CreatedAfter.UnixNano())
+	}
+	if !q.CreatedBefore.IsZero() {
+		conds = append(conds, "create_time < ?")
+		args = append(args, q.CreatedBefore.UnixNano())
+	}
+	if !q.ExpiresBefore.IsZero() {
+		conds = append(conds, "expire_time < ?")
+		args = append(args, q.ExpiresBefore.UnixNano())
+	}
+	query := "SELECT key, meta, expire_time FROM blobs WHERE " + strings.Join(conds, " AND ") +
+		" ORDER BY create_time DESC, key"
+	if q.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, q.Limit)
+	}
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "sqlite query error")
+	}
+	defer rows.Close()
+	var res []BlobInfo
+	for rows.Next() {
+		var info BlobInfo
+		var metadata string
+		var expireTime sql.NullInt64
+		if err = rows.Scan(&info.Key, &metadata, &expireTime); err != nil {
+			return nil, errors.Wrap(err, "sqlite query error")
+		}
+		if err = json.Unmarshal([]byte(metadata), &info.Meta); err != nil {
+			return nil, errors.Wrapf(err, "broken metadata of %q", info.Key)
+		}
+		if expireTime.Valid {
+			info.ExpireTime = time.Unix(0, expireTime.Int64)
+		}
+		res = append(res, info)
+	}
+	return res, errors.Wrap(rows.Err(), "sqlite query error")
+}
+
+// Close storage
+func (s *Sqlite) Close() error {
+	return s.db.Close()
+}
+
+func scanBlob(row *sql.Row) (io.Reader, map[string]string, error) {
+	var data []byte
+	var metadata string
+	err := row.Scan(&data, &metadata)
+	if err == sql.ErrNoRows {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "sqlite select error")
+	}
+	var meta map[string]string
+	if err = json.Unmarshal([]byte(metadata), &meta); err != nil {
+		return nil, nil, errors.Wrap(err, "broken metadata")
+	}
+	return bytes.NewReader(data), meta, nil
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+// nullTime converts time from metadata to column value, unparsable time is stored as NULL
+func nullTime(s string) sql.NullInt64 {
+	t := time.Time{}
+	if err := t.UnmarshalText([]byte(s)); err != nil {
+		return sql.NullInt64{}
+	}
+	return sql.NullInt64{Int64: t.UnixNano(), Valid: true}
+}
+
+func nullDuration(s string) sql.NullInt64 {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return sql.NullInt64{}
+	}
+	return sql.NullInt64{Int64: int64(d), Valid: true}
+}
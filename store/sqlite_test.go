@@ -0,0 +1,115 @@
+package store
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vdimir/markify/store/storetest"
+	"github.com/vdimir/markify/testutil"
+)
+
+func createTestSqlite(t *testing.T) *Sqlite {
+	tmpPath, tmpFolderClean := testutil.GetTempFolder(t, "test_sqlite")
+	s, err := NewSqliteStorage(filepath.Join(tmpPath, "data.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		defer tmpFolderClean()
+		assert.NoError(t, s.Close())
+	})
+	return s
+}
+
+func TestSqliteConformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) storetest.Store {
+		return createTestSqlite(t)
+	})
+}
+
+func TestSqliteQuery(t *testing.T) {
+	s := createTestSqlite(t)
+	baseTime := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 6; i++ {
+		createTime, err := baseTime.Add(time.Hour * time.Duration(i)).MarshalText()
+		require.NoError(t, err)
+		meta := map[string]string{
+			"user":        fmt.Sprintf("user%d", i%2),
+			"syntax":      []string{"go", "markdown"}[i%3/2],
+			"create_time": string(createTime),
+			"ttl":         "0s",
+		}
+		var ttl time.Duration
+		if i >= 4 {
+			ttl = time.Hour * time.Duration(i)
+			meta["ttl"] = ttl.String()
+		}
+		require.NoError(t, s.SetBlob(fmt.Sprintf("doc%d", i), strings.NewReader("data"), meta, ttl))
+	}
+	require.NoError(t, s.SetBlob("no_meta", strings.NewReader("data"), nil, 0))
+
+	keys := func(q Query) []string {
+		infos, err := s.QueryBlobs(q)
+		require.NoError(t, err)
+		var res []string
+		for _, info := range infos {
+			res = append(res, info.Key)
+		}
+		return res
+	}
+
+	assert.Equal(t, []string{"doc4", "doc2", "doc0"}, keys(Query{User: "user0"}))
+	assert.Equal(t, []string{"doc4", "doc2"}, keys(Query{User: "user0", Limit: 2}))
+	assert.Equal(t, []string{"doc5", "doc2"}, keys(Query{Syntax: "markdown"}))
+	assert.Equal(t, []string{"doc3", "doc2"},
+		keys(Query{CreatedAfter: baseTime.Add(time.Hour), CreatedBefore: baseTime.Add(time.Hour * 4)}))
+	assert.Equal(t, []string{"doc4"}, keys(Query{ExpiresBefore: time.Now().Add(time.Hour * 9 / 2)}))
+	assert.Len(t, keys(Query{}), 7)
+
+	infos, err := s.QueryBlobs(Query{User: "user1", Limit: 1})
+	require.NoError(t, err)
+	require.Len(t, infos, 1)
+	assert.Equal(t, "doc5", infos[0].Key)
+	assert.Equal(t, "user1", infos[0].Meta["user"])
+	assert.WithinDuration(t, time.Now().Add(time.Hour*5), infos[0].ExpireTime, time.Minute)
+
+	n, err := s.DeleteExpired(time.Now().Add(time.Hour*10), 1)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	n, err = s.DeleteExpired(time.Now().Add(time.Hour*10), 100)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, []string{"doc2", "doc0"}, keys(Query{User: "user0"}))
+}
+
+func TestSqliteQueryDecorated(t *testing.T) {
+	compressed, err := NewCompress(createTestEncrypted(t, createTestSqlite(t), testEncryptionKey("k1")), CodecZstd)
+	require.NoError(t, err)
+	dedup := NewDedup(createTestSqlite(t))
+	for name, s := range map[string]interface {
+		Store
+		QueryBlobs(q Query) ([]BlobInfo, error)
+	}{"compress+encrypted": compressed, "dedup": dedup} {
+		t.Run(name, func(t *testing.T) {
+			meta := map[string]string{"user": "user0", "create_time": "2021-03-01T12:00:00Z"}
+			require.NoError(t, s.SetBlob("doc0", strings.NewReader(strings.Repeat("data", 100)), meta, time.Hour))
+			require.NoError(t, s.SetBlob("doc1", strings.NewReader(strings.Repeat("data", 100)), meta, time.Hour))
+
+			infos, err := s.QueryBlobs(Query{User: "user0"})
+			require.NoError(t, err)
+			require.Len(t, infos, 2)
+			assert.Equal(t, meta, infos[0].Meta, "reserved metadata keys are not returned")
+
+			// content of dedup is stored with ttl, but it's not returned
+			infos, err = s.QueryBlobs(Query{ExpiresBefore: time.Now().Add(time.Hour * 2)})
+			require.NoError(t, err)
+			assert.Len(t, infos, 2)
+		})
+	}
+
+	_, err = NewDedup(NewMemoryStorage(0)).QueryBlobs(Query{User: "user0"})
+	assert.Equal(t, ErrQueryNotSupported, err)
+}
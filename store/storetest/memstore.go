@@ -0,0 +1,96 @@
+package storetest
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"sync"
+	"time"
+)
+
+// MemStore is in-memory reference implementation of Store for tests
+type MemStore struct {
+	mu    sync.Mutex
+	blobs map[string]memBlob
+}
+
+type memBlob struct {
+	data     []byte
+	meta     map[string]string
+	expireAt time.Time
+}
+
+// NewMemStore creates empty MemStore
+func NewMemStore() *MemStore {
+	return &MemStore{blobs: map[string]memBlob{}}
+}
+
+// SetBlob stores copy of data and metadata
+func (s *MemStore) SetBlob(key string, reader io.Reader, meta map[string]string, ttl time.Duration) error {
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return err
+	}
+	blob := memBlob{data: data, meta: copyMeta(meta)}
+	if ttl > 0 {
+		blob.expireAt = time.Now().Add(ttl)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.blobs[key] = blob
+	return nil
+}
+
+// GetBlob returns blob by key, nil reader if it's missing or expired
+func (s *MemStore) GetBlob(key string) (io.Reader, map[string]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	blob, ok := s.get(key)
+	if !ok {
+		return nil, nil, nil
+	}
+	return bytes.NewReader(blob.data), copyMeta(blob.meta), nil
+}
+
+// TakeBlob returns blob by key and deletes it
+func (s *MemStore) TakeBlob(key string) (io.Reader, map[string]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	blob, ok := s.get(key)
+	if !ok {
+		return nil, nil, nil
+	}
+	delete(s.blobs, key)
+	return bytes.NewReader(blob.data), blob.meta, nil
+}
+
+// DeleteBlob removes blob by key
+func (s *MemStore) DeleteBlob(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.blobs, key)
+	return nil
+}
+
+func (s *MemStore) get(key string) (memBlob, bool) {
+	blob, ok := s.blobs[key]
+	if !ok {
+		return memBlob{}, false
+	}
+	if !blob.expireAt.IsZero() && !blob.expireAt.After(time.Now()) {
+		delete(s.blobs, key)
+		return memBlob{}, false
+	}
+	return blob, true
+}
+
+func copyMeta(meta map[string]string) map[string]string {
+	if meta == nil {
+		return nil
+	}
+	res := make(map[string]string, len(meta))
+	for k, v := range meta {
+		res[k] = v
+	}
+	return res
+}
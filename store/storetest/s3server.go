@@ -0,0 +1,415 @@
+package storetest
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// S3Server is local stand-in for S3 compatible storage with single bucket.
+// It supports only requests used by store.S3Storage and ignores authentication,
+// uploads are conditional on If-None-Match and If-Match headers as in S3
+type S3Server struct {
+	*httptest.Server
+	bucket string
+
+	// IgnorePreconditions makes server ignore conditional headers as some S3 compatible storages do,
+	// it should be set before requests are sent
+	IgnorePreconditions bool
+
+	mu      sync.Mutex
+	objects map[string]s3Object
+	uploads map[string]*s3Upload // multipart uploads by id
+}
+
+// s3Upload is multipart upload in progress
+type s3Upload struct {
+	key    string
+	header http.Header // user metadata headers
+	parts  map[int][]byte
+}
+
+type s3Object struct {
+	data     []byte
+	header   http.Header // user metadata headers
+	modified time.Time
+}
+
+// NewS3Server starts server with empty bucket, it's closed on test cleanup
+func NewS3Server(t testing.TB, bucket string) *S3Server {
+	s := &S3Server{bucket: bucket, objects: map[string]s3Object{}, uploads: map[string]*s3Upload{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// Endpoint returns host and port of server
+func (s *S3Server) Endpoint() string {
+	return strings.TrimPrefix(s.URL, "http://")
+}
+
+func (s *S3Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	bucket, key := path, ""
+	if i := strings.IndexByte(path, '/'); i >= 0 {
+		bucket, key = path[:i], path[i+1:]
+	}
+	if bucket != s.bucket {
+		s3Error(w, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+	if key == "" {
+		s.serveBucket(w, r)
+		return
+	}
+
+	query := r.URL.Query()
+	if _, ok := query["uploads"]; ok || query.Get("uploadId") != "" {
+		s.serveMultipart(w, r, key)
+		return
+	}
+	switch r.Method {
+	case http.MethodPut:
+		s.putObject(w, r, key)
+	case http.MethodGet, http.MethodHead:
+		s.getObject(w, r, key)
+	case http.MethodDelete:
+		s.mu.Lock()
+		delete(s.objects, key)
+		s.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		s3Error(w, http.StatusMethodNotAllowed, "MethodNotAllowed")
+	}
+}
+
+func (s *S3Server) serveBucket(w http.ResponseWriter, r *http.Request) {
+	_, location := r.URL.Query()["location"]
+	switch {
+	case r.Method == http.MethodHead:
+		w.WriteHeader(http.StatusOK)
+	case r.Method == http.MethodGet && location:
+		w.Header().Set("Content-Type", "application/xml")
+		fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?>`+
+			`<LocationConstraint xmlns="http://s3.amazonaws.com/doc/2006-03-01/"></LocationConstraint>`)
+	case r.Method == http.MethodGet && r.URL.Query().Get("list-type") == "2":
+		s.listObjects(w, r)
+	default:
+		s3Error(w, http.StatusNotImplemented, "NotImplemented")
+	}
+}
+
+// listObjects responds with keys of objects in lexicographic order (ListObjectsV2),
+// continuation token is the last listed key
+func (s *S3Server) listObjects(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	prefix, startAfter, token := query.Get("prefix"), query.Get("start-after"), query.Get("continuation-token")
+	after := startAfter
+	if token != "" {
+		after = token
+	}
+	maxKeys := 1000
+	if n, err := strconv.Atoi(query.Get("max-keys")); err == nil && n >= 0 && n < maxKeys {
+		maxKeys = n
+	}
+	s.mu.Lock()
+	var keys []string
+	for key := range s.objects {
+		if strings.HasPrefix(key, prefix) && key > after {
+			keys = append(keys, key)
+		}
+	}
+	s.mu.Unlock()
+	sort.Strings(keys)
+	truncated := len(keys) > maxKeys
+	if truncated {
+		keys = keys[:maxKeys]
+	}
+
+	encode := func(name string) string { return name }
+	if query.Get("encoding-type") == "url" {
+		encode = url.QueryEscape
+	}
+	result := listBucketResult{
+		Name:              s.bucket,
+		Prefix:            encode(prefix),
+		StartAfter:        encode(startAfter),
+		ContinuationToken: token,
+		KeyCount:          len(keys),
+		MaxKeys:           maxKeys,
+		IsTruncated:       truncated,
+		EncodingType:      query.Get("encoding-type"),
+	}
+	if truncated {
+		result.NextContinuationToken = keys[len(keys)-1]
+	}
+	for _, key := range keys {
+		result.Contents = append(result.Contents, listBucketObject{Key: encode(key)})
+	}
+	writeXML(w, result)
+}
+
+type listBucketResult struct {
+	XMLName               xml.Name           `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListBucketResult"`
+	Name                  string             `xml:"Name"`
+	Prefix                string             `xml:"Prefix"`
+	StartAfter            string             `xml:"StartAfter,omitempty"`
+	ContinuationToken     string             `xml:"ContinuationToken,omitempty"`
+	NextContinuationToken string             `xml:"NextContinuationToken,omitempty"`
+	KeyCount              int                `xml:"KeyCount"`
+	MaxKeys               int                `xml:"MaxKeys"`
+	IsTruncated           bool               `xml:"IsTruncated"`
+	EncodingType          string             `xml:"EncodingType,omitempty"`
+	Contents              []listBucketObject `xml:"Contents"`
+}
+
+type listBucketObject struct {
+	Key string `xml:"Key"`
+}
+
+func (s *S3Server) putObject(w http.ResponseWriter, r *http.Request, key string) {
+	data, err := readBody(r)
+	if err != nil {
+		s3Error(w, http.StatusBadRequest, "IncompleteBody")
+		return
+	}
+	obj := s3Object{data: data, header: userMetaHeader(r.Header), modified: time.Now().UTC()}
+	s.mu.Lock()
+	current, exists := s.objects[key]
+	if !s.IgnorePreconditions && !uploadAllowed(r.Header, current, exists) {
+		s.mu.Unlock()
+		s3Error(w, http.StatusPreconditionFailed, "PreconditionFailed")
+		return
+	}
+	s.objects[key] = obj
+	s.mu.Unlock()
+	w.Header().Set("ETag", obj.etag())
+	w.WriteHeader(http.StatusOK)
+}
+
+// serveMultipart handles requests of multipart upload: initiation, upload of part, completion and abort
+func (s *S3Server) serveMultipart(w http.ResponseWriter, r *http.Request, key string) {
+	query := r.URL.Query()
+	uploadID := query.Get("uploadId")
+	if r.Method == http.MethodPost && uploadID == "" {
+		s.mu.Lock()
+		uploadID = strconv.Itoa(len(s.uploads)+1) + "-" + strconv.FormatInt(time.Now().UnixNano(), 36)
+		s.uploads[uploadID] = &s3Upload{key: key, header: userMetaHeader(r.Header), parts: map[int][]byte{}}
+		s.mu.Unlock()
+		writeXML(w, initiateMultipartUploadResult{Bucket: s.bucket, Key: key, UploadID: uploadID})
+		return
+	}
+
+	s.mu.Lock()
+	upload, ok := s.uploads[uploadID]
+	s.mu.Unlock()
+	if !ok || upload.key != key {
+		s3Error(w, http.StatusNotFound, "NoSuchUpload")
+		return
+	}
+	switch r.Method {
+	case http.MethodPut:
+		partNumber, err := strconv.Atoi(query.Get("partNumber"))
+		if err != nil {
+			s3Error(w, http.StatusBadRequest, "InvalidArgument")
+			return
+		}
+		data, err := readBody(r)
+		if err != nil {
+			s3Error(w, http.StatusBadRequest, "IncompleteBody")
+			return
+		}
+		s.mu.Lock()
+		upload.parts[partNumber] = data
+		s.mu.Unlock()
+		w.Header().Set("ETag", s3Object{data: data}.etag())
+		w.WriteHeader(http.StatusOK)
+	case http.MethodPost:
+		var complete struct {
+			Parts []struct {
+				PartNumber int `xml:"PartNumber"`
+			} `xml:"Part"`
+		}
+		if err := xml.NewDecoder(r.Body).Decode(&complete); err != nil {
+			s3Error(w, http.StatusBadRequest, "MalformedXML")
+			return
+		}
+		obj := s3Object{header: upload.header, modified: time.Now().UTC()}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for _, part := range complete.Parts {
+			data, ok := upload.parts[part.PartNumber]
+			if !ok {
+				s3Error(w, http.StatusBadRequest, "InvalidPart")
+				return
+			}
+			obj.data = append(obj.data, data...)
+		}
+		current, exists := s.objects[key]
+		if !s.IgnorePreconditions && !uploadAllowed(r.Header, current, exists) {
+			s3Error(w, http.StatusPreconditionFailed, "PreconditionFailed")
+			return
+		}
+		s.objects[key] = obj
+		delete(s.uploads, uploadID)
+		writeXML(w, completeMultipartUploadResult{Bucket: s.bucket, Key: key, ETag: obj.etag()})
+	case http.MethodDelete:
+		s.mu.Lock()
+		delete(s.uploads, uploadID)
+		s.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		s3Error(w, http.StatusMethodNotAllowed, "MethodNotAllowed")
+	}
+}
+
+type initiateMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ InitiateMultipartUploadResult"`
+	Bucket   string   `xml:"Bucket"`
+	Key      string   `xml:"Key"`
+	UploadID string   `xml:"UploadId"`
+}
+
+type completeMultipartUploadResult struct {
+	XMLName xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ CompleteMultipartUploadResult"`
+	Bucket  string   `xml:"Bucket"`
+	Key     string   `xml:"Key"`
+	ETag    string   `xml:"ETag"`
+}
+
+// readBody returns data of upload request
+func readBody(r *http.Request) ([]byte, error) {
+	var body io.Reader = r.Body
+	if strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+		body = &chunkedReader{r: bufio.NewReader(r.Body)}
+	}
+	return ioutil.ReadAll(body)
+}
+
+// userMetaHeader returns user metadata headers of upload request
+func userMetaHeader(header http.Header) http.Header {
+	res := http.Header{}
+	for name, values := range header {
+		if strings.HasPrefix(strings.ToLower(name), "x-amz-meta-") {
+			res[name] = values
+		}
+	}
+	return res
+}
+
+func writeXML(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/xml")
+	fmt.Fprint(w, xml.Header)
+	_ = xml.NewEncoder(w).Encode(v)
+}
+
+// uploadAllowed checks preconditions of upload against current object
+func uploadAllowed(header http.Header, current s3Object, exists bool) bool {
+	if cond := header.Get("If-None-Match"); cond != "" && exists && (cond == "*" || cond == current.etag()) {
+		return false
+	}
+	if cond := header.Get("If-Match"); cond != "" && (!exists || cond != "*" && cond != current.etag()) {
+		return false
+	}
+	return true
+}
+
+func (s *S3Server) getObject(w http.ResponseWriter, r *http.Request, key string) {
+	s.mu.Lock()
+	obj, ok := s.objects[key]
+	s.mu.Unlock()
+	if !ok {
+		s3Error(w, http.StatusNotFound, "NoSuchKey")
+		return
+	}
+	for name, values := range obj.header {
+		w.Header()[name] = values
+	}
+	w.Header().Set("ETag", obj.etag())
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Last-Modified", obj.modified.Format(http.TimeFormat))
+	http.ServeContent(w, r, "", obj.modified, bytes.NewReader(obj.data))
+}
+
+func (o s3Object) etag() string {
+	sum := md5.Sum(o.data)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+func s3Error(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?><Error><Code>%s</Code><Message>%s</Message></Error>`,
+		code, http.StatusText(status))
+}
+
+// chunkedReader decodes body of request signed with streaming signature:
+// chunks of "hex-size;chunk-signature=...\r\n<data>\r\n" ending with zero size chunk
+type chunkedReader struct {
+	r    *bufio.Reader
+	left int
+	done bool
+}
+
+func (c *chunkedReader) Read(p []byte) (int, error) {
+	for c.left == 0 {
+		if c.done {
+			return 0, io.EOF
+		}
+		if err := c.nextChunk(); err != nil {
+			return 0, err
+		}
+	}
+	if len(p) > c.left {
+		p = p[:c.left]
+	}
+	n, err := c.r.Read(p)
+	c.left -= n
+	if c.left == 0 && err == nil {
+		err = c.skipCRLF()
+	}
+	return n, err
+}
+
+func (c *chunkedReader) nextChunk() error {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return io.ErrUnexpectedEOF
+	}
+	sizeStr := strings.TrimSpace(line)
+	if i := strings.IndexByte(sizeStr, ';'); i >= 0 {
+		sizeStr = sizeStr[:i]
+	}
+	size, err := strconv.ParseInt(sizeStr, 16, 64)
+	if err != nil {
+		return fmt.Errorf("bad chunk size %q", sizeStr)
+	}
+	if size == 0 {
+		c.done = true
+		return nil
+	}
+	c.left = int(size)
+	return nil
+}
+
+func (c *chunkedReader) skipCRLF() error {
+	buf := make([]byte, 2)
+	if _, err := io.ReadFull(c.r, buf); err != nil || string(buf) != "\r\n" {
+		return fmt.Errorf("bad chunk end")
+	}
+	return nil
+}
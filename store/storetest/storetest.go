@@ -0,0 +1,383 @@
+// Package storetest provides conformance tests for blob stores.
+//
+// Every store is expected to follow the same contract:
+//   - GetBlob and TakeBlob return data and metadata as they were passed to the last SetBlob call for the key.
+//     Metadata keys are lowercase, empty metadata may be returned as nil or empty map;
+//   - missing and expired keys are reported as nil reader with nil metadata and nil error,
+//     DeleteBlob of missing key is not an error;
+//   - blob set with positive ttl expires after ttl elapsed, zero ttl means no expiration
+//     and SetBlob without ttl removes expiration of the previous blob;
+//   - TakeBlob returns blob to the single caller even if it's called concurrently;
+//   - keys may contain slashes and concurrent SetBlob calls don't corrupt blobs;
+//   - stores that can enumerate keys return them in lexicographic order page by page,
+//     empty page means there are no keys left;
+//   - stores that can read metadata without data return nil for missing and expired keys
+//     and non-nil map for existing ones;
+//   - stores that report expiration time return zero time for blobs without ttl, missing and expired ones.
+package storetest
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Store is a blob store under test, it has the same methods as app.Store
+type Store interface {
+	SetBlob(key string, reader io.Reader, meta map[string]string, ttl time.Duration) error
+	GetBlob(key string) (io.Reader, map[string]string, error)
+	TakeBlob(key string) (io.Reader, map[string]string, error)
+	DeleteBlob(key string) error
+}
+
+// Lister is implemented by stores that can enumerate keys
+type Lister interface {
+	ListKeys(prefix string, after string, limit int) ([]string, error)
+}
+
+// MetaReader is implemented by stores that can read metadata without data
+type MetaReader interface {
+	GetMeta(key string) (map[string]string, error)
+}
+
+// ExpiryReader is implemented by stores that report expiration time of blobs
+type ExpiryReader interface {
+	GetExpireTime(key string) (time.Time, error)
+}
+
+// ttl used in tests, stores are expected to be precise enough to expire blob in ttlWait
+const ttl = 200 * time.Millisecond
+const ttlWait = 3 * ttl
+
+// Run runs conformance tests, each test gets new empty store created by newStore
+func Run(t *testing.T, newStore func(t *testing.T) Store) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, s Store)
+	}{
+		{"RoundTrip", testRoundTrip},
+		{"Overwrite", testOverwrite},
+		{"Missing", testMissing},
+		{"Delete", testDelete},
+		{"Take", testTake},
+		{"TTL", testTTL},
+		{"LargeBlob", testLargeBlob},
+		{"KeysWithSlashes", testKeysWithSlashes},
+		{"ConcurrentWriters", testConcurrentWriters},
+		{"ListKeys", testListKeys},
+		{"GetMeta", testGetMeta},
+		{"ExpireTime", testExpireTime},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fn(t, newStore(t))
+		})
+	}
+}
+
+// RequireBlob checks that store has blob with data and metadata
+func RequireBlob(t *testing.T, s Store, key string, data []byte, meta map[string]string) {
+	reader, gotMeta, err := s.GetBlob(key)
+	require.NoError(t, err)
+	require.NotNil(t, reader, "blob %q not found", key)
+	gotData, err := ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	require.Equal(t, data, gotData, "data of blob %q", key)
+	requireMeta(t, meta, gotMeta)
+}
+
+// RequireMissing checks that store has no blob by key
+func RequireMissing(t *testing.T, s Store, key string) {
+	reader, meta, err := s.GetBlob(key)
+	require.NoError(t, err)
+	require.Nil(t, reader, "blob %q is not expected", key)
+	require.Nil(t, meta)
+}
+
+func requireMeta(t *testing.T, expected map[string]string, actual map[string]string) {
+	if len(expected) == 0 {
+		require.Empty(t, actual)
+		return
+	}
+	require.Equal(t, expected, actual)
+}
+
+func testRoundTrip(t *testing.T, s Store) {
+	meta := map[string]string{
+		"syntax":      "go",
+		"create_time": "2021-01-02T15:04:05.123456789Z",
+		"source_url":  "https://example.com/path?query=1&b=2",
+		"title":       "Hello, world!",
+	}
+	require.NoError(t, s.SetBlob("key", strings.NewReader("some data"), meta, 0))
+	RequireBlob(t, s, "key", []byte("some data"), meta)
+	// blob can be read many times
+	RequireBlob(t, s, "key", []byte("some data"), meta)
+
+	require.NoError(t, s.SetBlob("no_meta", strings.NewReader("other data"), nil, 0))
+	RequireBlob(t, s, "no_meta", []byte("other data"), nil)
+
+	require.NoError(t, s.SetBlob("empty", bytes.NewReader(nil), map[string]string{"k": "v"}, 0))
+	RequireBlob(t, s, "empty", []byte{}, map[string]string{"k": "v"})
+}
+
+func testOverwrite(t *testing.T, s Store) {
+	require.NoError(t, s.SetBlob("key", strings.NewReader("first"), map[string]string{"a": "1", "b": "2"}, 0))
+	require.NoError(t, s.SetBlob("key", strings.NewReader("second"), map[string]string{"a": "3"}, 0))
+	RequireBlob(t, s, "key", []byte("second"), map[string]string{"a": "3"})
+}
+
+func testMissing(t *testing.T, s Store) {
+	RequireMissing(t, s, "missing")
+
+	reader, meta, err := s.TakeBlob("missing")
+	require.NoError(t, err)
+	assert.Nil(t, reader)
+	assert.Nil(t, meta)
+
+	assert.NoError(t, s.DeleteBlob("missing"))
+}
+
+func testDelete(t *testing.T, s Store) {
+	require.NoError(t, s.SetBlob("key", strings.NewReader("data"), map[string]string{"a": "1"}, 0))
+	require.NoError(t, s.SetBlob("other", strings.NewReader("data"), nil, 0))
+	require.NoError(t, s.DeleteBlob("key"))
+	RequireMissing(t, s, "key")
+	RequireBlob(t, s, "other", []byte("data"), nil)
+
+	require.NoError(t, s.SetBlob("key", strings.NewReader("new data"), nil, 0))
+	RequireBlob(t, s, "key", []byte("new data"), nil)
+}
+
+func testTake(t *testing.T, s Store) {
+	require.NoError(t, s.SetBlob("key", strings.NewReader("data"), map[string]string{"a": "1"}, 0))
+	reader, meta, err := s.TakeBlob("key")
+	require.NoError(t, err)
+	require.NotNil(t, reader)
+	data, err := ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "data", string(data))
+	assert.Equal(t, map[string]string{"a": "1"}, meta)
+	RequireMissing(t, s, "key")
+
+	require.NoError(t, s.SetBlob("key", strings.NewReader("data"), nil, 0))
+	const takers = 10
+	var taken int
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < takers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			reader, _, err := s.TakeBlob("key")
+			assert.NoError(t, err)
+			if reader != nil {
+				mu.Lock()
+				taken++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 1, taken, "blob is taken exactly once")
+}
+
+func testTTL(t *testing.T, s Store) {
+	require.NoError(t, s.SetBlob("expiring", strings.NewReader("data"), map[string]string{"a": "1"}, ttl))
+	require.NoError(t, s.SetBlob("permanent", strings.NewReader("data"), nil, 0))
+	require.NoError(t, s.SetBlob("extended", strings.NewReader("data"), nil, ttl))
+	require.NoError(t, s.SetBlob("extended", strings.NewReader("new data"), nil, 0))
+	require.NoError(t, s.SetBlob("taken", strings.NewReader("data"), nil, ttl))
+	RequireBlob(t, s, "expiring", []byte("data"), map[string]string{"a": "1"})
+
+	time.Sleep(ttlWait)
+	if metaReader, ok := s.(MetaReader); ok {
+		meta, err := metaReader.GetMeta("expiring")
+		require.NoError(t, err)
+		assert.Nil(t, meta, "metadata of expired blob")
+	}
+	RequireMissing(t, s, "expiring")
+	RequireBlob(t, s, "permanent", []byte("data"), nil)
+	RequireBlob(t, s, "extended", []byte("new data"), nil)
+
+	reader, _, err := s.TakeBlob("taken")
+	require.NoError(t, err)
+	assert.Nil(t, reader, "expired blob can't be taken")
+
+	require.NoError(t, s.SetBlob("expiring", strings.NewReader("again"), nil, 0))
+	RequireBlob(t, s, "expiring", []byte("again"), nil)
+}
+
+func testLargeBlob(t *testing.T, s Store) {
+	data := make([]byte, 5<<20)
+	rand.New(rand.NewSource(42)).Read(data)
+	require.NoError(t, s.SetBlob("large", bytes.NewReader(data), map[string]string{"size": "large"}, 0))
+	RequireBlob(t, s, "large", data, map[string]string{"size": "large"})
+}
+
+func testKeysWithSlashes(t *testing.T, s Store) {
+	keys := []string{"doc", "doc/rev/1", "doc/rev/10", "_index/user/abc", "a/b/c/"}
+	for _, key := range keys {
+		require.NoError(t, s.SetBlob(key, strings.NewReader("data of "+key), nil, 0))
+	}
+	for _, key := range keys {
+		RequireBlob(t, s, key, []byte("data of "+key), nil)
+	}
+	require.NoError(t, s.DeleteBlob("doc/rev/1"))
+	RequireMissing(t, s, "doc/rev/1")
+	RequireBlob(t, s, "doc/rev/10", []byte("data of doc/rev/10"), nil)
+	RequireBlob(t, s, "doc", []byte("data of doc"), nil)
+}
+
+func testConcurrentWriters(t *testing.T, s Store) {
+	const writers = 8
+	const blobSize = 64 << 10
+	payload := func(i int) []byte {
+		return bytes.Repeat([]byte{byte('a' + i)}, blobSize)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			meta := map[string]string{"writer": fmt.Sprint(i)}
+			assert.NoError(t, s.SetBlob(fmt.Sprintf("key%d", i), bytes.NewReader(payload(i)), meta, 0))
+			assert.NoError(t, s.SetBlob("shared", bytes.NewReader(payload(i)), meta, 0))
+		}(i)
+	}
+	wg.Wait()
+
+	for i := 0; i < writers; i++ {
+		RequireBlob(t, s, fmt.Sprintf("key%d", i), payload(i), map[string]string{"writer": fmt.Sprint(i)})
+	}
+
+	reader, meta, err := s.GetBlob("shared")
+	require.NoError(t, err)
+	require.NotNil(t, reader)
+	data, err := ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	var writer int
+	_, err = fmt.Sscan(meta["writer"], &writer)
+	require.NoError(t, err)
+	assert.Equal(t, payload(writer), data, "data and metadata of shared blob are from the same writer")
+}
+
+func testListKeys(t *testing.T, s Store) {
+	lister, ok := s.(Lister)
+	if !ok {
+		t.Skip("store can't list keys")
+	}
+	keys, err := lister.ListKeys("", "", 10)
+	require.NoError(t, err)
+	assert.Empty(t, keys)
+
+	stored := []string{"b", "a/2", "a/10", "a/1", "c", "ab"}
+	for _, key := range stored {
+		require.NoError(t, s.SetBlob(key, strings.NewReader("data of "+key), nil, 0))
+	}
+	require.NoError(t, s.SetBlob("deleted", strings.NewReader("data"), nil, 0))
+	require.NoError(t, s.DeleteBlob("deleted"))
+
+	var listed []string
+	after := ""
+	for {
+		keys, err := lister.ListKeys("", after, 2)
+		require.NoError(t, err)
+		if len(keys) == 0 {
+			break
+		}
+		require.LessOrEqual(t, len(keys), 2)
+		listed = append(listed, keys...)
+		after = keys[len(keys)-1]
+	}
+	assert.Equal(t, []string{"a/1", "a/10", "a/2", "ab", "b", "c"}, listed)
+
+	keys, err = lister.ListKeys("a/", "", 10)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a/1", "a/10", "a/2"}, keys)
+
+	keys, err = lister.ListKeys("a/", "a/1", 10)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a/10", "a/2"}, keys)
+
+	keys, err = lister.ListKeys("a", "a/2", 10)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"ab"}, keys)
+}
+
+func testGetMeta(t *testing.T, s Store) {
+	metaReader, ok := s.(MetaReader)
+	if !ok {
+		t.Skip("store can't read metadata separately")
+	}
+	meta := map[string]string{"syntax": "go", "title": "Hello, world!"}
+	require.NoError(t, s.SetBlob("key", strings.NewReader("data"), meta, 0))
+	require.NoError(t, s.SetBlob("no_meta", strings.NewReader("data"), nil, 0))
+	require.NoError(t, s.SetBlob("expiring", strings.NewReader("data"), meta, ttl))
+
+	got, err := metaReader.GetMeta("key")
+	require.NoError(t, err)
+	assert.Equal(t, meta, got)
+
+	got, err = metaReader.GetMeta("no_meta")
+	require.NoError(t, err)
+	assert.NotNil(t, got, "existing blob without metadata")
+	assert.Empty(t, got)
+
+	got, err = metaReader.GetMeta("missing")
+	require.NoError(t, err)
+	assert.Nil(t, got)
+
+	got, err = metaReader.GetMeta("expiring")
+	require.NoError(t, err)
+	assert.Equal(t, meta, got)
+
+	require.NoError(t, s.DeleteBlob("key"))
+	got, err = metaReader.GetMeta("key")
+	require.NoError(t, err)
+	assert.Nil(t, got)
+}
+
+func testExpireTime(t *testing.T, s Store) {
+	expiryReader, ok := s.(ExpiryReader)
+	if !ok {
+		t.Skip("store can't report expiration time")
+	}
+	require.NoError(t, s.SetBlob("forever", strings.NewReader("data"), nil, 0))
+	expireTime, err := expiryReader.GetExpireTime("forever")
+	if err != nil {
+		// decorators report expiration time only if underlying store does
+		t.Skipf("store can't report expiration time: %s", err)
+	}
+	assert.True(t, expireTime.IsZero())
+
+	now := time.Now()
+	require.NoError(t, s.SetBlob("hour", strings.NewReader("data"), nil, time.Hour))
+	expireTime, err = expiryReader.GetExpireTime("hour")
+	require.NoError(t, err)
+	assert.WithinDuration(t, now.Add(time.Hour), expireTime, time.Second)
+
+	require.NoError(t, s.SetBlob("hour", strings.NewReader("data"), nil, 0))
+	expireTime, err = expiryReader.GetExpireTime("hour")
+	require.NoError(t, err)
+	assert.True(t, expireTime.IsZero(), "ttl removed on overwrite")
+
+	require.NoError(t, s.SetBlob("expiring", strings.NewReader("data"), nil, ttl))
+	time.Sleep(ttlWait)
+	for _, key := range []string{"expiring", "missing"} {
+		expireTime, err = expiryReader.GetExpireTime(key)
+		require.NoError(t, err)
+		assert.True(t, expireTime.IsZero(), key)
+	}
+}
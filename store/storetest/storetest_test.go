@@ -0,0 +1,9 @@
+package storetest
+
+import "testing"
+
+func TestMemStore(t *testing.T) {
+	Run(t, func(t *testing.T) Store {
+		return NewMemStore()
+	})
+}
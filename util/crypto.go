@@ -0,0 +1,77 @@
+package util
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/scrypt"
+)
+
+const passwordCipherVersion = 1
+const saltLen = 16
+
+// scrypt parameters recommended for interactive logins
+const scryptN = 1 << 15
+const scryptR = 8
+const scryptP = 1
+
+// ErrWrongPassword returned when data can't be decrypted with password
+var ErrWrongPassword = errors.New("wrong password")
+
+// EncryptWithPassword encrypts data with AES-GCM using key derived from password with scrypt.
+// Result contains everything except password required to decrypt data
+func EncryptWithPassword(data []byte, password string) ([]byte, error) {
+	salt := make([]byte, saltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	aead, err := passwordAEAD(password, salt)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	res := make([]byte, 0, 1+len(salt)+len(nonce)+len(data)+aead.Overhead())
+	res = append(res, passwordCipherVersion)
+	res = append(res, salt...)
+	res = append(res, nonce...)
+	return aead.Seal(res, nonce, data, nil), nil
+}
+
+// DecryptWithPassword decrypts data encrypted by EncryptWithPassword
+func DecryptWithPassword(data []byte, password string) ([]byte, error) {
+	if len(data) < 1+saltLen || data[0] != passwordCipherVersion {
+		return nil, errors.New("malformed encrypted data")
+	}
+	salt := data[1 : 1+saltLen]
+	aead, err := passwordAEAD(password, salt)
+	if err != nil {
+		return nil, err
+	}
+	data = data[1+saltLen:]
+	if len(data) < aead.NonceSize() {
+		return nil, errors.New("malformed encrypted data")
+	}
+	nonce, ciphertext := data[:aead.NonceSize()], data[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, ErrWrongPassword
+	}
+	return plaintext, nil
+}
+
+func passwordAEAD(password string, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(password), salt, scryptN, scryptR, scryptP, 32)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't derive key")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,34 @@
+package util
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncryptWithPassword(t *testing.T) {
+	plaintext := []byte("db password: hunter2")
+
+	encrypted, err := EncryptWithPassword(plaintext, "pa$$word")
+	require.NoError(t, err)
+	assert.NotContains(t, string(encrypted), "hunter2")
+
+	other, err := EncryptWithPassword(plaintext, "pa$$word")
+	require.NoError(t, err)
+	assert.NotEqual(t, encrypted, other, "salt and nonce are random")
+
+	decrypted, err := DecryptWithPassword(encrypted, "pa$$word")
+	require.NoError(t, err)
+	assert.Equal(t, plaintext, decrypted)
+
+	_, err = DecryptWithPassword(encrypted, "password")
+	assert.Equal(t, ErrWrongPassword, err)
+
+	_, err = DecryptWithPassword(encrypted[:10], "pa$$word")
+	assert.Error(t, err)
+
+	encrypted[len(encrypted)-1] ^= 1
+	_, err = DecryptWithPassword(encrypted, "pa$$word")
+	assert.Equal(t, ErrWrongPassword, err)
+}
@@ -4,7 +4,7 @@ import (
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/base64"
-	"hash"
+	"encoding/hex"
 	"strings"
 
 	"github.com/rs/xid"
@@ -44,34 +44,46 @@ func GetUID() []byte {
 	return xid.New().Bytes()
 }
 
+// SignedUIDGenerator generates unique ids signed with secret key
 type SignedUIDGenerator struct {
-	hasher  hash.Hash
+	secret  []byte
 	signLen int
 	sep     string
 }
 
+// NewSignedUIDGenerator creates SignedUIDGenerator with secret key
 func NewSignedUIDGenerator(secret []byte) *SignedUIDGenerator {
 	return &SignedUIDGenerator{
-		hasher:  hmac.New(sha256.New224, secret),
+		secret:  secret,
 		signLen: 8,
 		sep:     "_",
 	}
 }
 
+// GetUID returns new unique id of length n followed by signature
 func (s *SignedUIDGenerator) GetUID(n int) []byte {
 	uid := Base58UID(n)
-	sign := s.hasher.Sum(uid)
-	signStr := base64.StdEncoding.EncodeToString(sign)[:s.signLen]
-	return []byte(string(uid) + s.sep + signStr)
-
+	return []byte(string(uid) + s.sep + s.sign(uid))
 }
 
+// Validate checks that data is id generated by GetUID
 func (s *SignedUIDGenerator) Validate(data []byte) bool {
 	dataParts := strings.SplitN(string(data), s.sep, 2)
 	if len(dataParts) != 2 {
 		return false
 	}
-	sign := s.hasher.Sum([]byte(dataParts[0]))
-	signStr := base64.StdEncoding.EncodeToString(sign)[:s.signLen]
-	return signStr == dataParts[1]
+	signStr := s.sign([]byte(dataParts[0]))
+	return hmac.Equal([]byte(signStr), []byte(dataParts[1]))
+}
+
+func (s *SignedUIDGenerator) sign(uid []byte) string {
+	hasher := hmac.New(sha256.New224, s.secret)
+	hasher.Write(uid)
+	return base64.RawURLEncoding.EncodeToString(hasher.Sum(nil))[:s.signLen]
+}
+
+// TokenHash returns hex encoded sha256 of token, suitable to store instead of secret itself
+func TokenHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
 }
@@ -1,6 +1,7 @@
 package util
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -33,3 +34,21 @@ func TestGUID(t *testing.T) {
 	assert.NotEqual(g1, g3)
 	assert.NotEqual(g2, g3)
 }
+
+func TestSignedUIDGenerator(t *testing.T) {
+	assert := assert.New(t)
+	gen := NewSignedUIDGenerator([]byte("secret"))
+	otherGen := NewSignedUIDGenerator([]byte("other secret"))
+
+	uid1 := gen.GetUID(10)
+	uid2 := gen.GetUID(10)
+	assert.NotEqual(uid1, uid2)
+	assert.True(gen.Validate(uid1))
+	assert.True(gen.Validate(uid2))
+	assert.False(otherGen.Validate(uid1))
+
+	parts := strings.SplitN(string(uid1), "_", 2)
+	assert.False(gen.Validate([]byte(parts[0])))
+	assert.False(gen.Validate([]byte(parts[0] + "_" + strings.Repeat("a", len(parts[1])))))
+	assert.False(gen.Validate([]byte(string(Base58UID(10)) + "_" + parts[1])))
+}
@@ -9,11 +9,12 @@ import (
 // For testing purposes only
 type DebugRender struct {
 	fs.FS
+	assetURL AssetURLFunc
 }
 
 // RenderPage render page and writes data to wr. Reloads all templates every time
 func (htmlRend *DebugRender) RenderPage(wr io.Writer, tplContext TemplateContext) error {
-	inner, err := newView(htmlRend.FS)
+	inner, err := newView(htmlRend.FS, htmlRend.assetURL)
 	if err != nil {
 		return err
 	}
@@ -13,6 +13,13 @@ type EditorContext struct {
 	Title       string
 	Msg         string
 	InitialText string
+	Syntax      string
+	Syntaxes    []SyntaxOption // languages for syntax highlighting
+	Action      string         // url to submit form, "/create" if empty
+	EditToken   string
+	ForkedFrom  string // id of paste which copy is edited
+
+	ShowUserPastes bool
 }
 
 // Name of the page
@@ -20,6 +27,24 @@ func (c *EditorContext) FileName() string {
 	return "editor.html"
 }
 
+// URLPromptContext context for url_prompt.html
+type URLPromptContext struct {
+	Title       string
+	Msg         string
+	InitialText string
+}
+
+// Name of the page
+func (c *URLPromptContext) FileName() string {
+	return "url_prompt.html"
+}
+
+// SyntaxOption language to select in editor
+type SyntaxOption struct {
+	Name  string
+	Label string
+}
+
 // OpenGraphInfo contain Opengraph metadata
 type OpenGraphInfo struct {
 	Title       string
@@ -36,6 +61,24 @@ type PageContext struct {
 	OgInfo     *OpenGraphInfo
 	CreateTime string
 	DocID      string
+	SourceURL  string
+	Editable   bool
+	EditToken  string
+
+	Syntax         string
+	SyntaxDetected bool
+	Syntaxes       []SyntaxOption // languages to render document as
+
+	Burned bool // document deleted after showing
+
+	Revision  int // shown revision number, zero for the latest one
+	Revisions int // number of revisions
+
+	ForkedFrom string   // id of paste this one is copied from
+	Forks      []string // ids of pastes copied from this one
+	Forkable   bool
+
+	Ciphertext string // document encrypted in browser, decrypted with key from url fragment
 }
 
 // Name of the page
@@ -54,3 +97,97 @@ type StatusContext struct {
 func (c *StatusContext) FileName() string {
 	return "status.html"
 }
+
+// RevealContext context for reveal.html
+type RevealContext struct {
+	Title       string
+	Action      string // url to submit to reveal document
+	Msg         string
+	Burn        bool // document will be deleted after reading
+	AskPassword bool // document is protected with password
+}
+
+// Name of the page
+func (c *RevealContext) FileName() string {
+	return "reveal.html"
+}
+
+// PasteInfo short description of paste
+type PasteInfo struct {
+	DocID      string
+	Title      string
+	CreateTime string
+	ExpireTime string
+}
+
+// UserPastesContext context for my_pastes.html
+type UserPastesContext struct {
+	Title    string
+	Pastes   []PasteInfo
+	NextPage string // position to list following pastes from, empty if there are no more pastes
+}
+
+// Name of the page
+func (c *UserPastesContext) FileName() string {
+	return "my_pastes.html"
+}
+
+// RevisionInfo short description of paste revision
+type RevisionInfo struct {
+	Number int
+	Time   string
+}
+
+// HistoryContext context for history.html
+type HistoryContext struct {
+	Title     string
+	DocID     string
+	Revisions []RevisionInfo // starting from the latest
+}
+
+// Name of the page
+func (c *HistoryContext) FileName() string {
+	return "history.html"
+}
+
+// DiffLine line of diff, Kind is one of "equal", "delete" or "insert"
+type DiffLine struct {
+	Kind      string
+	OldNum    int
+	NewNum    int
+	HTML      template.HTML // highlighted text of line
+	NoNewline bool          // line is the last one of text that doesn't end with newline
+}
+
+// DiffRow pair of lines shown side by side, nil for missing line
+type DiffRow struct {
+	Old *DiffLine
+	New *DiffLine
+}
+
+// DiffHunk group of changed lines with context
+type DiffHunk struct {
+	Header string
+	Lines  []DiffLine
+	Rows   []DiffRow // filled for side by side view
+}
+
+// DiffContext context for diff.html
+type DiffContext struct {
+	Title    string
+	FromName string
+	FromURL  string
+	ToName   string
+	ToURL    string
+	Hunks    []DiffHunk
+
+	Split      bool   // show changes side by side
+	UnifiedURL string // url of unified view of the same diff
+	SplitURL   string // url of side by side view of the same diff
+	RawURL     string // url of diff in unified format
+}
+
+// Name of the page
+func (c *DiffContext) FileName() string {
+	return "diff.html"
+}
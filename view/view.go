@@ -1,7 +1,9 @@
 package view
 
 import (
+	"crypto/sha256"
 	"embed"
+	"encoding/hex"
 	"github.com/vdimir/markify/util"
 	"html/template"
 	"io"
@@ -17,20 +19,44 @@ type HTMLPageView interface {
 	RenderPage(wr io.Writer, tplContext TemplateContext) error
 }
 
+// AssetURLFunc returns url of static file by its name, used as "asset" function in templates
+type AssetURLFunc func(name string) string
+
 // Render contains set of templates and pre-rendered pages
 type Render struct {
 	tpl *template.Template
 }
 
-func NewView(filePath string) (HTMLPageView, error) {
+// NewView loads templates from filePath or embedded ones if it's empty.
+// Static files are referenced as /public/<name> if assetURL is nil
+func NewView(filePath string, assetURL AssetURLFunc) (HTMLPageView, error) {
+	if assetURL == nil {
+		assetURL = func(name string) string {
+			return "/public/" + name
+		}
+	}
 	if filePath != "" {
-		return &DebugRender{os.DirFS(filePath)}, nil
+		return &DebugRender{FS: os.DirFS(filePath), assetURL: assetURL}, nil
 	}
 	subFs, err := fs.Sub(embeddedTemplateFS, "template")
 	if err != nil {
 		return nil, err
 	}
-	return newView(subFs)
+	return newView(subFs, assetURL)
+}
+
+// TemplatesVersion returns hash of embedded templates, it changes when pages look changes
+func TemplatesVersion() string {
+	hash := sha256.New()
+	err := util.WalkFiles(embeddedTemplateFS, "template", func(data []byte, filePath string) error {
+		hash.Write([]byte(filePath))
+		hash.Write(data)
+		return nil
+	})
+	if err != nil {
+		return ""
+	}
+	return hex.EncodeToString(hash.Sum(nil))
 }
 
 // RenderPage render page and writes data to wr
@@ -39,9 +65,9 @@ func (htmlRend *Render) RenderPage(wr io.Writer, tplContext TemplateContext) err
 	return err
 }
 
-func newView(fs fs.FS) (HTMLPageView, error) {
+func newView(fs fs.FS, assetURL AssetURLFunc) (HTMLPageView, error) {
 	htmlRend := &Render{
-		tpl: template.New("root"),
+		tpl: template.New("root").Funcs(template.FuncMap{"asset": assetURL}),
 	}
 
 	err := util.WalkFiles(fs, ".", func(data []byte, filePath string) error {
@@ -23,13 +23,18 @@ func TestHTMLTemplateRender(t *testing.T) {
 		checkRender(r, pageCtxs)
 	}
 
-	r, err := NewView("template")
+	r, err := NewView("template", nil)
 	require.NoError(t, err)
 
 	checkAllRender(r, []TemplateContext{
 		&EditorContext{},
 		&PageContext{},
 		&StatusContext{},
+		&URLPromptContext{},
+		&UserPastesContext{},
+		&RevealContext{},
+		&HistoryContext{},
+		&DiffContext{},
 	})
 
 	checkAllRender(r, []TemplateContext{
@@ -37,10 +42,19 @@ func TestHTMLTemplateRender(t *testing.T) {
 			Title:       "Title",
 			Msg:         "Msg",
 			InitialText: "InitialText",
+			Syntax:      "go",
+			Syntaxes:    []SyntaxOption{{Name: "go", Label: "Go"}, {Name: "python", Label: "Python"}},
 		},
 		&PageContext{
-			Title: "Title",
-			Body:  "<h1>Hello</h1>",
+			Title:     "Title",
+			Body:      "<h1>Hello</h1>",
+			DocID:     "abc",
+			Editable:  true,
+			EditToken: "token",
+
+			Syntax:         "go",
+			SyntaxDetected: true,
+			Syntaxes:       []SyntaxOption{{Name: "go", Label: "Go"}},
 			OgInfo: &OpenGraphInfo{
 				Title:       "ogtitle",
 				URL:         "http://markify.dev/foobar",
@@ -54,6 +68,71 @@ func TestHTMLTemplateRender(t *testing.T) {
 			HeaderMsg: "HeaderMsg",
 			Msg:       "Msg",
 		},
+		&UserPastesContext{
+			Title: "Title",
+			Pastes: []PasteInfo{
+				{DocID: "abc", Title: "Title", CreateTime: "Jan 2 15:04:05 2006 MST"},
+				{DocID: "def", CreateTime: "Jan 2 15:04:05 2006 MST", ExpireTime: "Jan 3 15:04:05 2006 MST"},
+			},
+		},
+		&PageContext{
+			Title:      "Title",
+			DocID:      "abc",
+			ForkedFrom: "def",
+			Forks:      []string{"ghi", "jkl"},
+			Forkable:   true,
+		},
+		&EditorContext{
+			Title:       "Title",
+			InitialText: "InitialText",
+			ForkedFrom:  "abc",
+		},
+		&PageContext{
+			Title:      "Title",
+			DocID:      "abc",
+			Ciphertext: `{"v":1,"iv":"AAECAwQFBgcICQoL","ct":"IyBzZWNyZXQ="}`,
+		},
+		&HistoryContext{
+			Title:     "Title",
+			DocID:     "abc",
+			Revisions: []RevisionInfo{{Number: 2, Time: "Jan 3 15:04:05 2006 MST"}, {Number: 1, Time: "Jan 2 15:04:05 2006 MST"}},
+		},
+		&DiffContext{
+			Title:    "Title",
+			FromName: "#1",
+			FromURL:  "/p/abc/v/1",
+			ToName:   "#2",
+			ToURL:    "/p/abc/v/2",
+			Hunks: []DiffHunk{{
+				Header: "@@ -1,2 +1,2 @@",
+				Lines: []DiffLine{
+					{Kind: "equal", OldNum: 1, NewNum: 1, HTML: "foo"},
+					{Kind: "delete", OldNum: 2, HTML: "bar"},
+					{Kind: "insert", NewNum: 2, HTML: "<span>baz</span>"},
+				},
+			}},
+			RawURL: "/api/v1/diff/abc/def",
+		},
+		&DiffContext{
+			Title:    "Title",
+			FromName: "abc",
+			ToName:   "def",
+			Split:    true,
+			Hunks: []DiffHunk{{
+				Header: "@@ -1 +1,2 @@",
+				Rows: []DiffRow{
+					{Old: &DiffLine{Kind: "delete", OldNum: 1, HTML: "bar"}, New: &DiffLine{Kind: "insert", NewNum: 1, HTML: "baz"}},
+					{New: &DiffLine{Kind: "insert", NewNum: 2, HTML: "qux"}},
+				},
+			}},
+		},
+		&RevealContext{
+			Title:       "Title",
+			Action:      "/p/abc",
+			Msg:         "Wrong password",
+			Burn:        true,
+			AskPassword: true,
+		},
 	})
 
 	checkRender(r, []TemplateContext{